@@ -0,0 +1,136 @@
+package acl_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/snapp-incubator/qsse/auth"
+	"github.com/snapp-incubator/qsse/auth/acl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const policy = `
+rules:
+  - name: drivers
+    roles: [driver]
+    allow: [ride.driver.>]
+    deny: [ride.driver.internal]
+  - name: admins
+    roles: [admin]
+    allow: [">"]
+  - principals: ["*"]
+    allow: [news.*]
+`
+
+func TestPolicy(t *testing.T) {
+	p, err := acl.ParsePolicy([]byte(policy))
+	require.NoError(t, err)
+
+	driver := &auth.Principal{ID: "42", Roles: []string{"driver"}}        //nolint:exhaustruct
+	admin := &auth.Principal{ID: "7", Roles: []string{"driver", "admin"}} //nolint:exhaustruct
+	passenger := &auth.Principal{ID: "13"}                                //nolint:exhaustruct
+
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		topic     string
+		decision  acl.Decision
+	}{
+		{"allowed by role", driver, "ride.driver.42", acl.Decision{Allowed: true, Rule: "drivers", Pattern: "ride.driver.>"}},
+		{"denied by role", driver, "ride.driver.internal", acl.Decision{Allowed: false, Rule: "drivers", Pattern: "ride.driver.internal"}},
+		{"deny wins", admin, "ride.driver.internal", acl.Decision{Allowed: false, Rule: "drivers", Pattern: "ride.driver.internal"}},
+		{"allowed by another role", admin, "ride.passenger.1", acl.Decision{Allowed: true, Rule: "admins", Pattern: ">"}},
+		{"allowed to anyone", passenger, "news.weather", acl.Decision{Allowed: true, Rule: "#2", Pattern: "news.*"}},
+		{"no rule", passenger, "ride.driver.42", acl.Decision{Allowed: false, Rule: "", Pattern: ""}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.decision, p.Explain(test.principal, test.topic))
+			assert.Equal(t, test.decision.Allowed, p.AuthorizePrincipal(test.principal, test.topic))
+		})
+	}
+
+	assert.Equal(t, `denied by "ride.driver.internal" of rule drivers`, p.Explain(admin, "ride.driver.internal").String())
+	assert.Equal(t, "denied: no rule allows the topic", p.Explain(passenger, "ride").String())
+}
+
+func TestPolicyAllowPrecedence(t *testing.T) {
+	p, err := acl.ParsePolicy([]byte(`{
+		"precedence": "allow",
+		"rules": [
+			{"name": "everyone", "principals": ["*"], "deny": ["ride.>"]},
+			{"name": "support", "principals": ["support"], "allow": ["ride.*.status"]}
+		]
+	}`))
+	require.NoError(t, err)
+
+	support := &auth.Principal{ID: "support"} //nolint:exhaustruct
+
+	assert.True(t, p.AuthorizePrincipal(support, "ride.42.status"))
+	assert.False(t, p.AuthorizePrincipal(support, "ride.42.location"))
+	assert.Equal(t, acl.Decision{Allowed: false, Rule: "everyone", Pattern: "ride.>"}, p.Explain(support, "ride.42.location"))
+}
+
+func TestParsePolicyInvalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"precedence":    "precedence: first",
+		"unknown field": "rules:\n  - roles: [admin]\n    alow: [\">\"]",
+		"no principals": "rules:\n  - allow: [\">\"]",
+		"pattern":       "rules:\n  - roles: [admin]\n    allow: [\"a..b\"]",
+		"json":          `{"rules": [{"roles": ["admin"], "allows": [">"]}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := acl.ParsePolicy([]byte(data))
+			require.ErrorIs(t, err, acl.ErrInvalidPolicy)
+		})
+	}
+
+	p, err := acl.ParsePolicy(nil)
+	require.NoError(t, err)
+	assert.False(t, p.AuthorizePrincipal(&auth.Principal{ID: "42"}, "topic")) //nolint:exhaustruct
+}
+
+func TestFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.yaml")
+
+	write := func(data string, modified time.Time) {
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+		require.NoError(t, os.Chtimes(path, modified, modified))
+	}
+
+	now := time.Now()
+
+	write("rules:\n  - principals: [\"42\"]\n    allow: [ride.*]\n", now.Add(-time.Hour))
+
+	reloads := make(chan error, 1)
+
+	file, err := acl.LoadFile(path, &acl.FileConfig{
+		CheckInterval: time.Nanosecond,
+		OnReload:      func(err error) { reloads <- err },
+	})
+	require.NoError(t, err)
+
+	principal := &auth.Principal{ID: "42"} //nolint:exhaustruct
+
+	assert.True(t, file.AuthorizePrincipal(principal, "ride.1"))
+	assert.False(t, file.AuthorizePrincipal(principal, "news.1"))
+
+	write("rules:\n  - principals: [\"42\"]\n    allow: [news.*]\n", now)
+
+	assert.True(t, file.AuthorizePrincipal(principal, "news.1"))
+	require.NoError(t, <-reloads)
+	assert.False(t, file.AuthorizePrincipal(principal, "ride.1"))
+	assert.Equal(t, uint64(2), file.Version())
+
+	// a broken file keeps the previous policy.
+	write("rules: [", now.Add(time.Hour))
+
+	assert.True(t, file.AuthorizePrincipal(principal, "news.1"))
+	require.ErrorIs(t, <-reloads, acl.ErrInvalidPolicy)
+	require.ErrorIs(t, file.Reload(), acl.ErrInvalidPolicy)
+	assert.Equal(t, "allowed by \"news.*\" of rule #0", file.Explain(principal, "news.1").String())
+}
@@ -0,0 +1,139 @@
+package acl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/snapp-incubator/qsse/auth"
+)
+
+// DefCheckInterval is the default interval of checking a policy file for changes.
+const DefCheckInterval = 10 * time.Second
+
+// FileConfig configures checking a policy file for changes.
+type FileConfig struct {
+	// CheckInterval is the interval of checking the file for changes, DefCheckInterval by default.
+	CheckInterval time.Duration
+	// OnReload is called after the changed file is loaded, err is the reason that the previous policy is kept.
+	OnReload func(err error)
+}
+
+// File is the policy of a YAML or JSON file. the file is checked for changes at most once per CheckInterval
+// when clients are authorized and the changed policy replaces the previous one at once. the previous policy
+// is kept when the changed file can't be read or parsed, e.g. while it is being written.
+type File struct {
+	path   string
+	config FileConfig
+
+	policy  atomic.Pointer[Policy]
+	checked atomic.Int64
+	// version counts the loaded policies, so auth.CachedAuthorizer forgets the decisions of the previous ones.
+	version atomic.Uint64
+
+	// lock serializes reloads, authorizing doesn't wait for them.
+	lock     sync.Mutex
+	modified time.Time
+	size     int64
+}
+
+// LoadFile loads the policy of the file at path. config can be nil for the defaults.
+func LoadFile(path string, config *FileConfig) (*File, error) {
+	file := &File{path: path, config: processFileConfig(config)} //nolint:exhaustruct
+
+	if err := file.Reload(); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func processFileConfig(config *FileConfig) FileConfig {
+	if config == nil {
+		config = new(FileConfig)
+	}
+
+	cfg := *config
+
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = DefCheckInterval
+	}
+
+	return cfg
+}
+
+// Reload loads the file even if it isn't changed, e.g. on SIGHUP.
+func (f *File) Reload() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.reload(true)
+}
+
+// Policy returns the current policy.
+func (f *File) Policy() *Policy {
+	if time.Since(time.Unix(0, f.checked.Load())) >= f.config.CheckInterval && f.lock.TryLock() {
+		defer f.lock.Unlock()
+
+		if err := f.reload(false); f.config.OnReload != nil && !errors.Is(err, errUnchanged) {
+			f.config.OnReload(err)
+		}
+	}
+
+	return f.policy.Load()
+}
+
+// AuthorizePrincipal reports whether the current policy allows principal on topic.
+func (f *File) AuthorizePrincipal(principal *auth.Principal, topic string) bool {
+	return f.Policy().AuthorizePrincipal(principal, topic)
+}
+
+// Version returns the number of loaded policies after checking the file for changes. it implements
+// auth.Versioned, so the decisions of auth.CachedAuthorizer are forgotten when the file is reloaded.
+func (f *File) Version() uint64 {
+	f.Policy()
+
+	return f.version.Load()
+}
+
+// Explain returns why the current policy allows or denies principal on topic, e.g. to debug a rejected topic.
+func (f *File) Explain(principal *auth.Principal, topic string) Decision {
+	return f.Policy().Explain(principal, topic)
+}
+
+// errUnchanged is returned by reload when the file isn't changed.
+var errUnchanged = errors.New("acl file is not changed")
+
+// reload loads the file when it is changed or forced. lock must be held.
+func (f *File) reload(force bool) error {
+	f.checked.Store(time.Now().UnixNano())
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to check acl file: %w", err)
+	}
+
+	if !force && info.ModTime().Equal(f.modified) && info.Size() == f.size {
+		return errUnchanged
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to read acl file: %w", err)
+	}
+
+	policy, err := ParsePolicy(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse acl file %s: %w", f.path, err)
+	}
+
+	f.policy.Store(policy)
+	f.version.Add(1)
+	f.modified = info.ModTime()
+	f.size = info.Size()
+
+	return nil
+}
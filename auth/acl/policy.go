@@ -0,0 +1,189 @@
+// Package acl authorizes principals by the rules of a policy file, which grant or deny the topics
+// matching topic patterns to principals and roles, instead of authorizers in code.
+package acl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/snapp-incubator/qsse/auth"
+	"github.com/snapp-incubator/qsse/internal"
+	"gopkg.in/yaml.v3"
+)
+
+// precedences of policies.
+const (
+	Deny  = "deny"
+	Allow = "allow"
+)
+
+// Anyone matches all the principals in the principals of a rule.
+const Anyone = "*"
+
+// ErrInvalidPolicy is returned when a policy can't be parsed or has an invalid rule.
+var ErrInvalidPolicy = errors.New("acl policy is not valid")
+
+// Policy is a list of rules. a topic is denied unless a rule of the principal allows it, and the rules
+// of Precedence win when the topic is both allowed and denied, e.g. a denied topic in an allowed pattern.
+//
+//	precedence: deny
+//	rules:
+//	  - name: drivers
+//	    roles: [driver]
+//	    allow: [ride.driver.>]
+//	    deny: [ride.driver.internal]
+//	  - name: monitoring
+//	    principals: [grafana]
+//	    allow: [">"]
+type Policy struct {
+	// Precedence is Deny or Allow, Deny by default.
+	Precedence string `json:"precedence" yaml:"precedence"`
+	Rules      []Rule `json:"rules"      yaml:"rules"`
+}
+
+// Rule grants or denies topic patterns to the principals with one of its IDs or roles. Anyone in
+// Principals applies the rule to every principal. the patterns follow the topic patterns of the server,
+// so "*" matches one segment and ">" matches the remaining segments.
+type Rule struct {
+	// Name identifies the rule in explanations, its index by default.
+	Name       string   `json:"name"       yaml:"name"`
+	Principals []string `json:"principals" yaml:"principals"`
+	Roles      []string `json:"roles"      yaml:"roles"`
+	Allow      []string `json:"allow"      yaml:"allow"`
+	Deny       []string `json:"deny"       yaml:"deny"`
+}
+
+// ParsePolicy parses a JSON policy when data is a JSON object and a YAML policy otherwise.
+// unknown fields are rejected, so a misspelled field doesn't silently grant or deny topics.
+func ParsePolicy(data []byte) (*Policy, error) {
+	var policy Policy
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		decoder := json.NewDecoder(bytes.NewReader(trimmed))
+		decoder.DisallowUnknownFields()
+
+		if err := decoder.Decode(&policy); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidPolicy, err)
+		}
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+
+		// an empty document is an empty policy which denies everything.
+		if err := decoder.Decode(&policy); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidPolicy, err)
+		}
+	}
+
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &policy, nil
+}
+
+// Validate checks the precedence and the rules and names the rules without a name.
+func (p *Policy) Validate() error {
+	switch p.Precedence {
+	case "":
+		p.Precedence = Deny
+	case Deny, Allow:
+	default:
+		return fmt.Errorf("%w: precedence %q is neither %s nor %s", ErrInvalidPolicy, p.Precedence, Deny, Allow)
+	}
+
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("#%d", i)
+		}
+
+		if len(rule.Principals) == 0 && len(rule.Roles) == 0 {
+			return fmt.Errorf("%w: rule %s has no principals or roles", ErrInvalidPolicy, rule.Name)
+		}
+
+		for _, pattern := range slices.Concat(rule.Allow, rule.Deny) {
+			if err := internal.ValidatePattern(pattern); err != nil {
+				return fmt.Errorf("%w: rule %s: %w", ErrInvalidPolicy, rule.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Decision is the explanation of authorizing a principal on a topic.
+type Decision struct {
+	Allowed bool
+	// Rule and Pattern are the rule and its pattern which decided, they are empty when no rule matches the topic.
+	Rule    string
+	Pattern string
+}
+
+func (d Decision) String() string {
+	switch {
+	case d.Rule == "":
+		return "denied: no rule allows the topic"
+	case d.Allowed:
+		return fmt.Sprintf("allowed by %q of rule %s", d.Pattern, d.Rule)
+	default:
+		return fmt.Sprintf("denied by %q of rule %s", d.Pattern, d.Rule)
+	}
+}
+
+// Explain returns why principal is allowed or denied on topic.
+func (p *Policy) Explain(principal *auth.Principal, topic string) Decision {
+	allowed, allowRule, allowPattern := p.match(principal, topic, func(rule *Rule) []string { return rule.Allow })
+	denied, denyRule, denyPattern := p.match(principal, topic, func(rule *Rule) []string { return rule.Deny })
+
+	switch {
+	case allowed && (!denied || p.Precedence == Allow):
+		return Decision{Allowed: true, Rule: allowRule, Pattern: allowPattern}
+	case denied:
+		return Decision{Allowed: false, Rule: denyRule, Pattern: denyPattern}
+	default:
+		return Decision{Allowed: false, Rule: "", Pattern: ""}
+	}
+}
+
+// AuthorizePrincipal reports whether a rule of principal allows topic.
+func (p *Policy) AuthorizePrincipal(principal *auth.Principal, topic string) bool {
+	return p.Explain(principal, topic).Allowed
+}
+
+// match returns the first rule of principal with a pattern of patterns matching topic.
+func (p *Policy) match(
+	principal *auth.Principal,
+	topic string,
+	patterns func(rule *Rule) []string,
+) (bool, string, string) {
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+
+		if !rule.applies(principal) {
+			continue
+		}
+
+		for _, pattern := range patterns(rule) {
+			if internal.MatchTopic(pattern, topic) {
+				return true, rule.Name, pattern
+			}
+		}
+	}
+
+	return false, "", ""
+}
+
+// applies reports whether the rule is of principal.
+func (r *Rule) applies(principal *auth.Principal) bool {
+	if slices.Contains(r.Principals, Anyone) || slices.Contains(r.Principals, principal.ID) {
+		return true
+	}
+
+	return slices.ContainsFunc(r.Roles, principal.HasRole)
+}
@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// results of CachedAuthorizer lookups.
+const (
+	CacheHit  = "hit"
+	CacheMiss = "miss"
+	// CacheShared is the result of a lookup which waited for the same check of another one.
+	CacheShared = "shared"
+)
+
+// defaults of CacheConfig.
+const (
+	DefCacheTTL  = time.Minute
+	DefCacheSize = 10000
+)
+
+// CacheConfig configures CachedAuthorizer.
+type CacheConfig struct {
+	// TTL is how long allowed topics are cached, DefCacheTTL by default.
+	TTL time.Duration
+	// NegativeTTL is how long denied topics are cached, zero doesn't cache them.
+	NegativeTTL time.Duration
+	// Size is the maximum number of cached decisions, the least recently used ones are removed first.
+	// DefCacheSize by default.
+	Size int
+	// OnLookup is called with the result of each lookup: CacheHit, CacheMiss or CacheShared, e.g. to count them.
+	OnLookup func(result string)
+}
+
+// Versioned is implemented by authorizers whose decisions change at runtime, e.g. by reloading a policy.
+// Version increases when the decisions change, it is called on each lookup of CachedAuthorizer and can
+// check for the changes.
+type Versioned interface {
+	Version() uint64
+}
+
+// CachedAuthorizer caches the decisions of an authorizer per principal and topic, so reconnecting clients
+// don't check the same topics again. the principals are the same when their ID, token, roles and claims are.
+// the concurrent checks of the same topic are done once. the decisions are forgotten when the version of
+// a Versioned authorizer changes.
+type CachedAuthorizer struct {
+	authorizer PrincipalAuthorizer
+	config     CacheConfig
+
+	lock    sync.Mutex
+	version uint64
+	entries map[string]*list.Element
+	// order has the entries from the most recently used.
+	order *list.List
+	// group is replaced on purge, so the checks which were in flight aren't cached.
+	group *singleflight.Group
+}
+
+type cacheEntry struct {
+	key     string
+	allowed bool
+	expires time.Time
+}
+
+// NewCachedAuthorizer returns a cache of the decisions of authorizer. config can be nil for the defaults.
+func NewCachedAuthorizer(authorizer PrincipalAuthorizer, config *CacheConfig) *CachedAuthorizer {
+	return &CachedAuthorizer{
+		authorizer: authorizer,
+		config:     processCacheConfig(config),
+		lock:       sync.Mutex{},
+		version:    0,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		group:      new(singleflight.Group),
+	}
+}
+
+func processCacheConfig(config *CacheConfig) CacheConfig {
+	if config == nil {
+		config = new(CacheConfig)
+	}
+
+	cfg := *config
+
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefCacheTTL
+	}
+
+	if cfg.Size <= 0 {
+		cfg.Size = DefCacheSize
+	}
+
+	return cfg
+}
+
+// cacheKey encodes principal and topic as JSON, which quotes the strings and writes the maps in
+// the order of their keys, so only the same principals and topics have the same key.
+func cacheKey(principal *Principal, topic string) (string, error) {
+	key, err := json.Marshal(struct {
+		ID     string         `json:"id"`
+		Token  string         `json:"token"`
+		Roles  []string       `json:"roles"`
+		Claims map[string]any `json:"claims"`
+		Topic  string         `json:"topic"`
+	}{
+		ID:     principal.ID,
+		Token:  principal.Token,
+		Roles:  principal.Roles,
+		Claims: principal.Claims,
+		Topic:  topic,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(key), nil
+}
+
+// AuthorizePrincipal returns the cached decision of principal on topic or authorizes it by the authorizer.
+func (c *CachedAuthorizer) AuthorizePrincipal(principal *Principal, topic string) bool {
+	allowed, result := c.Lookup(principal, topic)
+
+	if c.config.OnLookup != nil {
+		c.config.OnLookup(result)
+	}
+
+	return allowed
+}
+
+// Lookup returns the decision of AuthorizePrincipal and the result of the lookup: CacheHit, CacheMiss or
+// CacheShared, without calling OnLookup.
+func (c *CachedAuthorizer) Lookup(principal *Principal, topic string) (bool, string) {
+	key, err := cacheKey(principal, topic)
+	if err != nil {
+		// the decisions of principals whose claims can't be encoded aren't cached.
+		return c.authorizer.AuthorizePrincipal(principal, topic), CacheMiss
+	}
+
+	// the version is checked outside the lock, because it can check for the changes.
+	var version uint64
+	if versioned, ok := c.authorizer.(Versioned); ok {
+		version = versioned.Version()
+	}
+
+	c.lock.Lock()
+
+	if version > c.version {
+		c.purge()
+		c.version = version
+	}
+
+	if allowed, ok := c.get(key); ok {
+		c.lock.Unlock()
+
+		return allowed, CacheHit
+	}
+
+	group := c.group
+
+	c.lock.Unlock()
+
+	checked, cached := false, false
+
+	value, _, _ := group.Do(key, func() (any, error) {
+		// the previous check may have finished after the lookup.
+		c.lock.Lock()
+		allowed, ok := c.get(key)
+		c.lock.Unlock()
+
+		if ok {
+			cached = true
+
+			return allowed, nil
+		}
+
+		checked = true
+		allowed = c.authorizer.AuthorizePrincipal(principal, topic)
+
+		c.lock.Lock()
+		defer c.lock.Unlock()
+
+		if c.group == group {
+			c.set(key, allowed)
+		}
+
+		return allowed, nil
+	})
+
+	switch {
+	case checked:
+		return value.(bool), CacheMiss //nolint:forcetypeassert
+	case cached:
+		return value.(bool), CacheHit //nolint:forcetypeassert
+	default:
+		return value.(bool), CacheShared //nolint:forcetypeassert
+	}
+}
+
+// Purge removes all the decisions, e.g. when the permissions of the authorizer change.
+func (c *CachedAuthorizer) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.purge()
+}
+
+// Len returns the number of cached decisions, including the expired ones which aren't removed yet.
+func (c *CachedAuthorizer) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.order.Len()
+}
+
+// purge removes all the decisions. lock must be held.
+func (c *CachedAuthorizer) purge() {
+	clear(c.entries)
+	c.order.Init()
+	c.group = new(singleflight.Group)
+}
+
+// get returns the decision of key when it isn't expired. lock must be held.
+func (c *CachedAuthorizer) get(key string) (bool, bool) {
+	element, ok := c.entries[key]
+	if !ok {
+		return false, false
+	}
+
+	entry := element.Value.(*cacheEntry) //nolint:forcetypeassert
+	if !time.Now().Before(entry.expires) {
+		c.remove(element)
+
+		return false, false
+	}
+
+	c.order.MoveToFront(element)
+
+	return entry.allowed, true
+}
+
+// set caches the decision of key and removes the least recently used decision when the cache is full.
+// lock must be held.
+func (c *CachedAuthorizer) set(key string, allowed bool) {
+	ttl := c.config.TTL
+	if !allowed {
+		ttl = c.config.NegativeTTL
+	}
+
+	if ttl <= 0 {
+		return
+	}
+
+	entry := &cacheEntry{key: key, allowed: allowed, expires: time.Now().Add(ttl)}
+
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+
+	if c.order.Len() > c.config.Size {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *CachedAuthorizer) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).key) //nolint:forcetypeassert
+}
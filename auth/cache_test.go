@@ -0,0 +1,213 @@
+package auth_test
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/snapp-incubator/qsse/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingAuthorizer allows the topics of allowed and counts the checks.
+func countingAuthorizer(checks *atomic.Int32, allowed ...string) auth.PrincipalAuthorizer {
+	return auth.PrincipalAuthorizerFunc(func(_ *auth.Principal, topic string) bool {
+		checks.Add(1)
+
+		return slices.Contains(allowed, topic)
+	})
+}
+
+func TestCachedAuthorizer(t *testing.T) {
+	var checks atomic.Int32
+
+	authorizer := countingAuthorizer(&checks, "people")
+	principal := &auth.Principal{ID: "42", Token: "token"} //nolint:exhaustruct
+
+	cache := auth.NewCachedAuthorizer(authorizer, &auth.CacheConfig{
+		TTL:         time.Minute,
+		NegativeTTL: 50 * time.Millisecond,
+		Size:        10,
+		OnLookup:    nil,
+	})
+
+	allowed, result := cache.Lookup(principal, "people")
+	assert.True(t, allowed)
+	assert.Equal(t, auth.CacheMiss, result)
+
+	allowed, result = cache.Lookup(principal, "people")
+	assert.True(t, allowed)
+	assert.Equal(t, auth.CacheHit, result)
+
+	// denied topics are cached for the negative TTL.
+	allowed, result = cache.Lookup(principal, "cars")
+	assert.False(t, allowed)
+	assert.Equal(t, auth.CacheMiss, result)
+
+	_, result = cache.Lookup(principal, "cars")
+	assert.Equal(t, auth.CacheHit, result)
+
+	time.Sleep(60 * time.Millisecond)
+
+	_, result = cache.Lookup(principal, "cars")
+	assert.Equal(t, auth.CacheMiss, result)
+
+	// another token of the principal is checked again.
+	_, result = cache.Lookup(&auth.Principal{ID: "42", Token: "refreshed"}, "people") //nolint:exhaustruct
+	assert.Equal(t, auth.CacheMiss, result)
+
+	// the roles and claims of the principal can change under the same token.
+	_, result = cache.Lookup(&auth.Principal{ID: "42", Token: "token", Roles: []string{"admin"}}, "people") //nolint:exhaustruct
+	assert.Equal(t, auth.CacheMiss, result)
+
+	_, result = cache.Lookup(&auth.Principal{ID: "42", Token: "token", Claims: map[string]any{"city": 1}}, "people") //nolint:exhaustruct
+	assert.Equal(t, auth.CacheMiss, result)
+
+	// the claims which would print the same are told apart.
+	_, result = cache.Lookup(&auth.Principal{ID: "42", Claims: map[string]any{"a": "b c:d"}}, "people") //nolint:exhaustruct
+	assert.Equal(t, auth.CacheMiss, result)
+
+	_, result = cache.Lookup(&auth.Principal{ID: "42", Claims: map[string]any{"a": "b", "c": "d"}}, "people") //nolint:exhaustruct
+	assert.Equal(t, auth.CacheMiss, result)
+
+	// the claims which can't be encoded are not cached.
+	_, result = cache.Lookup(&auth.Principal{ID: "42", Claims: map[string]any{"f": func() {}}}, "people") //nolint:exhaustruct
+	assert.Equal(t, auth.CacheMiss, result)
+
+	assert.Equal(t, int32(9), checks.Load())
+
+	cache.Purge()
+	assert.Zero(t, cache.Len())
+
+	_, result = cache.Lookup(principal, "people")
+	assert.Equal(t, auth.CacheMiss, result)
+}
+
+func TestCachedAuthorizerWithoutNegativeTTL(t *testing.T) {
+	var checks atomic.Int32
+
+	authorizer := countingAuthorizer(&checks)
+	principal := &auth.Principal{ID: "42"} //nolint:exhaustruct
+
+	cache := auth.NewCachedAuthorizer(authorizer, &auth.CacheConfig{TTL: time.Minute, NegativeTTL: 0, Size: 10, OnLookup: nil})
+
+	for range 3 {
+		_, result := cache.Lookup(principal, "cars")
+		assert.Equal(t, auth.CacheMiss, result)
+	}
+
+	assert.Equal(t, int32(3), checks.Load())
+}
+
+func TestCachedAuthorizerSize(t *testing.T) {
+	var checks atomic.Int32
+
+	authorizer := countingAuthorizer(&checks, "a", "b", "c")
+	principal := &auth.Principal{ID: "42"} //nolint:exhaustruct
+
+	cache := auth.NewCachedAuthorizer(authorizer, &auth.CacheConfig{TTL: time.Minute, NegativeTTL: time.Minute, Size: 2, OnLookup: nil})
+
+	cache.Lookup(principal, "a")
+	cache.Lookup(principal, "b")
+	// a is used recently, so b is removed for c.
+	cache.Lookup(principal, "a")
+	cache.Lookup(principal, "c")
+
+	assert.Equal(t, 2, cache.Len())
+
+	_, result := cache.Lookup(principal, "a")
+	assert.Equal(t, auth.CacheHit, result)
+
+	_, result = cache.Lookup(principal, "b")
+	assert.Equal(t, auth.CacheMiss, result)
+}
+
+func TestCachedAuthorizerSingleflight(t *testing.T) {
+	var checks atomic.Int32
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+
+	authorizer := auth.PrincipalAuthorizerFunc(func(_ *auth.Principal, _ string) bool {
+		checks.Add(1)
+		started <- struct{}{}
+		<-release
+
+		return true
+	})
+
+	principal := &auth.Principal{ID: "42"} //nolint:exhaustruct
+	cache := auth.NewCachedAuthorizer(authorizer, &auth.CacheConfig{TTL: time.Minute, NegativeTTL: time.Minute, Size: 10, OnLookup: nil})
+
+	const callers = 10
+
+	var (
+		wg      sync.WaitGroup
+		lock    sync.Mutex
+		results = make(map[string]int)
+	)
+
+	wg.Add(callers)
+
+	for range callers {
+		go func() {
+			defer wg.Done()
+
+			allowed, result := cache.Lookup(principal, "people")
+			assert.True(t, allowed)
+
+			lock.Lock()
+			results[result]++
+			lock.Unlock()
+		}()
+	}
+
+	<-started
+	// the other callers wait for the started check or find its decision afterward.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), checks.Load())
+	assert.Equal(t, 1, results[auth.CacheMiss])
+	assert.Equal(t, callers-1, results[auth.CacheShared]+results[auth.CacheHit])
+}
+
+// versionedAuthorizer allows the topics of its current version.
+type versionedAuthorizer struct {
+	version atomic.Uint64
+	allowed []string
+}
+
+func (a *versionedAuthorizer) AuthorizePrincipal(_ *auth.Principal, topic string) bool {
+	return slices.Contains(a.allowed[a.version.Load():], topic)
+}
+
+func (a *versionedAuthorizer) Version() uint64 {
+	return a.version.Load()
+}
+
+func TestCachedAuthorizerVersion(t *testing.T) {
+	authorizer := &versionedAuthorizer{version: atomic.Uint64{}, allowed: []string{"people", "cars"}}
+	principal := &auth.Principal{ID: "42"} //nolint:exhaustruct
+
+	results := make([]string, 0, 3)
+
+	cache := auth.NewCachedAuthorizer(authorizer, &auth.CacheConfig{
+		TTL:         time.Minute,
+		NegativeTTL: time.Minute,
+		Size:        10,
+		OnLookup:    func(result string) { results = append(results, result) },
+	})
+
+	assert.True(t, cache.AuthorizePrincipal(principal, "people"))
+	assert.True(t, cache.AuthorizePrincipal(principal, "people"))
+
+	// the decisions of the previous version are forgotten.
+	authorizer.version.Store(1)
+
+	assert.False(t, cache.AuthorizePrincipal(principal, "people"))
+	assert.Equal(t, []string{auth.CacheMiss, auth.CacheHit, auth.CacheMiss}, results)
+}
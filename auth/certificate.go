@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+)
+
+// ErrNoCertificate is wrapped by the error of CertificateAuthenticator when the client has no verified certificate.
+var ErrNoCertificate = errors.New("client has no verified certificate")
+
+// CertificateAuthenticator authenticates clients of mutual TLS by their certificate which is verified
+// in the TLS handshake. the principal ID is returned by identity, CertificateIdentity when it is nil.
+// the roles are the organizational units of the subject and the principal expires with the certificate.
+func CertificateAuthenticator(identity func(cert *x509.Certificate) string) ConnAuthenticator {
+	if identity == nil {
+		identity = CertificateIdentity
+	}
+
+	return ConnAuthenticatorFunc(func(_ context.Context, info *ConnInfo) (*Principal, error) {
+		if len(info.VerifiedChains) == 0 || len(info.PeerCertificates) == 0 {
+			return nil, NewError("no certificate", ErrNoCertificate)
+		}
+
+		cert := info.PeerCertificates[0]
+
+		id := identity(cert)
+		if id == "" {
+			return nil, NewError("certificate has no identity", nil)
+		}
+
+		uris := make([]string, 0, len(cert.URIs))
+		for _, uri := range cert.URIs {
+			uris = append(uris, uri.String())
+		}
+
+		return &Principal{
+			ID:    id,
+			Roles: cert.Subject.OrganizationalUnit,
+			Claims: map[string]any{
+				"subject":   cert.Subject.String(),
+				"dns_names": cert.DNSNames,
+				"emails":    cert.EmailAddresses,
+				"uris":      uris,
+			},
+			ExpiresAt: cert.NotAfter,
+			Token:     info.Token,
+		}, nil
+	})
+}
+
+// CertificateIdentity returns the first URI SAN of cert, e.g. a SPIFFE ID, or its first DNS
+// SAN, or its first email SAN, or the common name of its subject when it has no SAN.
+func CertificateIdentity(cert *x509.Certificate) string {
+	switch {
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	default:
+		return cert.Subject.CommonName
+	}
+}
+
+// CommonName returns the common name of the subject of cert.
+func CommonName(cert *x509.Certificate) string {
+	return cert.Subject.CommonName
+}
+
+// Any authenticates clients by the first authenticator which authenticates them, e.g. by the certificate
+// of backend clients or the token of the others. it returns the error of the last authenticator otherwise.
+func Any(authenticators ...ConnAuthenticator) ConnAuthenticator {
+	return ConnAuthenticatorFunc(func(ctx context.Context, info *ConnInfo) (*Principal, error) {
+		var err error = NewError("no authenticator", nil)
+
+		for _, authenticator := range authenticators {
+			var principal *Principal
+
+			principal, err = authenticator.AuthenticateConn(ctx, info)
+			if err == nil && principal != nil {
+				return principal, nil
+			}
+		}
+
+		return nil, err
+	})
+}
@@ -0,0 +1,70 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/snapp-incubator/qsse/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertificateIdentity(t *testing.T) {
+	spiffe, err := url.Parse("spiffe://snapp/billing")
+	require.NoError(t, err)
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}} //nolint:exhaustruct
+	assert.Equal(t, "billing", auth.CertificateIdentity(cert))
+
+	cert.DNSNames = []string{"billing.snapp"}
+	assert.Equal(t, "billing.snapp", auth.CertificateIdentity(cert))
+
+	cert.URIs = []*url.URL{spiffe}
+	assert.Equal(t, "spiffe://snapp/billing", auth.CertificateIdentity(cert))
+	assert.Equal(t, "billing", auth.CommonName(cert))
+}
+
+func TestCertificateAuthenticator(t *testing.T) {
+	cert := &x509.Certificate{ //nolint:exhaustruct
+		Subject:  pkix.Name{CommonName: "billing", OrganizationalUnit: []string{"backend"}}, //nolint:exhaustruct
+		NotAfter: time.Unix(1_700_000_000, 0),
+	}
+
+	authenticator := auth.CertificateAuthenticator(nil)
+
+	principal, err := authenticator.AuthenticateConn(context.Background(), &auth.ConnInfo{ //nolint:exhaustruct
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "billing", principal.ID)
+	assert.Equal(t, []string{"backend"}, principal.Roles)
+	assert.Equal(t, cert.NotAfter, principal.ExpiresAt)
+
+	// certificates which are not verified are not trusted.
+	_, err = authenticator.AuthenticateConn(context.Background(), &auth.ConnInfo{ //nolint:exhaustruct
+		PeerCertificates: []*x509.Certificate{cert},
+	})
+	require.ErrorIs(t, err, auth.ErrNoCertificate)
+}
+
+func TestAny(t *testing.T) {
+	token := auth.TokenAuthenticator(auth.AuthenticatorFunc(func(token string) bool { return token == "secret" }))
+	authenticator := auth.Any(auth.CertificateAuthenticator(nil), token)
+
+	principal, err := authenticator.AuthenticateConn(context.Background(), &auth.ConnInfo{Token: "secret"}) //nolint:exhaustruct
+	require.NoError(t, err)
+	assert.Equal(t, "secret", principal.Token)
+
+	_, err = authenticator.AuthenticateConn(context.Background(), &auth.ConnInfo{Token: "wrong"}) //nolint:exhaustruct
+	require.ErrorIs(t, err, auth.ErrNotAuthenticated)
+
+	var authErr *auth.Error
+	require.ErrorAs(t, err, &authErr)
+	assert.Equal(t, "invalid token", authErr.Reason)
+}
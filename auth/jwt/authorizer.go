@@ -0,0 +1,43 @@
+package jwt
+
+import (
+	"github.com/snapp-incubator/qsse/auth"
+	"github.com/snapp-incubator/qsse/internal"
+)
+
+// Authorizer grants clients the topics matching the topic patterns of the topics claim of their token,
+// e.g. {"topics": ["ride.passenger.42", "ride.*.status"]}. topics are matched like the topic patterns
+// of the server, so "*" matches one segment and ">" matches the remaining segments.
+type Authorizer struct {
+	authenticator *Authenticator
+}
+
+// Authorizer returns the authorizer of the topics claim of the authenticated tokens.
+func (a *Authenticator) Authorizer() *Authorizer {
+	return &Authorizer{authenticator: a}
+}
+
+// AuthorizePrincipal authorizes principal by its claims, so the token isn't verified again.
+func (a *Authorizer) AuthorizePrincipal(principal *auth.Principal, topic string) bool {
+	return a.grants(principal.Claims, topic)
+}
+
+// Authorize verifies token and authorizes it by its claims.
+func (a *Authorizer) Authorize(token, topic string) bool {
+	claims, err := a.authenticator.Verify(token)
+	if err != nil {
+		return false
+	}
+
+	return a.grants(claims, topic)
+}
+
+func (a *Authorizer) grants(claims map[string]any, topic string) bool {
+	for _, pattern := range stringsClaim(claims, a.authenticator.config.TopicsClaim) {
+		if internal.MatchTopic(pattern, topic) {
+			return true
+		}
+	}
+
+	return false
+}
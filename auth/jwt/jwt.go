@@ -0,0 +1,363 @@
+// Package jwt authenticates clients by JSON web tokens and authorizes them by the topic patterns of a claim.
+// it supports the HS256, HS384, HS512, RS256, RS384, RS512, ES256, ES384 and ES512 algorithms.
+package jwt
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/snapp-incubator/qsse/auth"
+)
+
+const (
+	DefTopicsClaim = "topics"
+	DefRolesClaim  = "roles"
+)
+
+var (
+	ErrMalformed            = errors.New("token is malformed")
+	ErrUnsupportedAlgorithm = errors.New("algorithm is not supported")
+	ErrInvalidSignature     = errors.New("signature is invalid")
+	ErrUnknownKey           = errors.New("key is not found")
+	ErrExpired              = errors.New("token is expired")
+	ErrNotValidYet          = errors.New("token is not valid yet")
+	ErrInvalidIssuer        = errors.New("issuer is not valid")
+	ErrInvalidAudience      = errors.New("audience is not valid")
+)
+
+// Config configures the validation of tokens.
+type Config struct {
+	// Issuer is the required iss claim, any issuer is accepted when it is empty.
+	Issuer string
+	// Audience is the audience that the aud claim must have, any audience is accepted when it is empty.
+	Audience string
+	// Algorithms are the accepted algorithms, all the supported algorithms by default.
+	Algorithms []string
+	// Leeway is the accepted clock skew of exp and nbf, the principals expire Leeway after exp.
+	Leeway time.Duration
+	// RolesClaim is the claim of principal roles, "roles" by default.
+	RolesClaim string
+	// TopicsClaim is the claim of topic patterns that Authorizer grants, "topics" by default.
+	TopicsClaim string
+	// Now returns the current time, time.Now by default.
+	Now func() time.Time
+}
+
+// Authenticator authenticates clients by the JWT that they send as their token.
+// the subject of the token is the principal ID and its claims are the principal claims.
+type Authenticator struct {
+	keys   KeySet
+	config Config
+}
+
+// NewAuthenticator returns an authenticator which verifies the signature of tokens by keys.
+func NewAuthenticator(keys KeySet, config *Config) *Authenticator {
+	return &Authenticator{keys: keys, config: processConfig(config)}
+}
+
+func processConfig(config *Config) Config {
+	if config == nil {
+		config = new(Config)
+	}
+
+	cfg := *config
+
+	if cfg.Algorithms == nil {
+		cfg.Algorithms = slices.Collect(maps.Keys(algorithms))
+	}
+
+	if cfg.RolesClaim == "" {
+		cfg.RolesClaim = DefRolesClaim
+	}
+
+	if cfg.TopicsClaim == "" {
+		cfg.TopicsClaim = DefTopicsClaim
+	}
+
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+
+	return cfg
+}
+
+// AuthenticateConn verifies the token of the client and returns its principal. the errors are
+// *auth.Error with the reason, e.g. "token is expired", which wraps the cause.
+func (a *Authenticator) AuthenticateConn(_ context.Context, info *auth.ConnInfo) (*auth.Principal, error) {
+	claims, err := a.Verify(info.Token)
+	if err != nil {
+		return nil, auth.NewError(reason(err), err)
+	}
+
+	principal := &auth.Principal{
+		ID:        stringClaim(claims, "sub"),
+		Roles:     stringsClaim(claims, a.config.RolesClaim),
+		Claims:    claims,
+		ExpiresAt: time.Time{},
+		Token:     info.Token,
+	}
+
+	// the server checks the expiry of the principal too, so it has the same leeway.
+	if exp, ok, _ := numericClaim(claims, "exp"); ok {
+		principal.ExpiresAt = exp.Add(a.config.Leeway)
+	}
+
+	return principal, nil
+}
+
+// reason returns the reason of a verification error which is sent to the client.
+func reason(err error) string {
+	for _, target := range []error{
+		ErrMalformed, ErrUnsupportedAlgorithm, ErrInvalidSignature, ErrUnknownKey,
+		ErrExpired, ErrNotValidYet, ErrInvalidIssuer, ErrInvalidAudience,
+	} {
+		if errors.Is(err, target) {
+			return target.Error()
+		}
+	}
+
+	return "token is not valid"
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// Verify verifies the signature and the registered claims of token and returns its claims.
+// the numbers of claims are json.Number.
+func (a *Authenticator) Verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 { //nolint:mnd
+		return nil, fmt.Errorf("%w: it doesn't have 3 parts", ErrMalformed)
+	}
+
+	var h header
+	if err := decode(parts[0], &h); err != nil {
+		return nil, fmt.Errorf("%w: header: %w", ErrMalformed, err)
+	}
+
+	verify, ok := algorithms[h.Algorithm]
+	if !ok || !slices.Contains(a.config.Algorithms, h.Algorithm) {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, h.Algorithm)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %w", ErrMalformed, err)
+	}
+
+	key, err := a.keys.Key(h.KeyID, h.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verify(key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := decode(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %w", ErrMalformed, err)
+	}
+
+	if err := a.validate(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// validate validates the exp, nbf, iss and aud claims.
+func (a *Authenticator) validate(claims map[string]any) error {
+	now := a.config.Now()
+
+	exp, ok, err := numericClaim(claims, "exp")
+	if err != nil {
+		return err
+	}
+
+	if ok && !now.Before(exp.Add(a.config.Leeway)) {
+		return ErrExpired
+	}
+
+	nbf, ok, err := numericClaim(claims, "nbf")
+	if err != nil {
+		return err
+	}
+
+	if ok && now.Add(a.config.Leeway).Before(nbf) {
+		return ErrNotValidYet
+	}
+
+	if a.config.Issuer != "" && stringClaim(claims, "iss") != a.config.Issuer {
+		return ErrInvalidIssuer
+	}
+
+	if a.config.Audience != "" && !slices.Contains(stringsClaim(claims, "aud"), a.config.Audience) {
+		return ErrInvalidAudience
+	}
+
+	return nil
+}
+
+func decode(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	return decoder.Decode(v)
+}
+
+func stringClaim(claims map[string]any, name string) string {
+	value, _ := claims[name].(string)
+
+	return value
+}
+
+// stringsClaim returns a claim which is a string or an array of strings.
+func stringsClaim(claims map[string]any, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []any:
+		values := make([]string, 0, len(value))
+
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+
+		return values
+	default:
+		return nil
+	}
+}
+
+// maxNumericDate is the latest seconds since epoch of a numeric claim, the end of year 9999.
+const maxNumericDate = 253402300799
+
+// numericClaim returns a claim which is seconds since epoch and reports whether it is present.
+// it fails with ErrMalformed when the claim isn't a number or is out of range.
+func numericClaim(claims map[string]any, name string) (time.Time, bool, error) {
+	value, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	number, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("%w: %s is not a number", ErrMalformed, name)
+	}
+
+	seconds, err := number.Float64()
+	if err != nil || math.Abs(seconds) > maxNumericDate {
+		return time.Time{}, false, fmt.Errorf("%w: %s is out of range", ErrMalformed, name)
+	}
+
+	if integer, err := number.Int64(); err == nil {
+		return time.Unix(integer, 0), true, nil
+	}
+
+	// the seconds are split, so the nanoseconds since epoch don't overflow after 2262.
+	integer, fraction := math.Modf(seconds)
+
+	return time.Unix(int64(integer), int64(fraction*float64(time.Second))), true, nil
+}
+
+// algorithms verify the signature of the signed parts of a token by key.
+//
+//nolint:gochecknoglobals
+var algorithms = map[string]func(key any, signed, signature []byte) error{
+	"HS256": verifyHMAC(crypto.SHA256),
+	"HS384": verifyHMAC(crypto.SHA384),
+	"HS512": verifyHMAC(crypto.SHA512),
+	"RS256": verifyRSA(crypto.SHA256),
+	"RS384": verifyRSA(crypto.SHA384),
+	"RS512": verifyRSA(crypto.SHA512),
+	"ES256": verifyECDSA(crypto.SHA256, "P-256"),
+	"ES384": verifyECDSA(crypto.SHA384, "P-384"),
+	"ES512": verifyECDSA(crypto.SHA512, "P-521"),
+}
+
+func verifyHMAC(hash crypto.Hash) func(key any, signed, signature []byte) error {
+	return func(key any, signed, signature []byte) error {
+		secret, ok := key.([]byte)
+		if !ok {
+			return fmt.Errorf("%w: HMAC needs a secret", ErrUnknownKey)
+		}
+
+		mac := hmac.New(hash.New, secret)
+		mac.Write(signed)
+
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrInvalidSignature
+		}
+
+		return nil
+	}
+}
+
+func verifyRSA(hash crypto.Hash) func(key any, signed, signature []byte) error {
+	return func(key any, signed, signature []byte) error {
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: RSA needs an RSA public key", ErrUnknownKey)
+		}
+
+		digest := hash.New()
+		digest.Write(signed)
+
+		if err := rsa.VerifyPKCS1v15(publicKey, hash, digest.Sum(nil), signature); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+		}
+
+		return nil
+	}
+}
+
+func verifyECDSA(hash crypto.Hash, curve string) func(key any, signed, signature []byte) error {
+	return func(key any, signed, signature []byte) error {
+		publicKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || publicKey.Curve.Params().Name != curve {
+			return fmt.Errorf("%w: ECDSA needs a %s public key", ErrUnknownKey, curve)
+		}
+
+		// the signature is r and s of the key size each.
+		size := (publicKey.Curve.Params().BitSize + 7) / 8 //nolint:mnd
+		if len(signature) != 2*size {
+			return ErrInvalidSignature
+		}
+
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+
+		digest := hash.New()
+		digest.Write(signed)
+
+		if !ecdsa.Verify(publicKey, digest.Sum(nil), r, s) {
+			return ErrInvalidSignature
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,270 @@
+package jwt_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/snapp-incubator/qsse/auth"
+	"github.com/snapp-incubator/qsse/auth/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sign returns a token of claims signed by key with alg of HS256, RS256 or ES256.
+func sign(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	t.Helper()
+
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	require.NoError(t, err)
+
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+
+	switch alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, key.([]byte)) //nolint:forcetypeassert
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case "RS256":
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:]) //nolint:forcetypeassert
+		require.NoError(t, err)
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), digest[:]) //nolint:forcetypeassert
+		require.NoError(t, err)
+
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	default:
+		signature = []byte("signature")
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestAuthenticatorClaims(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Unix(1_700_000_000, 0)
+
+	authenticator := jwt.NewAuthenticator(jwt.Secret(secret), &jwt.Config{ //nolint:exhaustruct
+		Issuer:   "qsse",
+		Audience: "events",
+		Leeway:   time.Minute,
+		Now:      func() time.Time { return now },
+	})
+
+	valid := map[string]any{"sub": "42", "iss": "qsse", "aud": []string{"events", "api"}, "exp": now.Unix() + 60}
+
+	tests := []struct {
+		name   string
+		change map[string]any
+		err    error
+	}{
+		{name: "valid", change: nil, err: nil},
+		{name: "expired", change: map[string]any{"exp": now.Unix() - 120}, err: jwt.ErrExpired},
+		{name: "expired in leeway", change: map[string]any{"exp": now.Unix() - 30}, err: nil},
+		{name: "fractional expiry", change: map[string]any{"exp": float64(now.Unix()) - 120.5}, err: jwt.ErrExpired},
+		{name: "far expiry", change: map[string]any{"exp": 9999999999}, err: nil},
+		{name: "string expiry", change: map[string]any{"exp": "1"}, err: jwt.ErrMalformed},
+		{name: "boolean expiry", change: map[string]any{"exp": true}, err: jwt.ErrMalformed},
+		{name: "null not before", change: map[string]any{"nbf": nil}, err: jwt.ErrMalformed},
+		{name: "out of range expiry", change: map[string]any{"exp": 1e300}, err: jwt.ErrMalformed},
+		{name: "not valid yet", change: map[string]any{"nbf": now.Unix() + 120}, err: jwt.ErrNotValidYet},
+		{name: "not valid yet in leeway", change: map[string]any{"nbf": now.Unix() + 30}, err: nil},
+		{name: "issuer", change: map[string]any{"iss": "other"}, err: jwt.ErrInvalidIssuer},
+		{name: "audience", change: map[string]any{"aud": "api"}, err: jwt.ErrInvalidAudience},
+		{name: "single audience", change: map[string]any{"aud": "events"}, err: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := map[string]any{}
+			for name, value := range valid {
+				claims[name] = value
+			}
+
+			for name, value := range test.change {
+				claims[name] = value
+			}
+
+			_, err := authenticator.Verify(sign(t, "HS256", "", secret, claims))
+			if test.err == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, test.err)
+			}
+		})
+	}
+}
+
+func TestAuthenticatorSignature(t *testing.T) {
+	secret := []byte("secret")
+	claims := map[string]any{"sub": "42"}
+
+	authenticator := jwt.NewAuthenticator(jwt.Secret(secret), &jwt.Config{Algorithms: []string{"HS256"}}) //nolint:exhaustruct
+
+	_, err := authenticator.Verify(sign(t, "HS256", "", []byte("other"), claims))
+	require.ErrorIs(t, err, jwt.ErrInvalidSignature)
+
+	_, err = authenticator.Verify(sign(t, "none", "", nil, claims))
+	require.ErrorIs(t, err, jwt.ErrUnsupportedAlgorithm)
+
+	_, err = authenticator.Verify(sign(t, "HS384", "", secret, claims))
+	require.ErrorIs(t, err, jwt.ErrUnsupportedAlgorithm)
+
+	_, err = authenticator.Verify("token")
+	require.ErrorIs(t, err, jwt.ErrMalformed)
+
+	// a public key must not be used as an HMAC secret.
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	authenticator = jwt.NewAuthenticator(jwt.PublicKey(&key.PublicKey), nil)
+
+	_, err = authenticator.Verify(sign(t, "HS256", "", secret, claims))
+	require.ErrorIs(t, err, jwt.ErrUnknownKey)
+
+	_, err = authenticator.Verify(sign(t, "ES256", "", key, claims))
+	require.NoError(t, err)
+}
+
+func TestAuthenticateConn(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	exp := time.Now().Add(time.Hour).Unix()
+	token := sign(t, "RS256", "", key, map[string]any{"sub": "42", "roles": []string{"passenger"}, "exp": exp})
+
+	authenticator := jwt.NewAuthenticator(jwt.PublicKey(&key.PublicKey), nil)
+
+	principal, err := authenticator.AuthenticateConn(context.Background(), &auth.ConnInfo{Token: token}) //nolint:exhaustruct
+	require.NoError(t, err)
+
+	assert.Equal(t, "42", principal.ID)
+	assert.Equal(t, []string{"passenger"}, principal.Roles)
+	assert.Equal(t, time.Unix(exp, 0), principal.ExpiresAt)
+	assert.Equal(t, token, principal.Token)
+
+	// the principal of a token in the leeway isn't expired.
+	exp = time.Now().Add(-30 * time.Second).Unix()
+	leeway := jwt.NewAuthenticator(jwt.PublicKey(&key.PublicKey), &jwt.Config{Leeway: time.Minute}) //nolint:exhaustruct
+
+	principal, err = leeway.AuthenticateConn(context.Background(), &auth.ConnInfo{ //nolint:exhaustruct
+		Token: sign(t, "RS256", "", key, map[string]any{"sub": "42", "exp": exp}),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(exp, 0).Add(time.Minute), principal.ExpiresAt)
+	assert.False(t, principal.Expired(time.Now()))
+
+	_, err = authenticator.AuthenticateConn(context.Background(), &auth.ConnInfo{Token: token + "x"}) //nolint:exhaustruct
+	require.ErrorIs(t, err, auth.ErrNotAuthenticated)
+
+	var authErr *auth.Error
+	require.ErrorAs(t, err, &authErr)
+	assert.Equal(t, jwt.ErrInvalidSignature.Error(), authErr.Reason)
+}
+
+func TestAuthorizer(t *testing.T) {
+	secret := []byte("secret")
+	authorizer := jwt.NewAuthenticator(jwt.Secret(secret), nil).Authorizer()
+
+	token := sign(t, "HS256", "", secret, map[string]any{"topics": []string{"ride.passenger.42", "ride.*.status"}})
+
+	assert.True(t, authorizer.Authorize(token, "ride.passenger.42"))
+	assert.True(t, authorizer.Authorize(token, "ride.1.status"))
+	assert.False(t, authorizer.Authorize(token, "ride.passenger.43"))
+	assert.False(t, authorizer.Authorize(sign(t, "HS256", "", []byte("other"), map[string]any{"topics": ">"}), "ride"))
+
+	principal := &auth.Principal{Claims: map[string]any{"topics": ">"}} //nolint:exhaustruct
+	assert.True(t, authorizer.AuthorizePrincipal(principal, "ride.passenger.43"))
+	assert.False(t, authorizer.AuthorizePrincipal(&auth.Principal{}, "ride")) //nolint:exhaustruct
+}
+
+// writeJWKS writes the JWKS of the public keys by their key ID.
+func writeJWKS(t *testing.T, path string, keys map[string]*ecdsa.PrivateKey) {
+	t.Helper()
+
+	set := struct {
+		Keys []map[string]string `json:"keys"`
+	}{}
+
+	encode := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.FillBytes(make([]byte, 32))) }
+
+	for kid, key := range keys {
+		set.Keys = append(set.Keys, map[string]string{
+			"kty": "EC", "kid": kid, "alg": "ES256", "use": "sig", "crv": "P-256",
+			"x": encode(key.X), "y": encode(key.Y),
+		})
+	}
+
+	data, err := json.Marshal(set)
+	require.NoError(t, err)
+
+	// the file is replaced at once, so it is never read half written.
+	tmp := path + ".tmp"
+	require.NoError(t, os.WriteFile(tmp, data, 0o600))
+	require.NoError(t, os.Rename(tmp, path))
+}
+
+func TestJWKSFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	writeJWKS(t, path, map[string]*ecdsa.PrivateKey{"old": oldKey})
+
+	keys, err := jwt.LoadJWKSFile(path)
+	require.NoError(t, err)
+
+	authenticator := jwt.NewAuthenticator(keys, nil)
+
+	claims := map[string]any{"sub": "42"}
+
+	_, err = authenticator.Verify(sign(t, "ES256", "old", oldKey, claims))
+	require.NoError(t, err)
+
+	// the only key is used for tokens without a key ID.
+	_, err = authenticator.Verify(sign(t, "ES256", "", oldKey, claims))
+	require.NoError(t, err)
+
+	_, err = authenticator.Verify(sign(t, "ES256", "new", newKey, claims))
+	require.ErrorIs(t, err, jwt.ErrUnknownKey)
+
+	// the new key is loaded on its first token.
+	writeJWKS(t, path, map[string]*ecdsa.PrivateKey{"old": oldKey, "new": newKey})
+
+	_, err = authenticator.Verify(sign(t, "ES256", "new", newKey, claims))
+	require.NoError(t, err)
+
+	// the old key is removed after the check interval.
+	keys.CheckInterval = 0
+
+	writeJWKS(t, path, map[string]*ecdsa.PrivateKey{"new": newKey})
+
+	_, err = authenticator.Verify(sign(t, "ES256", "old", oldKey, claims))
+	require.ErrorIs(t, err, jwt.ErrUnknownKey)
+
+	// the key of another algorithm is not used.
+	_, err = keys.Key("new", "ES384")
+	require.ErrorIs(t, err, jwt.ErrUnknownKey)
+}
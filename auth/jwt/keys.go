@@ -0,0 +1,246 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+// KeySet returns the key that verifies the tokens of a key ID and algorithm. the key is
+// a []byte secret for HMAC, an *rsa.PublicKey for RSA or an *ecdsa.PublicKey for ECDSA.
+type KeySet interface {
+	Key(kid, alg string) (any, error)
+}
+
+type KeySetFunc func(kid, alg string) (any, error)
+
+func (f KeySetFunc) Key(kid, alg string) (any, error) {
+	return f(kid, alg)
+}
+
+// Secret returns the key set of an HMAC secret.
+func Secret(secret []byte) KeySet {
+	return KeySetFunc(func(_, _ string) (any, error) {
+		return secret, nil
+	})
+}
+
+// PublicKey returns the key set of an RSA or ECDSA public key.
+func PublicKey(key crypto.PublicKey) KeySet {
+	return KeySetFunc(func(_, _ string) (any, error) {
+		return key, nil
+	})
+}
+
+// DefJWKSCheckInterval is the default interval of checking a JWKS file for changes.
+const DefJWKSCheckInterval = 10 * time.Second
+
+// JWKSFile is the key set of a local JSON web key set file. the file is checked for changes at most
+// once per CheckInterval and when a token has an unknown key ID, so the keys can be rotated by
+// adding the new key to the file before using it and removing the old key after its tokens expire.
+type JWKSFile struct {
+	Path string
+	// CheckInterval is the interval of checking the file for changes, DefJWKSCheckInterval by default.
+	CheckInterval time.Duration
+
+	lock     sync.Mutex
+	keys     map[string]jwk
+	modified time.Time
+	size     int64
+	checked  time.Time
+}
+
+// LoadJWKSFile loads the key set of the JWKS file at path.
+func LoadJWKSFile(path string) (*JWKSFile, error) {
+	file := &JWKSFile{Path: path, CheckInterval: DefJWKSCheckInterval} //nolint:exhaustruct
+
+	file.lock.Lock()
+	defer file.lock.Unlock()
+
+	if err := file.reload(); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// Key returns the key of kid, which can be empty when the file has only one key.
+func (f *JWKSFile) Key(kid, alg string) (any, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	key, ok := f.key(kid)
+	if !ok || time.Since(f.checked) >= f.CheckInterval {
+		// the loaded key is used when the file can't be read.
+		if err := f.reload(); err != nil && !ok {
+			return nil, err
+		}
+
+		key, ok = f.key(kid)
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+	}
+
+	if key.alg != "" && key.alg != alg {
+		return nil, fmt.Errorf("%w: %q is for %s", ErrUnknownKey, kid, key.alg)
+	}
+
+	return key.key, nil
+}
+
+func (f *JWKSFile) key(kid string) (jwk, bool) {
+	if kid == "" && len(f.keys) == 1 {
+		for _, key := range f.keys {
+			return key, true
+		}
+	}
+
+	key, ok := f.keys[kid]
+
+	return key, ok
+}
+
+// reload reads the file when it is changed. the previous keys are kept when it fails.
+func (f *JWKSFile) reload() error {
+	f.checked = time.Now()
+
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return fmt.Errorf("failed to check jwks file: %w", err)
+	}
+
+	if f.keys != nil && info.ModTime().Equal(f.modified) && info.Size() == f.size {
+		return nil
+	}
+
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read jwks file: %w", err)
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	f.keys = keys
+	f.modified = info.ModTime()
+	f.size = info.Size()
+
+	return nil
+}
+
+// jwk is a parsed JSON web key.
+type jwk struct {
+	key any
+	alg string
+}
+
+type rawJWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+	// oct
+	K string `json:"k"`
+}
+
+// parseJWKS parses a JSON web key set by key ID. the keys which are not for signing are skipped.
+func parseJWKS(data []byte) (map[string]jwk, error) {
+	var set struct {
+		Keys []rawJWK `json:"keys"`
+	}
+
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse jwks: %w", err)
+	}
+
+	keys := make(map[string]jwk, len(set.Keys))
+
+	for _, raw := range set.Keys {
+		if raw.Use != "" && raw.Use != "sig" {
+			continue
+		}
+
+		key, err := raw.parse()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse jwk %q: %w", raw.KeyID, err)
+		}
+
+		keys[raw.KeyID] = jwk{key: key, alg: raw.Algorithm}
+	}
+
+	return keys, nil
+}
+
+func (raw *rawJWK) parse() (any, error) {
+	switch raw.KeyType {
+	case "RSA":
+		n, err := decodeInt(raw.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeInt(raw.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+
+		switch raw.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("curve %q is not supported", raw.Curve)
+		}
+
+		x, err := decodeInt(raw.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeInt(raw.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(raw.K)
+	default:
+		return nil, fmt.Errorf("key type %q is not supported", raw.KeyType)
+	}
+}
+
+func decodeInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(data), nil
+}
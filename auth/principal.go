@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net"
+	"slices"
+	"time"
+)
+
+// ErrNotAuthenticated is wrapped by the errors of authenticators.
+var ErrNotAuthenticated = errors.New("client is not authenticated")
+
+// ConnInfo is what the server knows about a client when it authenticates it.
+type ConnInfo struct {
+	RemoteAddr net.Addr
+	// PeerCertificates are the certificates of the client when it uses mutual TLS.
+	PeerCertificates []*x509.Certificate
+	// VerifiedChains are the chains of PeerCertificates which are verified by the client CAs of the server.
+	VerifiedChains [][]*x509.Certificate
+	// ServerName is the server name requested by the client with SNI.
+	ServerName string
+	// Protocol is the protocol negotiated by ALPN.
+	Protocol string
+	// Token and Metadata are sent by the client in its offer.
+	Token    string
+	Metadata map[string]string
+}
+
+// Principal is an authenticated client. it is passed to authorizers and server hooks.
+type Principal struct {
+	ID    string
+	Roles []string
+	// Claims are the attributes of the principal, e.g. the claims of a JWT.
+	Claims map[string]any
+	// ExpiresAt is when the authentication expires, zero never expires.
+	ExpiresAt time.Time
+	// Token is the token that the principal is authenticated by.
+	Token string
+}
+
+// HasRole reports whether the principal has role.
+func (p *Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+// Expired reports whether the authentication of the principal is expired at now.
+func (p *Principal) Expired(now time.Time) bool {
+	return !p.ExpiresAt.IsZero() && !now.Before(p.ExpiresAt)
+}
+
+// Error is the reason that a client is not authenticated. Reason is sent to the
+// client, so it must not have secrets.
+type Error struct {
+	Reason string
+	Err    error
+}
+
+// NewError returns the error of reason which wraps err, err can be nil.
+func NewError(reason string, err error) *Error {
+	return &Error{Reason: reason, Err: err}
+}
+
+func (e *Error) Error() string {
+	return "authentication failed: " + e.Reason
+}
+
+// Unwrap returns ErrNotAuthenticated and the cause.
+func (e *Error) Unwrap() []error {
+	if e.Err != nil {
+		return []error{ErrNotAuthenticated, e.Err}
+	}
+
+	return []error{ErrNotAuthenticated}
+}
+
+// ConnAuthenticator authenticates clients by their connection. it returns the principal of
+// the client or an error, which is an *Error to report the reason to the client.
+type ConnAuthenticator interface {
+	AuthenticateConn(ctx context.Context, info *ConnInfo) (*Principal, error)
+}
+
+type ConnAuthenticatorFunc func(ctx context.Context, info *ConnInfo) (*Principal, error)
+
+func (a ConnAuthenticatorFunc) AuthenticateConn(ctx context.Context, info *ConnInfo) (*Principal, error) {
+	return a(ctx, info)
+}
+
+// TokenAuthenticator authenticates clients by their token with authenticator. the token doesn't tell who
+// the client is, so the ID of the principals is empty. the token is a secret and is never used as an ID.
+func TokenAuthenticator(authenticator Authenticator) ConnAuthenticator {
+	return ConnAuthenticatorFunc(func(_ context.Context, info *ConnInfo) (*Principal, error) {
+		if !authenticator.Authenticate(info.Token) {
+			return nil, NewError("invalid token", nil)
+		}
+
+		return &Principal{ID: "", Roles: nil, Claims: nil, ExpiresAt: time.Time{}, Token: info.Token}, nil
+	})
+}
+
+// PrincipalAuthorizer authorizes principals when they want to subscribe on a specific topic.
+type PrincipalAuthorizer interface {
+	AuthorizePrincipal(principal *Principal, topic string) bool
+}
+
+type PrincipalAuthorizerFunc func(principal *Principal, topic string) bool
+
+func (a PrincipalAuthorizerFunc) AuthorizePrincipal(principal *Principal, topic string) bool {
+	return a(principal, topic)
+}
+
+// TokenAuthorizer authorizes principals by their token with authorizer.
+func TokenAuthorizer(authorizer Authorizer) PrincipalAuthorizer {
+	return PrincipalAuthorizerFunc(func(principal *Principal, topic string) bool {
+		return authorizer.Authorize(principal.Token, topic)
+	})
+}
@@ -0,0 +1,51 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/snapp-incubator/qsse/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenAuthenticator(t *testing.T) {
+	authenticator := auth.TokenAuthenticator(auth.AuthenticatorFunc(func(token string) bool { return token == "secret" }))
+
+	principal, err := authenticator.AuthenticateConn(context.Background(), &auth.ConnInfo{Token: "secret"}) //nolint:exhaustruct
+	require.NoError(t, err)
+	assert.Empty(t, principal.ID)
+	assert.Equal(t, "secret", principal.Token)
+
+	_, err = authenticator.AuthenticateConn(context.Background(), &auth.ConnInfo{Token: "wrong"}) //nolint:exhaustruct
+	require.ErrorIs(t, err, auth.ErrNotAuthenticated)
+
+	var authErr *auth.Error
+	require.ErrorAs(t, err, &authErr)
+	assert.Equal(t, "invalid token", authErr.Reason)
+}
+
+func TestError(t *testing.T) {
+	cause := errors.New("signature is invalid")
+	err := auth.NewError("invalid token", cause)
+
+	assert.ErrorIs(t, err, auth.ErrNotAuthenticated)
+	assert.ErrorIs(t, err, cause)
+	assert.Equal(t, "authentication failed: invalid token", err.Error())
+}
+
+func TestPrincipal(t *testing.T) {
+	now := time.Now()
+	principal := &auth.Principal{ID: "42", Roles: []string{"passenger"}, ExpiresAt: now} //nolint:exhaustruct
+
+	assert.True(t, principal.HasRole("passenger"))
+	assert.False(t, principal.HasRole("driver"))
+
+	assert.True(t, principal.Expired(now))
+	assert.False(t, principal.Expired(now.Add(-time.Second)))
+
+	principal.ExpiresAt = time.Time{}
+	assert.False(t, principal.Expired(now))
+}
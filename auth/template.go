@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalidTemplate is returned by ParseTemplate when a template doesn't follow the topic syntax.
+var ErrInvalidTemplate = errors.New("topic template is not valid")
+
+// Params are the named parameters of a topic, e.g. {"id": "42"} for ride.passenger.42
+// and the template ride.passenger.{id}.
+type Params map[string]string
+
+// ParamAuthorizer authorizes principals on the topics matching a template by the parameters of the topic.
+type ParamAuthorizer interface {
+	AuthorizeParams(principal *Principal, params Params) bool
+}
+
+type ParamAuthorizerFunc func(principal *Principal, params Params) bool
+
+func (a ParamAuthorizerFunc) AuthorizeParams(principal *Principal, params Params) bool {
+	return a(principal, params)
+}
+
+// Template is a topic in which segments can be named parameters, e.g. ride.passenger.{id}.
+// a parameter matches exactly one segment of a topic.
+type Template struct {
+	template string
+	segments []segment
+}
+
+type segment struct {
+	value string
+	param bool
+}
+
+// ParseTemplate parses template. its segments are either topic segments or parameters
+// with distinct names, which are letters, digits and underscores between braces.
+func ParseTemplate(template string) (*Template, error) {
+	segments := make([]segment, 0, strings.Count(template, ".")+1)
+	names := make(map[string]struct{})
+
+	for _, value := range strings.Split(template, ".") {
+		if name, ok := strings.CutPrefix(value, "{"); ok {
+			name, ok = strings.CutSuffix(name, "}")
+			if !ok || name == "" || strings.ContainsFunc(name, invalidNameRune) {
+				return nil, fmt.Errorf("%w: %q has an invalid parameter %s", ErrInvalidTemplate, template, value)
+			}
+
+			if _, ok := names[name]; ok {
+				return nil, fmt.Errorf("%w: %q has the parameter %s twice", ErrInvalidTemplate, template, name)
+			}
+
+			names[name] = struct{}{}
+			segments = append(segments, segment{value: name, param: true})
+
+			continue
+		}
+
+		if value == "" || strings.ContainsFunc(value, invalidSegmentRune) {
+			return nil, fmt.Errorf("%w: %q has an invalid segment %q", ErrInvalidTemplate, template, value)
+		}
+
+		segments = append(segments, segment{value: value, param: false})
+	}
+
+	return &Template{template: template, segments: segments}, nil
+}
+
+// Match returns the parameters of topic when it matches the template.
+func (t *Template) Match(topic string) (Params, bool) {
+	params := make(Params)
+
+	for i, segment := range t.segments {
+		value, rest, more := strings.Cut(topic, ".")
+
+		switch {
+		case segment.param:
+			params[segment.value] = value
+		case segment.value != value:
+			return nil, false
+		}
+
+		// the topic and the template must end together.
+		if more != (i < len(t.segments)-1) {
+			return nil, false
+		}
+
+		topic = rest
+	}
+
+	return params, true
+}
+
+// Pattern returns the topic pattern of the template, in which the parameters are wildcards.
+func (t *Template) Pattern() string {
+	segments := make([]string, 0, len(t.segments))
+
+	for _, segment := range t.segments {
+		if segment.param {
+			segments = append(segments, "*")
+		} else {
+			segments = append(segments, segment.value)
+		}
+	}
+
+	return strings.Join(segments, ".")
+}
+
+func (t *Template) String() string {
+	return t.template
+}
+
+func invalidNameRune(r rune) bool {
+	return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+func invalidSegmentRune(r rune) bool {
+	return r == '*' || r == '>' || r == '{' || r == '}' || unicode.IsSpace(r) || unicode.IsControl(r)
+}
@@ -0,0 +1,55 @@
+package auth_test
+
+import (
+	"testing"
+
+	"github.com/snapp-incubator/qsse/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		valid    bool
+	}{
+		{template: "ride.passenger.{id}", valid: true},
+		{template: "{city}.ride.{ride_id}", valid: true},
+		{template: "ride.passenger", valid: true},
+		{template: "", valid: false},
+		{template: "ride..{id}", valid: false},
+		{template: "ride.{}", valid: false},
+		{template: "ride.{id", valid: false},
+		{template: "ride.{i-d}", valid: false},
+		{template: "ride.{id}.{id}", valid: false},
+		{template: "ride.*.{id}", valid: false},
+		{template: "ride.{id}.>", valid: false},
+		{template: "ride.passenger{id}", valid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.template, func(t *testing.T) {
+			_, err := auth.ParseTemplate(test.template)
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, auth.ErrInvalidTemplate)
+			}
+		})
+	}
+}
+
+func TestTemplateMatch(t *testing.T) {
+	template, err := auth.ParseTemplate("{city}.passenger.{id}")
+	require.NoError(t, err)
+
+	params, ok := template.Match("tehran.passenger.42")
+	require.True(t, ok)
+	assert.Equal(t, auth.Params{"city": "tehran", "id": "42"}, params)
+	assert.Equal(t, "*.passenger.*", template.Pattern())
+
+	for _, topic := range []string{"tehran.driver.42", "tehran.passenger", "tehran.passenger.42.name", "passenger.42"} {
+		_, ok := template.Match(topic)
+		assert.False(t, ok, topic)
+	}
+}
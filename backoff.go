@@ -0,0 +1,133 @@
+package qsse
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// InfiniteRetries makes the client retry until it is connected or closed.
+const InfiniteRetries = -1
+
+// BackoffStrategy is the built-in strategy of computing the delay between reconnect attempts.
+type BackoffStrategy int
+
+const (
+	// BackoffConstant waits Interval between attempts.
+	BackoffConstant BackoffStrategy = iota
+	// BackoffExponential doubles the delay on each attempt with a random jitter up to MaxInterval.
+	BackoffExponential
+	// BackoffDecorrelatedJitter picks a random delay between Interval and three times the previous delay.
+	BackoffDecorrelatedJitter
+)
+
+const (
+	exponentialMultiplier = 2
+	exponentialJitter     = 0.5
+	decorrelatedFactor    = 3
+)
+
+// Backoff computes the delay before reconnect attempts. it must be safe for concurrent use
+// because the policy can be shared between clients.
+type Backoff interface {
+	// Next returns the delay before the next attempt. attempt starts from 1 and previous
+	// is the delay returned for the previous attempt, zero for the first one.
+	Next(attempt int, previous time.Duration) time.Duration
+}
+
+type BackoffFunc func(attempt int, previous time.Duration) time.Duration
+
+func (b BackoffFunc) Next(attempt int, previous time.Duration) time.Duration {
+	return b(attempt, previous)
+}
+
+// ConstantBackoff always waits for Interval.
+type ConstantBackoff struct {
+	Interval time.Duration
+}
+
+func (b ConstantBackoff) Next(_ int, _ time.Duration) time.Duration {
+	return b.Interval
+}
+
+// ExponentialBackoff multiplies Initial by Multiplier on each attempt up to Max.
+// the delay is randomized in [d - Jitter*d, d + Jitter*d] before it is capped by Max
+// and Jitter must be in [0, 1]. Multiplier is 2 when it is not positive.
+type ExponentialBackoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+}
+
+func (b ExponentialBackoff) Next(attempt int, _ time.Duration) time.Duration {
+	multiplier := b.Multiplier
+	if multiplier <= 0 {
+		multiplier = exponentialMultiplier
+	}
+
+	delay := float64(b.Initial) * math.Pow(multiplier, float64(attempt-1))
+
+	if b.Jitter > 0 {
+		delay += delay * b.Jitter * (2*rand.Float64() - 1) //nolint:gosec,mnd
+	}
+
+	if b.Max > 0 && delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+
+	return time.Duration(delay)
+}
+
+// DecorrelatedJitterBackoff picks a random delay between Base and three times the previous delay up to Max.
+// Base is the default retry interval of the client when it is not positive, so the client never redials at once.
+type DecorrelatedJitterBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (b DecorrelatedJitterBackoff) Next(_ int, previous time.Duration) time.Duration {
+	base := b.Base
+	if base <= 0 {
+		base = reconnectRetryInterval
+	}
+
+	upper := max(previous*decorrelatedFactor, base)
+
+	delay := base
+	if upper > base {
+		delay += rand.N(upper - base) //nolint:gosec
+	}
+
+	if b.Max > 0 && delay > b.Max {
+		delay = b.Max
+	}
+
+	return delay
+}
+
+// backoff returns the custom backoff of policy or the one built from its strategy.
+func (p ReconnectPolicy) backoff() Backoff {
+	if p.Backoff != nil {
+		return p.Backoff
+	}
+
+	switch p.Strategy {
+	case BackoffExponential:
+		return ExponentialBackoff{
+			Initial:    p.Interval,
+			Max:        p.MaxInterval,
+			Multiplier: exponentialMultiplier,
+			Jitter:     exponentialJitter,
+		}
+	case BackoffDecorrelatedJitter:
+		return DecorrelatedJitterBackoff{
+			Base: p.Interval,
+			Max:  p.MaxInterval,
+		}
+	case BackoffConstant:
+		fallthrough
+	default:
+		return ConstantBackoff{Interval: p.Interval}
+	}
+}
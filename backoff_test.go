@@ -0,0 +1,123 @@
+package qsse_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/snapp-incubator/qsse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConstantBackoff(t *testing.T) {
+	backoff := qsse.ConstantBackoff{Interval: time.Second}
+
+	for attempt := 1; attempt < 5; attempt++ {
+		assert.Equal(t, time.Second, backoff.Next(attempt, time.Second))
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		attempt int
+		delay   time.Duration
+	}{
+		{
+			name:    "first attempt",
+			attempt: 1,
+			delay:   100 * time.Millisecond,
+		},
+		{
+			name:    "third attempt",
+			attempt: 3,
+			delay:   400 * time.Millisecond,
+		},
+		{
+			name:    "capped by max",
+			attempt: 10,
+			delay:   time.Second,
+		},
+	}
+
+	backoff := qsse.ExponentialBackoff{
+		Initial:    100 * time.Millisecond,
+		Max:        time.Second,
+		Multiplier: 2,
+		Jitter:     0,
+	}
+
+	for _, test := range tests {
+		testCase := test
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, testCase.delay, backoff.Next(testCase.attempt, 0))
+		})
+	}
+}
+
+func TestExponentialBackoffJitter(t *testing.T) {
+	backoff := qsse.ExponentialBackoff{
+		Initial:    time.Second,
+		Max:        0,
+		Multiplier: 2,
+		Jitter:     0.5,
+	}
+
+	for range 100 {
+		delay := backoff.Next(2, 0)
+		assert.GreaterOrEqual(t, delay, time.Second)
+		assert.LessOrEqual(t, delay, 3*time.Second)
+	}
+}
+
+func TestExponentialBackoffJitterCappedByMax(t *testing.T) {
+	backoff := qsse.ExponentialBackoff{
+		Initial:    time.Second,
+		Max:        time.Second,
+		Multiplier: 2,
+		Jitter:     0.5,
+	}
+
+	for range 100 {
+		assert.LessOrEqual(t, backoff.Next(3, 0), time.Second)
+	}
+}
+
+func TestExponentialBackoffDefaultMultiplier(t *testing.T) {
+	backoff := qsse.ExponentialBackoff{Initial: 100 * time.Millisecond} //nolint:exhaustruct
+
+	assert.Equal(t, 400*time.Millisecond, backoff.Next(3, 0))
+}
+
+func TestDecorrelatedJitterBackoff(t *testing.T) {
+	backoff := qsse.DecorrelatedJitterBackoff{
+		Base: 100 * time.Millisecond,
+		Max:  time.Second,
+	}
+
+	var delay time.Duration
+
+	for attempt := 1; attempt < 100; attempt++ {
+		previous := delay
+		delay = backoff.Next(attempt, previous)
+
+		assert.GreaterOrEqual(t, delay, 100*time.Millisecond)
+		assert.LessOrEqual(t, delay, max(3*previous, 100*time.Millisecond))
+		assert.LessOrEqual(t, delay, time.Second)
+	}
+}
+
+func TestDecorrelatedJitterBackoffZeroBase(t *testing.T) {
+	var backoff qsse.DecorrelatedJitterBackoff
+
+	var delay time.Duration
+
+	for attempt := 1; attempt < 10; attempt++ {
+		delay = backoff.Next(attempt, delay)
+
+		assert.GreaterOrEqual(t, delay, 5*time.Second)
+	}
+
+	// the delay is capped by Max even when it is lower than the default base.
+	backoff.Max = time.Second
+	assert.Equal(t, time.Second, backoff.Next(1, 0))
+}
@@ -1,10 +1,9 @@
 package qsse
 
 import (
-	"bufio"
 	"context"
 	"crypto/tls"
-	"encoding/json"
+	"crypto/x509"
 	"time"
 
 	quic "github.com/quic-go/quic-go"
@@ -14,40 +13,134 @@ import (
 
 const (
 	reconnectRetryNumber   = 5
-	reconnectRetryInterval = 5000
+	reconnectRetryInterval = 5 * time.Second
+	keepAlivePeriod        = 10 * time.Second
 )
 
+// Session is the handshake response of the server, it describes the session ID,
+// the accepted and rejected topics and the server limits.
+type Session = internal.Session
+
+// RejectedTopic is an offered topic that the server refused, Code is CodeTopicNotAvailable, CodeNotAuthorized
+// or CodeInvalidTopic.
+type RejectedTopic = internal.RejectedTopic
+
+// Limits are the server limits that apply to a session.
+type Limits = internal.Limits
+
+// Ack is the answer of the server to Client.Subscribe and Client.Unsubscribe.
+type Ack = internal.Ack
+
 type Client interface {
 	SetEventHandler(topic string, handler func([]byte))
 
 	SetErrorHandler(handler func(code int, data map[string]any))
 
 	SetMessageHandler(handler func(topic string, event []byte))
+
+	// Subscribe subscribes the current connection to topics, the server authorizes each topic and
+	// rejects the ones that are not available. it needs protocol version 2.
+	Subscribe(ctx context.Context, topics ...string) (Ack, error)
+
+	// Unsubscribe unsubscribes the current connection from topics. all the topics are accepted, even the ones
+	// that are not subscribed or not available. it needs protocol version 2.
+	Unsubscribe(ctx context.Context, topics ...string) (Ack, error)
+
+	// Refresh authenticates the current connection again by token before its token expires, so the session
+	// is kept. the topics that token is not authorized for are unsubscribed by the server. token is offered
+	// on reconnect afterward. it needs protocol version 2.
+	Refresh(ctx context.Context, token string) error
+
+	// Session returns what the server granted to the current connection. servers
+	// of version 1 don't report it, so only its Version is set.
+	Session() Session
+
+	// Close closes the connection to the server.
+	Close() error
 }
 
 type ClientConfig struct {
-	Token           string
-	TLSConfig       *tls.Config
+	Token string
+	// Metadata is sent in the offer and passed to the authenticator of the server.
+	Metadata  map[string]string
+	TLSConfig *tls.Config
+	// MTLS sends a certificate to servers of mutual TLS.
+	MTLS            *ClientMTLSConfig
 	ReconnectPolicy *ReconnectPolicy
+	// Versions are the offered protocol versions from the most preferred, the server
+	// chooses one of them by ALPN. NextProtos of TLSConfig is replaced by their tokens.
+	Versions []int
+	// Log replaces the default logger, e.g. by the logger of the application.
+	Log *LogConfig
+
+	// OnConnect is called when the client is connected to the server.
+	OnConnect func()
+	// OnDisconnect is called when the client is disconnected from the server.
+	// err is nil when the client is closed and a *ConnectionError otherwise. it is called
+	// again with an error wrapping ErrFailedToReconnect when reconnecting gives up.
+	OnDisconnect func(err error)
+	// OnReconnect is called when the client is connected to the server again.
+	OnReconnect func()
+	// RefreshToken returns a new token when the server notifies the client by CodeTokenExpiring, e.g. from
+	// an identity provider. the client is disconnected with CodeNotAuthorized when its token expires otherwise.
+	RefreshToken func(ctx context.Context) (string, error)
+}
+
+// ClientMTLSConfig configures the certificate of the client for mutual TLS.
+type ClientMTLSConfig struct {
+	Certificate tls.Certificate
+	// RootCAs verify the certificate of the server, the RootCAs of TLSConfig or the system
+	// roots are used when it is nil. the server is always verified with mutual TLS.
+	RootCAs *x509.CertPool
 }
 
+// ReconnectPolicy is used when the client can't connect to the server
+// and when the connection is lost afterwards.
 type ReconnectPolicy struct {
-	Retry         bool
-	RetryTimes    int
+	Retry bool
+	// RetryTimes is the number of attempts, use InfiniteRetries to retry until connected.
+	RetryTimes int
+	// Deprecated: use Interval instead.
 	RetryInterval int // duration between retry intervals in milliseconds
+
+	// Strategy of computing the delay between attempts. it is ignored when Backoff is set.
+	Strategy BackoffStrategy
+	// Interval is the delay of constant strategy and the initial delay of other strategies.
+	Interval time.Duration
+	// MaxInterval caps the delay between attempts, zero means no limit.
+	MaxInterval time.Duration
+	// MaxElapsedTime stops retrying when it passes since the first failure, zero means no limit.
+	MaxElapsedTime time.Duration
+	// Backoff is a custom backoff which replaces the Strategy.
+	Backoff Backoff
 }
 
 //nolint:funlen
 func NewClient(address string, topics []string, config *ClientConfig) (Client, error) {
 	processedConfig := processConfig(config)
-	l := internal.NewLogger().Named("client")
 
-	connection, err := quic.DialAddr(context.Background(), address, processedConfig.TLSConfig, nil)
+	if err := internal.ValidateVersions(processedConfig.Versions); err != nil {
+		return nil, err
+	}
+
+	logger, err := newLogger(processedConfig.Log)
+	if err != nil {
+		return nil, err
+	}
+
+	l := logger.Named("client").With(zap.String("remote_addr", address))
+
+	connection, err := quic.DialAddr(context.Background(), address, processedConfig.TLSConfig, quicConfig())
+	if internal.IsNoCommonProtocol(err) {
+		return nil, &HandshakeError{Code: CodeUnsupportedVersion, Message: "no protocol version in common", Err: err}
+	}
+
 	if err != nil {
 		if processedConfig.ReconnectPolicy.Retry {
 			l.Warn("Failed to connect to server, retrying...")
 
 			c, res := reconnect(
+				context.Background(),
 				*processedConfig.ReconnectPolicy,
 				address,
 				processedConfig.TLSConfig,
@@ -66,122 +159,161 @@ func NewClient(address string, topics []string, config *ClientConfig) (Client, e
 	}
 
 	client := internal.Client{
-		Connection: connection,
-		Token:      processedConfig.Token,
-		Topics:     topics,
-		Finder: internal.Finder{
-			Logger: l.Named("finder"),
-		},
-		OnEvent:   make(map[string]func([]byte)),
-		OnMessage: internal.DefaultOnMessage,
-		OnError:   internal.DefaultOnError,
-		Logger:    l.Named("client"),
+		Connection:   connection,
+		Token:        processedConfig.Token,
+		Metadata:     processedConfig.Metadata,
+		Topics:       topics,
+		Finder:       internal.Finder{},
+		LastEventIDs: make(map[string]uint64),
+		OnEvent:      make(map[string]func([]byte)),
+		OnMessage:    internal.DefaultOnMessage,
+		OnError:      internal.DefaultOnError,
+		Logger:       l.Named("client"),
+
+		OnConnect:    processedConfig.OnConnect,
+		OnDisconnect: processedConfig.OnDisconnect,
+		OnReconnect:  processedConfig.OnReconnect,
+
+		RefreshToken: processedConfig.RefreshToken,
 	}
 
-	offer := internal.NewOffer(processedConfig.Token, topics)
-
-	bytes, err := json.Marshal(offer)
-	if err != nil {
-		l.Error("failed to marshal offer", zap.Error(err))
-
-		return nil, internal.ErrFailedToMarshal
-	}
+	if processedConfig.ReconnectPolicy.Retry {
+		client.Reconnect = func(ctx context.Context) (*quic.Conn, error) {
+			c, res := reconnect(
+				ctx,
+				*processedConfig.ReconnectPolicy,
+				address,
+				processedConfig.TLSConfig,
+				l.Named("reconnect"),
+			)
+			if !res {
+				return nil, internal.ErrFailedToReconnect
+			}
 
-	stream, err := connection.OpenUniStream()
-	if err != nil {
-		l.Error("failed to open send stream", zap.Error(err))
-
-		err = internal.CloseClientConnection(
-			connection,
-			internal.CodeFailedToCreateStream,
-			internal.ErrFailedToCreateStream,
-		)
-		if err != nil {
-			l.Error("failed to close client connection", zap.Error(err))
+			return c, nil
 		}
-
-		return nil, internal.ErrFailedToCreateStream
 	}
 
-	err = internal.WriteData(bytes, stream)
+	reader, err := client.Handshake(context.Background())
 	if err != nil {
-		l.Error("failed to send offer to server", zap.Error(err))
-
-		err = internal.CloseClientConnection(
-			connection,
-			internal.CodeFailedToSendOffer,
-			internal.ErrFailedToSendOffer,
-		)
-		if err != nil {
-			l.Error("failed to close client connection", zap.Error(err))
-		}
-
-		return nil, internal.ErrFailedToSendOffer
+		return nil, err
 	}
 
-	_ = stream.Close()
-
-	receiveStream, err := connection.AcceptUniStream(context.Background())
-	if err != nil {
-		l.Error("failed to open receive stream", zap.Error(err))
-
-		err = internal.CloseClientConnection(
-			connection,
-			internal.CodeFailedToCreateStream,
-			internal.ErrFailedToCreateStream,
-		)
-		if err != nil {
-			l.Error("failed to close client connection", zap.Error(err))
-		}
-
-		return nil, internal.ErrFailedToCreateStream
+	if client.OnConnect != nil {
+		client.OnConnect()
 	}
 
-	reader := bufio.NewReader(receiveStream)
-	go client.AcceptEvents(reader)
+	client.Listen(reader)
 
 	return &client, nil
 }
 
 func processConfig(config *ClientConfig) ClientConfig {
 	if config == nil {
-		return ClientConfig{
+		return ClientConfig{ //nolint:exhaustruct
 			Token:     "",
 			TLSConfig: GetSimpleTLS(),
-			ReconnectPolicy: &ReconnectPolicy{
-				Retry:         false,
-				RetryTimes:    reconnectRetryNumber,
-				RetryInterval: reconnectRetryInterval,
+			ReconnectPolicy: &ReconnectPolicy{ //nolint:exhaustruct
+				Retry:      false,
+				RetryTimes: reconnectRetryNumber,
+				Interval:   reconnectRetryInterval,
 			},
+			Versions: internal.SupportedVersions,
 		}
 	}
 
+	cfg := *config
+	config = &cfg
+
+	if config.Versions == nil {
+		config.Versions = internal.SupportedVersions
+	}
+
 	if config.TLSConfig == nil {
 		config.TLSConfig = GetSimpleTLS()
 	}
 
+	config.TLSConfig = config.TLSConfig.Clone()
+	config.TLSConfig.NextProtos = internal.Protocols(config.Versions)
+
+	if config.MTLS != nil {
+		config.TLSConfig.Certificates = []tls.Certificate{config.MTLS.Certificate}
+
+		config.TLSConfig.InsecureSkipVerify = false
+
+		if config.MTLS.RootCAs != nil {
+			config.TLSConfig.RootCAs = config.MTLS.RootCAs
+		}
+	}
+
 	if config.ReconnectPolicy == nil {
-		config.ReconnectPolicy = &ReconnectPolicy{
-			Retry:         false,
-			RetryTimes:    reconnectRetryNumber,
-			RetryInterval: reconnectRetryInterval,
+		config.ReconnectPolicy = &ReconnectPolicy{ //nolint:exhaustruct
+			Retry:      false,
+			RetryTimes: reconnectRetryNumber,
+			Interval:   reconnectRetryInterval,
+		}
+	}
+
+	if config.ReconnectPolicy.Interval == 0 {
+		policy := *config.ReconnectPolicy
+
+		policy.Interval = reconnectRetryInterval
+		if policy.RetryInterval > 0 {
+			policy.Interval = time.Duration(policy.RetryInterval) * time.Millisecond
 		}
+
+		config.ReconnectPolicy = &policy
 	}
 
 	return *config
 }
 
-func reconnect(policy ReconnectPolicy, address string, tlcCfg *tls.Config, l *zap.Logger) (*quic.Conn, bool) {
-	for range policy.RetryTimes {
-		connection, err := quic.DialAddr(context.Background(), address, tlcCfg, nil)
+// reconnect dials the server according to the policy. it gives up when ctx is done.
+func reconnect(
+	ctx context.Context,
+	policy ReconnectPolicy,
+	address string,
+	tlcCfg *tls.Config,
+	l *zap.Logger,
+) (*quic.Conn, bool) {
+	backoff := policy.backoff()
+	start := time.Now()
+
+	var delay time.Duration
+
+	for attempt := 1; policy.RetryTimes == InfiniteRetries || attempt <= policy.RetryTimes; attempt++ {
+		connection, err := quic.DialAddr(ctx, address, tlcCfg, quicConfig())
 		if err == nil {
 			return connection, true
 		}
 
-		l.Error("failed to reconnect", zap.Error(err))
+		// the server is reachable but doesn't support any of the versions.
+		if internal.IsNoCommonProtocol(err) {
+			l.Error("no protocol version in common with server", zap.Error(err))
+
+			return nil, false
+		}
 
-		time.Sleep(time.Duration(policy.RetryInterval) * time.Millisecond)
+		l.Error("failed to reconnect", zap.Error(err), zap.Int("attempt", attempt))
+
+		delay = backoff.Next(attempt, delay)
+		if policy.MaxElapsedTime > 0 && time.Since(start)+delay > policy.MaxElapsedTime {
+			return nil, false
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, false
+		}
 	}
 
 	return nil, false
 }
+
+// quicConfig keeps the connection alive, so it is only closed when the server is not reachable.
+func quicConfig() *quic.Config {
+	return &quic.Config{ //nolint:exhaustruct
+		KeepAlivePeriod: keepAlivePeriod,
+	}
+}
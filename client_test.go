@@ -0,0 +1,588 @@
+package qsse_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/snapp-incubator/qsse"
+	"github.com/snapp-incubator/qsse/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientClose(t *testing.T) {
+	address := "localhost:14243"
+
+	server := newTestServer(t, address, "client_close", []string{"topic"})
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	disconnected := make(chan error, 1)
+
+	done := make(chan struct{})
+	go publishUntil(server, "topic", done)
+
+	client, err := qsse.NewClient(address, []string{"topic"}, &qsse.ClientConfig{ //nolint:exhaustruct
+		OnDisconnect: func(err error) { disconnected <- err },
+	})
+	require.NoError(t, err)
+	close(done)
+
+	require.NoError(t, client.Close())
+	assert.ErrorIs(t, client.Close(), qsse.ErrClientClosed)
+
+	select {
+	case err := <-disconnected:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("client is not disconnected")
+	}
+}
+
+func TestClientDisconnectOnShutdown(t *testing.T) {
+	address := "localhost:14244"
+
+	server := newTestServer(t, address, "client_shutdown", []string{"topic"})
+
+	connected := make(chan struct{}, 1)
+	disconnected := make(chan error, 1)
+
+	done := make(chan struct{})
+	go publishUntil(server, "topic", done)
+
+	_, err := qsse.NewClient(address, []string{"topic"}, &qsse.ClientConfig{ //nolint:exhaustruct
+		OnConnect:    func() { connected <- struct{}{} },
+		OnDisconnect: func(err error) { disconnected <- err },
+	})
+	require.NoError(t, err)
+	<-connected
+	close(done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	require.NoError(t, server.Shutdown(ctx))
+
+	select {
+	case err := <-disconnected:
+		var connectionErr *qsse.ConnectionError
+
+		require.ErrorAs(t, err, &connectionErr)
+		assert.Equal(t, qsse.CodeGoingAway, connectionErr.Code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("client is not disconnected")
+	}
+}
+
+func TestClientReconnect(t *testing.T) {
+	address := "localhost:14245"
+
+	server := newTestServer(t, address, "client_reconnect", []string{"topic"})
+
+	done := make(chan struct{})
+	go publishUntil(server, "topic", done)
+
+	reconnected := make(chan struct{}, 1)
+
+	client, err := qsse.NewClient(address, []string{"topic"}, &qsse.ClientConfig{ //nolint:exhaustruct
+		ReconnectPolicy: &qsse.ReconnectPolicy{ //nolint:exhaustruct
+			Retry:      true,
+			RetryTimes: qsse.InfiniteRetries,
+			Interval:   100 * time.Millisecond,
+		},
+		OnReconnect: func() { reconnected <- struct{}{} },
+	})
+	require.NoError(t, err)
+
+	defer func() { _ = client.Close() }()
+
+	events := make(chan []byte, 100)
+	client.SetEventHandler("topic", func(data []byte) { events <- data })
+
+	close(done)
+	require.NoError(t, server.Shutdown(context.Background()))
+
+	server = newTestServer(t, address, "client_reconnect_again", []string{"topic"})
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	done = make(chan struct{})
+	defer close(done)
+
+	go publishUntil(server, "topic", done)
+
+	select {
+	case <-reconnected:
+	case <-time.After(10 * time.Second):
+		t.Fatal("client is not reconnected")
+	}
+
+	// drain the events that are received before reconnecting.
+	for len(events) > 0 {
+		<-events
+	}
+
+	select {
+	case data := <-events:
+		assert.Equal(t, []byte("ping"), data)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event is received after reconnect")
+	}
+}
+
+func TestClientReconnectGiveUp(t *testing.T) {
+	address := "localhost:14264"
+
+	server := newTestServer(t, address, "client_give_up", []string{"topic"})
+
+	done := make(chan struct{})
+	go publishUntil(server, "topic", done)
+
+	disconnected := make(chan error, 2)
+
+	client, err := qsse.NewClient(address, []string{"topic"}, &qsse.ClientConfig{ //nolint:exhaustruct
+		ReconnectPolicy: &qsse.ReconnectPolicy{ //nolint:exhaustruct
+			Retry:      true,
+			RetryTimes: 1,
+			Interval:   100 * time.Millisecond,
+		},
+		OnDisconnect: func(err error) { disconnected <- err },
+	})
+	require.NoError(t, err)
+
+	defer func() { _ = client.Close() }()
+
+	close(done)
+	require.NoError(t, server.Shutdown(context.Background()))
+
+	// the connection is lost and then reconnecting gives up.
+	for _, terminal := range []bool{false, true} {
+		select {
+		case err := <-disconnected:
+			require.Error(t, err)
+			assert.Equal(t, terminal, errors.Is(err, qsse.ErrFailedToReconnect))
+		case <-time.After(15 * time.Second):
+			t.Fatal("client is not disconnected")
+		}
+	}
+}
+
+func TestClientVersionNegotiation(t *testing.T) {
+	address := "localhost:14248"
+
+	server, err := qsse.NewServer(address, []string{"topic"}, &qsse.ServerConfig{ //nolint:exhaustruct
+		Metric:   &qsse.MetricConfig{Namespace: "versions", Subsystem: "test"},
+		Versions: []int{qsse.Version2},
+	})
+	require.NoError(t, err)
+
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	// the handshake of version 2 announces the stream, so the client connects without any event.
+	client, err := qsse.NewClient(address, []string{"topic"}, &qsse.ClientConfig{ //nolint:exhaustruct
+		Versions: []int{qsse.Version2, qsse.Version1},
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Close())
+
+	_, err = qsse.NewClient(address, []string{"topic"}, &qsse.ClientConfig{ //nolint:exhaustruct
+		Versions: []int{qsse.Version1},
+	})
+	require.ErrorIs(t, err, qsse.ErrUnsupportedVersion)
+
+	_, err = qsse.NewClient(address, []string{"topic"}, &qsse.ClientConfig{ //nolint:exhaustruct
+		Versions: []int{3},
+	})
+	require.ErrorIs(t, err, qsse.ErrUnsupportedVersion)
+}
+
+func TestClientVersion1(t *testing.T) {
+	address := "localhost:14249"
+
+	server := newTestServer(t, address, "version1", []string{"topic"})
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	done := make(chan struct{})
+	go publishUntil(server, "topic", done)
+
+	received := make(chan []byte, 1)
+
+	client, err := qsse.NewClient(address, []string{"topic"}, &qsse.ClientConfig{ //nolint:exhaustruct
+		Versions: []int{qsse.Version1},
+	})
+	require.NoError(t, err)
+	close(done)
+
+	defer func() { _ = client.Close() }()
+
+	client.SetEventHandler("topic", func(data []byte) {
+		select {
+		case received <- data:
+		default:
+		}
+	})
+
+	server.Publish("topic", []byte("data"))
+
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event is not received over version 1")
+	}
+
+	_, err = client.Subscribe(context.Background(), "topic")
+	require.ErrorIs(t, err, qsse.ErrUnsupportedVersion)
+}
+
+func TestClientSession(t *testing.T) {
+	address := "localhost:14251"
+
+	server := newTestServer(t, address, "session", []string{"people", "cars"})
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	server.SetAuthorizerFunc(func(_, topic string) bool { return topic != "cars" })
+
+	client, err := qsse.NewClient(address, []string{"people", "cars", "trains"}, nil)
+	require.NoError(t, err)
+
+	defer func() { _ = client.Close() }()
+
+	session := client.Session()
+
+	assert.Equal(t, qsse.Version2, session.Version)
+	assert.NotEmpty(t, session.ID)
+	assert.Equal(t, []string{"people"}, session.Accepted)
+	assert.Equal(t, []qsse.RejectedTopic{
+		{Topic: "cars", Code: qsse.CodeNotAuthorized},
+		{Topic: "trains", Code: qsse.CodeTopicNotAvailable},
+	}, session.Rejected)
+	assert.Equal(t, qsse.DefQueueSize, session.Limits.QueueSize)
+}
+
+func TestClientNotAuthenticated(t *testing.T) {
+	address := "localhost:14252"
+
+	server := newTestServer(t, address, "not_authenticated", []string{"topic"})
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	server.SetAuthenticatorFunc(func(token string) bool { return token == "secret" })
+
+	_, err := qsse.NewClient(address, []string{"topic"}, &qsse.ClientConfig{ //nolint:exhaustruct
+		Token: "wrong",
+	})
+	require.ErrorIs(t, err, qsse.ErrNotAuthorized)
+
+	var handshakeErr *qsse.HandshakeError
+	require.ErrorAs(t, err, &handshakeErr)
+	assert.Equal(t, qsse.CodeNotAuthorized, handshakeErr.Code)
+
+	client, err := qsse.NewClient(address, []string{"topic"}, &qsse.ClientConfig{ //nolint:exhaustruct
+		Token: "secret",
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Close())
+}
+
+func TestClientSubscribe(t *testing.T) {
+	address := "localhost:14253"
+
+	server := newTestServer(t, address, "subscribe", []string{"people", "cars"})
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	server.SetAuthorizerFunc(func(_, topic string) bool { return topic != "cars" })
+
+	client, err := qsse.NewClient(address, nil, nil)
+	require.NoError(t, err)
+
+	defer func() { _ = client.Close() }()
+
+	received := make(chan []byte, 1)
+
+	client.SetMessageHandler(func(_ string, data []byte) { received <- data })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ack, err := client.Subscribe(ctx, "people", "cars", "trains")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"people"}, ack.Accepted)
+	assert.Equal(t, []qsse.RejectedTopic{
+		{Topic: "cars", Code: qsse.CodeNotAuthorized},
+		{Topic: "trains", Code: qsse.CodeTopicNotAvailable},
+	}, ack.Rejected)
+	assert.Equal(t, []string{"people"}, client.Session().Accepted)
+
+	result, err := server.PublishContext(ctx, "people", []byte("data"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"people": 1}, result.Subscribers)
+
+	select {
+	case data := <-received:
+		assert.Equal(t, []byte("data"), data)
+	case <-ctx.Done():
+		t.Fatal("event is not received after subscribe")
+	}
+
+	ack, err = client.Unsubscribe(ctx, "people")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"people"}, ack.Accepted)
+	assert.Empty(t, client.Session().Accepted)
+
+	// the topics that the server doesn't have are accepted too.
+	ack, err = client.Unsubscribe(ctx, "trains")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"trains"}, ack.Accepted)
+	assert.Empty(t, ack.Rejected)
+
+	// the server is not queuing events for the client anymore.
+	result, err = server.PublishContext(ctx, "people", []byte("data"))
+	require.NoError(t, err)
+	assert.Empty(t, result.Subscribers)
+}
+
+func TestClientWildcardSubscription(t *testing.T) {
+	address := "localhost:14256"
+
+	server := newTestServer(t, address, "wildcard", []string{"ride.1", "ride.2", "car.1"})
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	server.SetAuthorizerFunc(func(_, topic string) bool { return topic != "ride.2" })
+
+	client, err := qsse.NewClient(address, []string{"ride.*"}, nil)
+	require.NoError(t, err)
+
+	defer func() { _ = client.Close() }()
+
+	assert.Equal(t, []string{"ride.*"}, client.Session().Accepted)
+
+	received := make(chan string, 2)
+
+	client.SetMessageHandler(func(topic string, _ []byte) { received <- topic })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	subscribers := func(topic string) int {
+		result, err := server.PublishContext(ctx, topic, []byte("data"))
+		require.NoError(t, err)
+
+		return result.Subscribers[topic]
+	}
+
+	assert.Equal(t, 1, subscribers("ride.1"))
+	// the matching topics are authorized one by one.
+	assert.Equal(t, 0, subscribers("ride.2"))
+	assert.Equal(t, 0, subscribers("car.1"))
+
+	// topics which are added later are subscribed too.
+	require.NoError(t, server.AddTopics("ride.3"))
+	assert.Equal(t, 1, subscribers("ride.3"))
+
+	// events are tagged with their concrete topic.
+	topics := make([]string, 0, 2)
+
+	for range 2 {
+		select {
+		case topic := <-received:
+			topics = append(topics, topic)
+		case <-ctx.Done():
+			t.Fatal("event of wildcard subscription is not received")
+		}
+	}
+
+	assert.ElementsMatch(t, []string{"ride.1", "ride.3"}, topics)
+
+	_, err = client.Subscribe(ctx, "ride.1")
+	require.NoError(t, err)
+
+	// topics subscribed by name are kept.
+	_, err = client.Unsubscribe(ctx, "ride.*")
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, subscribers("ride.1"))
+	assert.Equal(t, 0, subscribers("ride.3"))
+}
+
+func TestClientRefreshToken(t *testing.T) {
+	address := "localhost:14261"
+
+	server, err := qsse.NewServer(address, []string{"topic"}, &qsse.ServerConfig{ //nolint:exhaustruct
+		Metric: &qsse.MetricConfig{ //nolint:exhaustruct
+			Namespace: "refresh_token",
+			Subsystem: "test",
+		},
+		ExpiryNotice: 1500 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	server.SetConnAuthenticatorFunc(func(_ context.Context, info *auth.ConnInfo) (*auth.Principal, error) {
+		if !strings.HasPrefix(info.Token, "token") {
+			return nil, auth.NewError("invalid token", nil)
+		}
+
+		return &auth.Principal{ //nolint:exhaustruct
+			ID:        "42",
+			ExpiresAt: time.Now().Add(2 * time.Second),
+			Token:     info.Token,
+		}, nil
+	})
+
+	var refreshes atomic.Int32
+
+	expiring := make(chan int, 1)
+	disconnected := make(chan error, 1)
+
+	client, err := qsse.NewClient(address, []string{"topic"}, &qsse.ClientConfig{ //nolint:exhaustruct
+		Token:        "token",
+		OnDisconnect: func(err error) { disconnected <- err },
+		RefreshToken: func(_ context.Context) (string, error) {
+			return fmt.Sprintf("token-%d", refreshes.Add(1)), nil
+		},
+	})
+	require.NoError(t, err)
+
+	defer func() { _ = client.Close() }()
+
+	client.SetErrorHandler(func(code int, _ map[string]any) {
+		select {
+		case expiring <- code:
+		default:
+		}
+	})
+
+	select {
+	case code := <-expiring:
+		assert.Equal(t, qsse.CodeTokenExpiring, code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("client is not notified of token expiry")
+	}
+
+	// the session outlives the first token.
+	select {
+	case err := <-disconnected:
+		t.Fatalf("client is disconnected after refreshing its token: %v", err)
+	case <-time.After(3 * time.Second):
+	}
+
+	assert.Positive(t, refreshes.Load())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = client.Refresh(ctx, "wrong")
+
+	var controlErr *qsse.ControlError
+	require.ErrorAs(t, err, &controlErr)
+	assert.Equal(t, qsse.CodeNotAuthorized, controlErr.Code)
+	require.ErrorIs(t, err, qsse.ErrNotAuthorized)
+
+	// the client without RefreshToken is disconnected when its token expires.
+	expired := make(chan error, 1)
+
+	_, err = qsse.NewClient(address, []string{"topic"}, &qsse.ClientConfig{ //nolint:exhaustruct
+		Token:        "token",
+		OnDisconnect: func(err error) { expired <- err },
+	})
+	require.NoError(t, err)
+
+	select {
+	case err := <-expired:
+		var connectionErr *qsse.ConnectionError
+
+		require.ErrorAs(t, err, &connectionErr)
+		assert.Equal(t, qsse.CodeNotAuthorized, connectionErr.Code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("client is not disconnected when its token expires")
+	}
+}
+
+func TestClientRefreshNarrowsTopics(t *testing.T) {
+	address := "localhost:14266"
+
+	server := newTestServer(t, address, "refresh_narrow", []string{"people", "cars"})
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	server.SetConnAuthenticatorFunc(func(_ context.Context, info *auth.ConnInfo) (*auth.Principal, error) {
+		return &auth.Principal{ID: "42", Roles: nil, Claims: nil, ExpiresAt: time.Time{}, Token: info.Token}, nil
+	})
+
+	server.SetAuthorizerFunc(func(token, topic string) bool { return token == "full" || topic == "people" })
+
+	client, err := qsse.NewClient(address, []string{"people", "cars"}, &qsse.ClientConfig{ //nolint:exhaustruct
+		Token: "full",
+	})
+	require.NoError(t, err)
+
+	defer func() { _ = client.Close() }()
+
+	assert.Equal(t, []string{"people", "cars"}, client.Session().Accepted)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the new token is not authorized for cars, so the client is detached from it.
+	require.NoError(t, client.Refresh(ctx, "narrow"))
+
+	result, err := server.PublishContext(ctx, "cars", []byte("data"))
+	require.NoError(t, err)
+	assert.Empty(t, result.Subscribers)
+
+	result, err = server.PublishContext(ctx, "people", []byte("data"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"people": 1}, result.Subscribers)
+}
+
+func TestClientRefreshAnotherPrincipal(t *testing.T) {
+	address := "localhost:14268"
+
+	server := newTestServer(t, address, "refresh_another", []string{"people"})
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	// the principals of alice and bob have no ID like the principals of auth.TokenAuthenticator.
+	server.SetConnAuthenticatorFunc(func(_ context.Context, info *auth.ConnInfo) (*auth.Principal, error) {
+		id := info.Token
+		if id == "alice" || id == "bob" {
+			id = ""
+		}
+
+		return &auth.Principal{ID: id, Roles: nil, Claims: nil, ExpiresAt: time.Time{}, Token: info.Token}, nil
+	})
+
+	alice, err := qsse.NewClient(address, []string{"people"}, &qsse.ClientConfig{Token: "alice"}) //nolint:exhaustruct
+	require.NoError(t, err)
+
+	defer func() { _ = alice.Close() }()
+
+	bob, err := qsse.NewClient(address, []string{"people"}, &qsse.ClientConfig{Token: "bob"}) //nolint:exhaustruct
+	require.NoError(t, err)
+
+	defer func() { _ = bob.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the principals without ID can't refresh into each other.
+	for client, token := range map[qsse.Client]string{alice: "bob", bob: "alice"} {
+		var controlErr *qsse.ControlError
+
+		require.ErrorAs(t, client.Refresh(ctx, token), &controlErr)
+		assert.Equal(t, qsse.CodeNotAuthorized, controlErr.Code)
+	}
+
+	// principals with different IDs can't either.
+	carol, err := qsse.NewClient(address, []string{"people"}, &qsse.ClientConfig{Token: "carol"}) //nolint:exhaustruct
+	require.NoError(t, err)
+
+	defer func() { _ = carol.Close() }()
+
+	var controlErr *qsse.ControlError
+
+	require.ErrorAs(t, carol.Refresh(ctx, "dave"), &controlErr)
+	assert.Equal(t, qsse.CodeNotAuthorized, controlErr.Code)
+	require.NoError(t, carol.Refresh(ctx, "carol"))
+}
@@ -1,5 +1,55 @@
 package qsse
 
+import "github.com/snapp-incubator/qsse/internal"
+
+var (
+	// ErrServerClosed is returned by Server.Shutdown and publishing when the server is already shutting down.
+	ErrServerClosed = internal.ErrServerClosed
+	// ErrClientClosed is returned by Client.Close when the client is already closed.
+	ErrClientClosed = internal.ErrClientClosed
+	// ErrFailedToReconnect is wrapped by the error passed to ClientConfig.OnDisconnect when the client
+	// gives up reconnecting, e.g. ReconnectPolicy.RetryTimes is reached. the client is not used afterward.
+	ErrFailedToReconnect = internal.ErrFailedToReconnect
+	// ErrNotAuthorized is wrapped by the error of NewClient when the client is not authenticated.
+	ErrNotAuthorized = internal.ErrNotAuthorized
+	// ErrNoTopicMatched is returned by publishing when no topic matches the published topic.
+	ErrNoTopicMatched = internal.ErrNoTopicMatched
+	// ErrQueueFull is returned by Server.TryPublish when the distributor of a matched topic is saturated.
+	ErrQueueFull = internal.ErrQueueFull
+	// ErrEventTooLarge is returned by publishing when the event doesn't fit in the maximum frame size of clients.
+	ErrEventTooLarge = internal.ErrEventTooLarge
+	// ErrUnsupportedVersion is returned by NewServer and NewClient when a protocol version is not supported
+	// and wrapped by the error of NewClient when the client and server have no protocol version in common.
+	ErrUnsupportedVersion = internal.ErrUnsupportedVersion
+	// ErrTopicNotAvailable is returned by Server.RemoveTopic when the topic doesn't exist.
+	ErrTopicNotAvailable = internal.ErrTopicNotAvailable
+	// ErrInvalidTopic is returned by NewServer, Server.AddTopics and publishing when a topic or pattern
+	// doesn't follow the topic syntax.
+	ErrInvalidTopic = internal.ErrInvalidTopic
+	// ErrNoClientCAs is returned by NewServer when MTLS has no ClientCAs, the system roots would accept
+	// any publicly issued client certificate otherwise.
+	ErrNoClientCAs = internal.ErrNoClientCAs
+)
+
+// HandshakeError is returned by NewClient when the server refuses the offer, e.g. the client is not
+// authenticated. it wraps the error of its code, so errors.Is(err, ErrNotAuthorized) can be used.
+type HandshakeError = internal.HandshakeError
+
+// ControlError is returned by Client.Subscribe and Client.Unsubscribe when the server refuses the request,
+// e.g. it is shutting down. it wraps the error of its code, so errors.Is(err, ErrServerClosed) can be used.
+type ControlError = internal.ControlError
+
+// ConnectionError is passed to ClientConfig.OnDisconnect when the connection to server is lost.
+type ConnectionError = internal.ConnectionError
+
+// protocol versions.
+const (
+	// Version1 is the protocol of clients before versioning.
+	Version1 = internal.Version1
+	// Version2 answers the offer with a handshake, so clients get their session without waiting for the first event.
+	Version2 = internal.Version2
+)
+
 // error codes.
 const (
 	CodeNotAuthorized = iota + 1
@@ -7,4 +57,10 @@ const (
 	CodeFailedToCreateStream
 	CodeFailedToSendOffer
 	CodeUnknown
+	CodeGoingAway
+	CodeSlowConsumer
+	CodeUnsupportedVersion
+	CodeInvalidTopic
+	// CodeTokenExpiring notifies the client that its token expires at the unix time of "expires_at" in data.
+	CodeTokenExpiring
 )
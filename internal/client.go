@@ -2,23 +2,70 @@ package internal
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"maps"
+	"slices"
+	"sync"
 
 	quic "github.com/quic-go/quic-go"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
 
 type Client struct {
 	Connection *quic.Conn
 	Token      string
-	Topics     []string
-	Logger     *zap.Logger
-	Finder     Finder
+	// Metadata is sent in the offer for the authenticator of the server.
+	Metadata map[string]string
+	Topics   []string
+	Logger   *zap.Logger
+	Finder   Finder
+	// LastEventIDs is the ID of the latest received event per topic which is sent
+	// on reconnect, so the server replays the missed events.
+	LastEventIDs map[string]uint64
+
+	// Reconnect dials the server again after the connection is lost.
+	// the client doesn't reconnect when it is nil.
+	Reconnect func(ctx context.Context) (*quic.Conn, error)
+	// RefreshToken returns a new token when the server notifies that the token is expiring.
+	// the client is disconnected when its token expires if it is nil.
+	RefreshToken func(ctx context.Context) (string, error)
 
 	OnEvent   map[string]func(event []byte)
 	OnMessage func(topic string, message []byte)
 	OnError   func(code int, data map[string]any)
+
+	OnConnect    func()
+	OnDisconnect func(err error)
+	OnReconnect  func()
+
+	once   sync.Once
+	ctx    context.Context //nolint:containedctx
+	cancel context.CancelFunc
+
+	lock      sync.RWMutex
+	session   Session
+	closed    atomic.Bool
+	goingAway atomic.Bool
+
+	// topicIndex indexes Topics for matching the topics of events, it is built on first use.
+	topicIndex *Trie
+
+	// controlLock serializes control requests, so each response follows its request.
+	controlLock sync.Mutex
+	control     *controlStream
+	controlID   uint64
+}
+
+// controlStream is the control stream of a connection.
+type controlStream struct {
+	connection *quic.Conn
+	stream     *quic.Stream
+	reader     *bufio.Reader
 }
 
 // DefaultOnMessage Default handler for processing incoming events without a handler.
@@ -32,23 +79,208 @@ var DefaultOnError = func(code int, data map[string]any) { //nolint:gochecknoglo
 	log.Printf("code: %d, data: %v", code, data)
 }
 
+// Handshake sends the offer with the current topics to the server and accepts the events stream.
+// in protocol version 2, the server answers the offer with the session. it returns a
+// HandshakeError when the server refuses the offer.
+func (c *Client) Handshake(ctx context.Context) (*bufio.Reader, error) {
+	connection := c.connection()
+	version := ConnectionVersion(connection)
+
+	c.lock.RLock()
+	offer := NewOffer(c.Token, c.Topics, maps.Clone(c.LastEventIDs), version)
+	offer.Metadata = c.Metadata
+	c.lock.RUnlock()
+
+	bytes, err := json.Marshal(offer)
+	if err != nil {
+		c.Logger.Error("failed to marshal offer", zap.Error(err))
+
+		return nil, ErrFailedToMarshal
+	}
+
+	stream, err := connection.OpenUniStream()
+	if err != nil {
+		c.Logger.Error("failed to open send stream", zap.Error(err))
+		c.closeConnection(connection, CodeFailedToCreateStream, ErrFailedToCreateStream)
+
+		return nil, ErrFailedToCreateStream
+	}
+
+	err = WriteData(bytes, stream)
+	if err != nil {
+		c.Logger.Error("failed to send offer to server", zap.Error(err))
+		c.closeConnection(connection, CodeFailedToSendOffer, ErrFailedToSendOffer)
+
+		return nil, ErrFailedToSendOffer
+	}
+
+	_ = stream.Close()
+
+	receiveStream, err := connection.AcceptUniStream(ctx)
+	if err != nil {
+		c.Logger.Error("failed to open receive stream", zap.Error(err))
+
+		var appErr *quic.ApplicationError
+		if errors.As(err, &appErr) {
+			return nil, &HandshakeError{Code: int(appErr.ErrorCode), Message: appErr.ErrorMessage, Err: err}
+		}
+
+		c.closeConnection(connection, CodeFailedToCreateStream, ErrFailedToCreateStream)
+
+		return nil, ErrFailedToCreateStream
+	}
+
+	reader := bufio.NewReader(receiveStream)
+
+	// servers of version 1 don't report the session.
+	session := &Session{Version: version} //nolint:exhaustruct
+
+	if version >= Version2 {
+		session, err = ReadHandshake(reader)
+		if err != nil {
+			c.Logger.Error("failed to read handshake", zap.Error(err))
+			c.closeConnection(connection, CodeFailedToCreateStream, ErrFailedToCreateStream)
+
+			return nil, ErrFailedToCreateStream
+		}
+
+		if session.Version != version {
+			c.closeConnection(connection, CodeUnsupportedVersion, ErrUnsupportedVersion)
+
+			return nil, &HandshakeError{
+				Code:    CodeUnsupportedVersion,
+				Message: fmt.Sprintf("server answered version %d over %d", session.Version, version),
+				Err:     ErrUnsupportedVersion,
+			}
+		}
+	}
+
+	c.lock.Lock()
+	c.session = *session
+	c.lock.Unlock()
+
+	c.goingAway.Store(false)
+
+	return reader, nil
+}
+
+// Listen accepts events in background until the client is closed. OnDisconnect is called
+// with the reason whenever the connection is lost, then the client reconnects and sends
+// the offer again if Reconnect is set. OnDisconnect is called again with an error wrapping
+// ErrFailedToReconnect when reconnecting gives up.
+func (c *Client) Listen(reader *bufio.Reader) {
+	go func() {
+		for {
+			err := c.AcceptEvents(reader)
+			if err != nil {
+				c.Logger.Warn("disconnected from server", zap.Error(err))
+
+				_ = c.connection().CloseWithError(0, "")
+			}
+
+			if c.OnDisconnect != nil {
+				c.OnDisconnect(err)
+			}
+
+			if err == nil || c.Reconnect == nil {
+				return
+			}
+
+			reader, err = c.reconnect()
+			if reader == nil {
+				if err != nil && c.OnDisconnect != nil {
+					c.OnDisconnect(err)
+				}
+
+				return
+			}
+
+			c.Logger.Info("reconnected to server")
+
+			if c.OnReconnect != nil {
+				c.OnReconnect()
+			}
+		}
+	}()
+}
+
+// reconnect dials the server and sends the offer again. it returns a nil reader when the client is closed
+// and an error wrapping ErrFailedToReconnect when it gives up.
+func (c *Client) reconnect() (*bufio.Reader, error) {
+	connection, err := c.Reconnect(c.context())
+	if err != nil {
+		if c.closed.Load() {
+			return nil, nil //nolint:nilnil
+		}
+
+		c.Logger.Error("failed to reconnect", zap.Error(err))
+
+		if !errors.Is(err, ErrFailedToReconnect) {
+			err = fmt.Errorf("%w: %w", ErrFailedToReconnect, err)
+		}
+
+		return nil, err
+	}
+
+	c.lock.Lock()
+	c.Connection = connection
+	c.lock.Unlock()
+
+	// client may be closed while it was dialing.
+	if c.closed.Load() {
+		_ = connection.CloseWithError(0, "")
+
+		return nil, nil //nolint:nilnil
+	}
+
+	reader, err := c.Handshake(c.context())
+	if err != nil {
+		if c.closed.Load() {
+			return nil, nil //nolint:nilnil
+		}
+
+		c.Logger.Error("failed to subscribe after reconnect", zap.Error(err))
+
+		return nil, fmt.Errorf("%w: %w", ErrFailedToReconnect, err)
+	}
+
+	return reader, nil
+}
+
+// Close closes the connection to the server. OnDisconnect is called with nil error
+// after the client stops accepting events.
+func (c *Client) Close() error {
+	if !c.closed.CompareAndSwap(false, true) {
+		return ErrClientClosed
+	}
+
+	c.context()
+	c.cancel()
+
+	return c.connection().CloseWithError(0, "")
+}
+
 // AcceptEvents reads events from the stream and calls the proper handler.
 // order of calling handlers is as follows:
 // 1. OnError if topic is "error"
-// 2. OnEvent[topic]
-// 3. OnMessage.
-func (c *Client) AcceptEvents(reader *bufio.Reader) {
+// 2. OnEvent of the topic and the patterns matching it
+// 3. OnMessage with the topic when there is no handler.
+// it returns nil when the client is closed and a ConnectionError when the connection is lost.
+func (c *Client) AcceptEvents(reader *bufio.Reader) error {
 	for {
-		bytes, err := reader.ReadBytes(DELIMITER)
-		if err != nil {
-			c.Logger.Fatal("failed to read event", zap.Error(err))
+		event, err := ReadEvent(reader)
+		if errors.Is(err, ErrInvalidEvent) {
+			c.Logger.Error("failed to decode event", zap.Error(err))
+
+			continue
 		}
 
-		var event Event
-		if err = json.Unmarshal(bytes, &event); err != nil {
-			c.Logger.Error("failed to unmarshal event", zap.Error(err))
+		if err != nil {
+			if c.closed.Load() {
+				return nil
+			}
 
-			continue
+			return c.connectionError(err)
 		}
 
 		switch event.Topic {
@@ -58,30 +290,300 @@ func (c *Client) AcceptEvents(reader *bufio.Reader) {
 				c.Logger.Error("error in unmarshalling", zap.Error(e))
 			}
 
-			c.OnError(err.Code, err.Data)
-		default:
-			topics := c.Finder.FindRelatedWildcardTopics(event.Topic, c.Topics)
-
-			if len(topics) > 0 {
-				for _, topic := range topics {
-					eventHandler, ok := c.OnEvent[topic]
-					if ok {
-						eventHandler(event.Data)
-					} else {
-						c.OnMessage(topic, event.Data)
-					}
-				}
-			} else {
-				c.OnMessage(event.Topic, event.Data)
+			if err.Code == CodeGoingAway {
+				c.goingAway.Store(true)
 			}
+
+			if err.Code == CodeTokenExpiring && c.RefreshToken != nil {
+				go c.refreshToken()
+			}
+
+			c.lock.Lock()
+			// the topic is removed from the server.
+			if topic, ok := err.Data["topic"].(string); ok && err.Code == CodeTopicNotAvailable {
+				c.session.Accepted = slices.DeleteFunc(c.session.Accepted, func(t string) bool { return t == topic })
+			}
+
+			onError := c.OnError
+			c.lock.Unlock()
+
+			onError(err.Code, err.Data)
+		default:
+			c.handleEvent(event)
 		}
 	}
 }
 
+// index returns the index of topics. lock must be held.
+func (c *Client) index() *Trie {
+	if c.topicIndex == nil {
+		c.topicIndex = NewTrie(c.Topics...)
+	}
+
+	return c.topicIndex
+}
+
+// handleEvent calls the handlers of topics related to the event.
+func (c *Client) handleEvent(event Event) {
+	c.lock.Lock()
+	if event.ID > 0 {
+		if c.LastEventIDs == nil {
+			c.LastEventIDs = make(map[string]uint64)
+		}
+
+		c.LastEventIDs[event.Topic] = event.ID
+	}
+
+	// events of topics matching wildcard subscriptions are handled by the handlers of the patterns.
+	handlers := make([]func([]byte), 0, 1)
+
+	for _, topic := range c.index().MatchPatterns(event.Topic) {
+		if handler := c.OnEvent[topic]; handler != nil {
+			handlers = append(handlers, handler)
+		}
+	}
+
+	onMessage := c.OnMessage
+	c.lock.Unlock()
+
+	// OnMessage is called once with the concrete topic when no handler is set.
+	if len(handlers) == 0 {
+		onMessage(event.Topic, event.Data)
+
+		return
+	}
+
+	for _, handler := range handlers {
+		handler(event.Data)
+	}
+}
+
+// connectionError converts stream read errors to ConnectionError.
+func (c *Client) connectionError(err error) *ConnectionError {
+	code := CodeUnknown
+	if c.goingAway.Load() {
+		code = CodeGoingAway
+	}
+
+	message := err.Error()
+
+	var appErr *quic.ApplicationError
+	if errors.As(err, &appErr) {
+		code = int(appErr.ErrorCode)
+		message = appErr.ErrorMessage
+	}
+
+	return &ConnectionError{Code: code, Message: message, Err: err}
+}
+
+// context returns the client context which is canceled on close.
+func (c *Client) context() context.Context {
+	c.once.Do(func() {
+		c.ctx, c.cancel = context.WithCancel(context.Background())
+	})
+
+	return c.ctx
+}
+
+// connection returns the current connection to the server.
+func (c *Client) connection() *quic.Conn {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.Connection
+}
+
+func (c *Client) closeConnection(connection *quic.Conn, code uint64, err error) {
+	if err := CloseClientConnection(connection, code, err); err != nil {
+		c.Logger.Error("failed to close client connection", zap.Error(err))
+	}
+}
+
+// Session returns the session of the current connection.
+func (c *Client) Session() Session {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	session := c.session
+	session.Accepted = slices.Clone(session.Accepted)
+	session.Rejected = slices.Clone(session.Rejected)
+
+	return session
+}
+
+// Subscribe subscribes the connection to topics without reconnecting. the server answers which topics are
+// accepted, the rejected ones are reported with the reason. the accepted topics are offered again on reconnect.
+func (c *Client) Subscribe(ctx context.Context, topics ...string) (Ack, error) {
+	response, err := c.request(ctx, &ControlRequest{ID: 0, Op: ControlSubscribe, Topics: topics, Token: ""})
+	if err != nil {
+		return Ack{Accepted: nil, Rejected: nil}, err
+	}
+
+	c.lock.Lock()
+	for _, topic := range response.Accepted {
+		c.Topics = AppendIfMissing(c.Topics, topic)
+		c.index().Insert(topic)
+		c.session.Accepted = AppendIfMissing(c.session.Accepted, topic)
+	}
+	c.lock.Unlock()
+
+	return response.Ack, nil
+}
+
+// Unsubscribe unsubscribes the connection from topics without reconnecting.
+// events that are already sent on the topics may still be received.
+func (c *Client) Unsubscribe(ctx context.Context, topics ...string) (Ack, error) {
+	response, err := c.request(ctx, &ControlRequest{ID: 0, Op: ControlUnsubscribe, Topics: topics, Token: ""})
+	if err != nil {
+		return Ack{Accepted: nil, Rejected: nil}, err
+	}
+
+	c.lock.Lock()
+	c.Topics = slices.DeleteFunc(c.Topics, func(topic string) bool { return slices.Contains(response.Accepted, topic) })
+	for _, topic := range response.Accepted {
+		c.index().Delete(topic)
+	}
+
+	c.session.Accepted = slices.DeleteFunc(c.session.Accepted, func(topic string) bool {
+		return slices.Contains(response.Accepted, topic)
+	})
+	c.lock.Unlock()
+
+	return response.Ack, nil
+}
+
+// Refresh authenticates the connection again by token before the current token expires, so the session
+// is kept. the token is offered on reconnect afterward. it returns a ControlError with CodeNotAuthorized
+// when the token is refused.
+func (c *Client) Refresh(ctx context.Context, token string) error {
+	response, err := c.request(ctx, &ControlRequest{ID: 0, Op: ControlRefresh, Topics: nil, Token: token})
+	if err != nil {
+		return err
+	}
+
+	// the new token may not be authorized for all the topics, the server detaches them.
+	for _, rejected := range response.Rejected {
+		c.Logger.Warn("topic is rejected on refresh", zap.String("topic", rejected.Topic), zap.Int("code", rejected.Code))
+	}
+
+	c.lock.Lock()
+	c.Token = token
+	c.lock.Unlock()
+
+	return nil
+}
+
+// refreshToken refreshes the connection by the token of RefreshToken.
+func (c *Client) refreshToken() {
+	token, err := c.RefreshToken(c.context())
+	if err != nil {
+		c.Logger.Error("failed to get a new token", zap.Error(err))
+
+		return
+	}
+
+	if err := c.Refresh(c.context(), token); err != nil {
+		c.Logger.Error("failed to refresh token", zap.Error(err))
+	}
+}
+
+// request sends a control request and waits for its response until ctx is done.
+// the server may still apply a request which is given up.
+func (c *Client) request(ctx context.Context, request *ControlRequest) (*ControlResponse, error) {
+	op := request.Op
+
+	if c.closed.Load() {
+		return nil, ErrClientClosed
+	}
+
+	connection := c.connection()
+	if version := ConnectionVersion(connection); version < Version2 {
+		return nil, fmt.Errorf("%w: %s needs version %d but connection is %d", ErrUnsupportedVersion, op, Version2, version)
+	}
+
+	c.controlLock.Lock()
+	defer c.controlLock.Unlock()
+
+	control, err := c.controlStream(ctx, connection)
+	if err != nil {
+		return nil, err
+	}
+
+	c.controlID++
+	request.ID = c.controlID
+
+	response, err := control.roundTrip(ctx, request)
+	if err != nil {
+		// the stream is broken or a late response is on the way, so the next request uses a new one.
+		control.stream.CancelRead(0)
+		control.stream.CancelWrite(0)
+		c.control = nil
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		return nil, err
+	}
+
+	if response.Code != 0 {
+		return nil, &ControlError{Code: response.Code, Op: op}
+	}
+
+	return response, nil
+}
+
+// controlStream returns the control stream of connection and opens it on the first request.
+func (c *Client) controlStream(ctx context.Context, connection *quic.Conn) (*controlStream, error) {
+	if c.control != nil && c.control.connection == connection {
+		return c.control, nil
+	}
+
+	stream, err := connection.OpenStreamSync(ctx)
+	if err != nil {
+		c.Logger.Error("failed to open control stream", zap.Error(err))
+
+		return nil, fmt.Errorf("%w: %w", ErrFailedToCreateStream, err)
+	}
+
+	c.control = &controlStream{connection: connection, stream: stream, reader: bufio.NewReader(stream)}
+
+	return c.control, nil
+}
+
+// roundTrip writes the request and reads its response. it is interrupted when ctx is done.
+func (s *controlStream) roundTrip(ctx context.Context, request *ControlRequest) (*ControlResponse, error) {
+	stop := context.AfterFunc(ctx, func() {
+		s.stream.CancelRead(0)
+		s.stream.CancelWrite(0)
+	})
+	defer stop()
+
+	if err := WriteControl(s.stream, request); err != nil {
+		return nil, err
+	}
+
+	var response ControlResponse
+	if err := ReadControl(s.reader, &response); err != nil {
+		return nil, err
+	}
+
+	if response.ID != request.ID {
+		return nil, fmt.Errorf("%w: response %d to request %d", ErrInvalidControl, response.ID, request.ID)
+	}
+
+	return &response, nil
+}
+
 // SetEventHandler sets the handler for the given topic.
+// topic is only offered on reconnect, use Subscribe to subscribe the current connection to it.
 func (c *Client) SetEventHandler(topic string, handler func([]byte)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	if IsSubscribeTopicValid(topic, c.Topics) {
 		c.Topics = AppendIfMissing(c.Topics, topic)
+		c.index().Insert(topic)
 		c.OnEvent[topic] = handler
 	} else {
 		c.Logger.Error("topic is not valid")
@@ -90,10 +592,16 @@ func (c *Client) SetEventHandler(topic string, handler func([]byte)) {
 
 // SetErrorHandler sets the handler for "error" topic.
 func (c *Client) SetErrorHandler(handler func(code int, data map[string]any)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	c.OnError = handler
 }
 
 // SetMessageHandler sets the handler for all topics without handler and "error" topic.
 func (c *Client) SetMessageHandler(handler func(topic string, message []byte)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	c.OnMessage = handler
 }
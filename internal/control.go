@@ -0,0 +1,257 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	quic "github.com/quic-go/quic-go"
+	"github.com/snapp-incubator/qsse/internal/frame"
+	"go.uber.org/zap"
+)
+
+// operations of control requests.
+const (
+	ControlSubscribe   = "subscribe"
+	ControlUnsubscribe = "unsubscribe"
+	ControlRefresh     = "refresh"
+)
+
+// ControlRequest changes the topics of a connection. clients of version 2 send it on
+// their bidirectional control stream and the server answers it with a ControlResponse.
+type ControlRequest struct {
+	// ID is echoed by the response.
+	ID     uint64   `json:"id"`
+	Op     string   `json:"op"`
+	Topics []string `json:"topics,omitempty"`
+	// Token is the new token of refresh.
+	Token string `json:"token,omitempty"`
+}
+
+// Ack is the answer to the topics of a control request.
+type Ack struct {
+	// Accepted are the requested topics that the client is subscribed to on subscribe
+	// or that the client is not subscribed to anymore on unsubscribe. the topics matching
+	// a wildcard subscription are still received after they are unsubscribed by name.
+	Accepted []string `json:"accepted,omitempty"`
+	// Rejected are the requested topics that are not changed with the reason.
+	Rejected []RejectedTopic `json:"rejected,omitempty"`
+}
+
+// ControlResponse answers the control request of the same ID. Code is set when the whole request is refused.
+type ControlResponse struct {
+	ID   uint64 `json:"id"`
+	Code int    `json:"code,omitempty"`
+	Ack
+}
+
+// WriteControl writes v as a control frame.
+func WriteControl(writer io.Writer, v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshaling control failed %w", err)
+	}
+
+	bytes := frame.Encode(&frame.Frame{
+		Type:    frame.TypeControl,
+		Flags:   0,
+		Topic:   "",
+		ID:      0,
+		Headers: nil,
+		Payload: payload,
+	})
+
+	if _, err := writer.Write(bytes); err != nil {
+		return fmt.Errorf("write on stream failed %w", err)
+	}
+
+	return nil
+}
+
+// ReadControl reads a control frame into v.
+func ReadControl(reader *bufio.Reader, v any) error {
+	f, err := frame.Decode(reader)
+	if err != nil {
+		return fmt.Errorf("failed to read control: %w", err)
+	}
+
+	if f.Type != frame.TypeControl {
+		return fmt.Errorf("failed to read control: %w", ErrInvalidControl)
+	}
+
+	if err := json.Unmarshal(f.Payload, v); err != nil {
+		return fmt.Errorf("failed to read control: %w: %w", ErrInvalidControl, err)
+	}
+
+	return nil
+}
+
+// serveControl accepts the control streams of the connection until it is closed.
+func (s *Server) serveControl(connection *quic.Conn, subscriber *Subscriber) {
+	s.connectionGroup.Add(1)
+
+	go func() {
+		defer s.connectionGroup.Done()
+
+		for {
+			stream, err := connection.AcceptStream(connection.Context())
+			if err != nil {
+				return
+			}
+
+			s.connectionGroup.Add(1)
+
+			go func() {
+				defer s.connectionGroup.Done()
+
+				s.handleControl(connection, stream, subscriber)
+			}()
+		}
+	}()
+}
+
+// handleControl answers the requests of a control stream one by one.
+func (s *Server) handleControl(connection *quic.Conn, stream *quic.Stream, subscriber *Subscriber) {
+	defer func() { _ = stream.Close() }()
+
+	reader := bufio.NewReader(stream)
+
+	for {
+		var request ControlRequest
+		if err := ReadControl(reader, &request); err != nil {
+			// the stream is closed or reset by the client otherwise.
+			if isInvalidControl(err) {
+				subscriber.Logger.Warn("failed to read control request", zap.Error(err))
+				stream.CancelRead(quic.StreamErrorCode(CodeUnknown))
+			}
+
+			return
+		}
+
+		response := s.control(connection, subscriber, &request)
+
+		if err := WriteControl(stream, response); err != nil {
+			subscriber.Logger.Warn("failed to write control response", zap.Error(err))
+
+			return
+		}
+	}
+}
+
+// isInvalidControl reports whether reading a control message failed because it is invalid.
+func isInvalidControl(err error) bool {
+	return errors.Is(err, ErrInvalidControl) ||
+		errors.Is(err, frame.ErrMalformed) ||
+		errors.Is(err, frame.ErrTooLarge) ||
+		errors.Is(err, frame.ErrUnsupportedVersion)
+}
+
+// control applies the request on the subscriber.
+func (s *Server) control(connection *quic.Conn, subscriber *Subscriber, request *ControlRequest) *ControlResponse {
+	response := &ControlResponse{ID: request.ID, Code: 0, Ack: Ack{Accepted: nil, Rejected: nil}}
+
+	if s.closing.Load() {
+		response.Code = CodeGoingAway
+
+		return response
+	}
+
+	switch request.Op {
+	case ControlSubscribe:
+		return s.subscribe(subscriber, request)
+	case ControlUnsubscribe:
+		return s.unsubscribe(subscriber, request)
+	case ControlRefresh:
+		return s.refresh(connection, subscriber, request)
+	default:
+		subscriber.Logger.Warn("unknown control operation", zap.String("op", request.Op))
+
+		response.Code = CodeUnknown
+
+		return response
+	}
+}
+
+// subscribe adds the subscriber to the requested topics that the client is authorized for.
+// the subscriber receives the events which are published afterward.
+func (s *Server) subscribe(subscriber *Subscriber, request *ControlRequest) *ControlResponse {
+	accepted, rejected := s.checkTopics(subscriber, request.Topics)
+
+	response := &ControlResponse{
+		ID:   request.ID,
+		Code: 0,
+		Ack:  Ack{Accepted: make([]string, 0, len(accepted)), Rejected: rejected},
+	}
+
+	for _, topic := range accepted {
+		err := ErrTopicNotAvailable
+
+		if TopicHasWildcard(topic) {
+			err = s.subscribePattern(subscriber, topic, nil)
+		} else if source, ok := s.eventSource(topic); ok {
+			subscriber.choose(topic)
+
+			err = source.AddSubscriber(subscriber, 0, false)
+		}
+
+		if errors.Is(err, ErrServerClosed) || errors.Is(err, ErrWriterClosed) {
+			return &ControlResponse{ID: request.ID, Code: CodeGoingAway, Ack: Ack{Accepted: nil, Rejected: nil}}
+		}
+
+		// the topic is removed after it is accepted.
+		if errors.Is(err, ErrTopicNotAvailable) {
+			response.Rejected = append(response.Rejected, RejectedTopic{Topic: topic, Code: CodeTopicNotAvailable})
+
+			continue
+		}
+
+		if err != nil {
+			subscriber.Logger.Warn("failed to add subscriber", zap.String("topic", topic), zap.Error(err))
+			response.Rejected = append(response.Rejected, RejectedTopic{Topic: topic, Code: CodeUnknown})
+
+			continue
+		}
+
+		response.Accepted = append(response.Accepted, topic)
+	}
+
+	subscriber.Logger.Info("client subscribed to topics", zap.Strings("topics", response.Accepted))
+
+	return response
+}
+
+// unsubscribe removes the subscriber from the requested topics and patterns. topics that the client
+// is not subscribed to or the server doesn't have anymore are accepted, so retrying an unsubscribe has no effect.
+func (s *Server) unsubscribe(subscriber *Subscriber, request *ControlRequest) *ControlResponse {
+	response := &ControlResponse{
+		ID:   request.ID,
+		Code: 0,
+		Ack:  Ack{Accepted: make([]string, 0, len(request.Topics)), Rejected: make([]RejectedTopic, 0)},
+	}
+
+	for _, topic := range request.Topics {
+		if TopicHasWildcard(topic) {
+			s.unsubscribePattern(subscriber, topic)
+
+			response.Accepted = append(response.Accepted, topic)
+
+			continue
+		}
+
+		// the choice is cleared even when the topic is removed, so a topic with the same name isn't attached.
+		subscriber.unchoose(topic)
+
+		// the topic is kept when it matches a wildcard subscription.
+		if source, ok := s.eventSource(topic); ok && !subscriber.wants(topic) {
+			source.RemoveSubscriber(subscriber)
+		}
+
+		response.Accepted = append(response.Accepted, topic)
+	}
+
+	subscriber.Logger.Info("client unsubscribed from topics", zap.Strings("topics", response.Accepted))
+
+	return response
+}
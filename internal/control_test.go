@@ -0,0 +1,47 @@
+package internal_test
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/snapp-incubator/qsse/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestControl(t *testing.T) {
+	t.Parallel()
+
+	var stream bytes.Buffer
+
+	request := internal.ControlRequest{ID: 1, Op: internal.ControlSubscribe, Topics: []string{"people", "cars"}}
+	response := internal.ControlResponse{
+		ID:   1,
+		Code: 0,
+		Ack: internal.Ack{
+			Accepted: []string{"people"},
+			Rejected: []internal.RejectedTopic{{Topic: "cars", Code: internal.CodeNotAuthorized}},
+		},
+	}
+
+	require.NoError(t, internal.WriteControl(&stream, request))
+	require.NoError(t, internal.WriteControl(&stream, response))
+
+	reader := bufio.NewReader(&stream)
+
+	var readRequest internal.ControlRequest
+	require.NoError(t, internal.ReadControl(reader, &readRequest))
+	assert.Equal(t, request, readRequest)
+
+	var readResponse internal.ControlResponse
+	require.NoError(t, internal.ReadControl(reader, &readResponse))
+	assert.Equal(t, response, readResponse)
+
+	// an event is not a control frame.
+	event, err := internal.EncodingBinary.Encode(&internal.Event{ID: 1, Topic: "people", Data: nil})
+	require.NoError(t, err)
+
+	err = internal.ReadControl(bufio.NewReader(bytes.NewReader(event)), &readRequest)
+	assert.ErrorIs(t, err, internal.ErrInvalidControl)
+}
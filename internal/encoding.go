@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/snapp-incubator/qsse/internal/frame"
+)
+
+// Encoding is the wire format of events sent to a client.
+type Encoding int
+
+const (
+	// EncodingJSON is a JSON event followed by DELIMITER, it is used for clients that don't support frames.
+	EncodingJSON Encoding = iota
+	// EncodingBinary is the binary frame of internal/frame.
+	EncodingBinary
+)
+
+// ErrInvalidEvent is returned by ReadEvent when an event can't be decoded, the next event can still be read.
+var ErrInvalidEvent = errors.New("invalid event")
+
+// NegotiateEncoding returns the encoding of events for the frame version offered by the client.
+// clients of protocol version 2 always read frames.
+func NegotiateEncoding(offer *Offer, version int) Encoding {
+	if version >= Version2 || offer.Frame >= frame.Version {
+		return EncodingBinary
+	}
+
+	return EncodingJSON
+}
+
+// Encode encodes the event as a frame that is written at once.
+func (e Encoding) Encode(event *Event) ([]byte, error) {
+	if e == EncodingBinary {
+		t := frame.TypeEvent
+		if event.Topic == ErrorTopic {
+			t = frame.TypeError
+		}
+
+		f := &frame.Frame{
+			Type:    t,
+			Flags:   0,
+			Topic:   event.Topic,
+			ID:      event.ID,
+			Headers: nil,
+			Payload: event.Data,
+		}
+
+		// clients fail reading frames larger than frame.MaxSize.
+		if size := f.Size(); size > frame.MaxSize {
+			return nil, fmt.Errorf("%w: %d bytes", frame.ErrTooLarge, size)
+		}
+
+		return frame.Encode(f), nil
+	}
+
+	return EncodeFrame(event)
+}
+
+// EventFits reports whether an event of topic with data fits in a frame with any ID.
+func EventFits(topic string, data []byte) bool {
+	f := frame.Frame{Type: frame.TypeEvent, Flags: 0, Topic: topic, ID: math.MaxUint64, Headers: nil, Payload: data}
+
+	return f.Size() <= frame.MaxSize
+}
+
+// ReadEvent reads the next event which is either a binary frame or a JSON event.
+// JSON events start with '{' which is never the version of a binary frame.
+func ReadEvent(reader *bufio.Reader) (Event, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return Event{}, err
+	}
+
+	if first[0] != '{' {
+		f, err := frame.Decode(reader)
+		if errors.Is(err, frame.ErrMalformed) || errors.Is(err, frame.ErrUnsupportedVersion) {
+			return Event{}, fmt.Errorf("%w: %w", ErrInvalidEvent, err)
+		}
+
+		if err != nil {
+			return Event{}, err
+		}
+
+		return Event{ID: f.ID, Topic: f.Topic, Data: f.Payload}, nil
+	}
+
+	bytes, err := reader.ReadBytes(DELIMITER)
+	if err != nil {
+		return Event{}, err
+	}
+
+	var event Event
+	if err := json.Unmarshal(bytes, &event); err != nil {
+		return Event{}, fmt.Errorf("%w: %w", ErrInvalidEvent, err)
+	}
+
+	return event, nil
+}
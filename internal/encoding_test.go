@@ -0,0 +1,157 @@
+package internal_test
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"strconv"
+	"testing"
+
+	"github.com/snapp-incubator/qsse/internal"
+	"github.com/snapp-incubator/qsse/internal/frame"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadEventMixedEncodings(t *testing.T) {
+	t.Parallel()
+
+	events := []*internal.Event{
+		{ID: 1, Topic: "people", Data: []byte("data\nwith delimiter")},
+		{ID: 2, Topic: "people", Data: []byte{'{', 0, 0xff}},
+		{ID: 0, Topic: internal.ErrorTopic, Data: []byte(`{"code":6}`)},
+	}
+
+	var stream []byte
+
+	for i, event := range events {
+		// every other event is JSON, as if it were sent by an old server.
+		encoding := internal.EncodingBinary
+		if i%2 == 1 {
+			encoding = internal.EncodingJSON
+		}
+
+		frame, err := encoding.Encode(event)
+		require.NoError(t, err)
+
+		stream = append(stream, frame...)
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(stream))
+
+	for _, event := range events {
+		read, err := internal.ReadEvent(reader)
+		require.NoError(t, err)
+		assert.Equal(t, *event, read)
+	}
+}
+
+func TestReadEventSkipsInvalidJSON(t *testing.T) {
+	t.Parallel()
+
+	frame, err := internal.EncodingBinary.Encode(&internal.Event{ID: 1, Topic: "people", Data: []byte("data")})
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(bytes.NewReader(append([]byte("{invalid\n"), frame...)))
+
+	_, err = internal.ReadEvent(reader)
+	require.ErrorIs(t, err, internal.ErrInvalidEvent)
+
+	event, err := internal.ReadEvent(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "people", event.Topic)
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	t.Parallel()
+
+	// clients before binary frames don't send the frame version.
+	assert.Equal(t, internal.EncodingJSON, internal.NegotiateEncoding(&internal.Offer{}, internal.Version1)) //nolint:exhaustruct
+
+	offer := internal.NewOffer("token", []string{"topic"}, nil, internal.Version1)
+	assert.Equal(t, internal.EncodingBinary, internal.NegotiateEncoding(&offer, internal.Version1))
+	assert.Equal(t, internal.EncodingBinary, internal.NegotiateEncoding(&internal.Offer{}, internal.Version2)) //nolint:exhaustruct
+}
+
+func benchmarkEvent(b *testing.B, size int) *internal.Event {
+	b.Helper()
+
+	data := make([]byte, size)
+	_, _ = rand.Read(data)
+
+	return &internal.Event{ID: 123456, Topic: "people.1.location", Data: data}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	for _, size := range []int{64, 1024, 16 * 1024} {
+		for _, encoding := range []struct {
+			name     string
+			encoding internal.Encoding
+		}{{"json", internal.EncodingJSON}, {"binary", internal.EncodingBinary}} {
+			b.Run(encoding.name+"/"+strconv.Itoa(size), func(b *testing.B) {
+				event := benchmarkEvent(b, size)
+
+				var wire int
+
+				b.ReportAllocs()
+
+				b.ResetTimer()
+
+				for range b.N {
+					frame, err := encoding.encoding.Encode(event)
+					if err != nil {
+						b.Fatal(err)
+					}
+
+					wire = len(frame)
+				}
+
+				b.ReportMetric(float64(wire), "wire-bytes")
+				b.SetBytes(int64(size))
+			})
+		}
+	}
+}
+
+func BenchmarkReadEvent(b *testing.B) {
+	for _, size := range []int{64, 1024, 16 * 1024} {
+		for _, encoding := range []struct {
+			name     string
+			encoding internal.Encoding
+		}{{"json", internal.EncodingJSON}, {"binary", internal.EncodingBinary}} {
+			b.Run(encoding.name+"/"+strconv.Itoa(size), func(b *testing.B) {
+				frame, err := encoding.encoding.Encode(benchmarkEvent(b, size))
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				stream := bytes.NewReader(frame)
+				reader := bufio.NewReaderSize(stream, len(frame)+1)
+
+				b.ReportAllocs()
+				b.SetBytes(int64(size))
+
+				b.ResetTimer()
+
+				for range b.N {
+					stream.Reset(frame)
+					reader.Reset(stream)
+
+					if _, err := internal.ReadEvent(reader); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestEncodeTooLarge(t *testing.T) {
+	t.Parallel()
+
+	_, err := internal.EncodingBinary.Encode(&internal.Event{ID: 1, Topic: "people", Data: make([]byte, frame.MaxSize)})
+	require.ErrorIs(t, err, frame.ErrTooLarge)
+
+	assert.False(t, internal.EventFits("people", make([]byte, frame.MaxSize)))
+	assert.True(t, internal.EventFits("people", make([]byte, frame.MaxSize-64)))
+}
@@ -3,6 +3,7 @@ package internal
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 )
 
 type Error struct {
@@ -18,14 +19,102 @@ var (
 	ErrFailedToReadOffer    = errors.New("failed to read offer from client")
 	ErrFailedToSendOffer    = errors.New("failed to send offer to server")
 	ErrFailedToMarshal      = errors.New("failed to marshal/unmarshal data")
+	ErrServerClosed         = errors.New("server is shutting down")
+	ErrClientClosed         = errors.New("client is closed")
+	ErrFailedToReconnect    = errors.New("failed to reconnect to server")
+	ErrWriterClosed         = errors.New("client writer is closed")
+	ErrSlowConsumer         = errors.New("client is too slow to receive events")
+	ErrNoTopicMatched       = errors.New("no topic matched")
+	ErrQueueFull            = errors.New("publish queue is full")
+	ErrEventTooLarge        = errors.New("event is too large")
+	ErrUnsupportedVersion   = errors.New("no protocol version in common")
+	ErrInvalidControl       = errors.New("invalid control message")
+	ErrTopicNotAvailable    = errors.New("topic is not available")
+	ErrInvalidTopic         = errors.New("topic is not valid")
+	ErrTokenExpired         = errors.New("token is expired")
+	ErrTokenRevoked         = errors.New("token is revoked")
+	ErrNoClientCAs          = errors.New("mutual TLS needs client CAs")
 )
 
+// ConnectionError is the reason of losing connection to the server.
+// Code is the error code sent by server on closing the connection.
+type ConnectionError struct {
+	Code    int
+	Message string
+	Err     error
+}
+
+func (e *ConnectionError) Error() string {
+	return fmt.Sprintf("connection lost with code %d: %s", e.Code, e.Message)
+}
+
+func (e *ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+// HandshakeError is the reason that the server refused the offer.
+// Code is the error code sent by server on closing the connection.
+type HandshakeError struct {
+	Code    int
+	Message string
+	Err     error
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("handshake failed with code %d: %s", e.Code, e.Message)
+}
+
+// Unwrap returns the error of the code, e.g. ErrNotAuthorized, and the cause.
+func (e *HandshakeError) Unwrap() []error {
+	if err := codeError(e.Code); err != nil {
+		return []error{err, e.Err}
+	}
+
+	return []error{e.Err}
+}
+
+// ControlError is the reason that the server refused a control request, e.g. it is shutting down.
+type ControlError struct {
+	Code int
+	Op   string
+}
+
+func (e *ControlError) Error() string {
+	return fmt.Sprintf("%s failed with code %d", e.Op, e.Code)
+}
+
+// Unwrap returns the error of the code, e.g. ErrServerClosed.
+func (e *ControlError) Unwrap() error {
+	return codeError(e.Code)
+}
+
+// codeError returns the error of an error code or nil when it has none.
+func codeError(code int) error {
+	switch code {
+	case CodeNotAuthorized:
+		return ErrNotAuthorized
+	case CodeUnsupportedVersion:
+		return ErrUnsupportedVersion
+	case CodeGoingAway:
+		return ErrServerClosed
+	case CodeInvalidTopic:
+		return ErrInvalidTopic
+	default:
+		return nil
+	}
+}
+
 const (
 	CodeNotAuthorized = iota + 1
 	CodeTopicNotAvailable
 	CodeFailedToCreateStream
 	CodeFailedToSendOffer
 	CodeUnknown
+	CodeGoingAway
+	CodeSlowConsumer
+	CodeUnsupportedVersion
+	CodeInvalidTopic
+	CodeTokenExpiring
 )
 
 func NewErr(code int, data map[string]any) *Error {
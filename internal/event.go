@@ -1,119 +1,577 @@
 package internal
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"slices"
+	"sync"
 	"time"
 
 	quic "github.com/quic-go/quic-go"
+	"github.com/snapp-incubator/qsse/store"
 	"go.uber.org/atomic"
+	"go.uber.org/zap"
 )
 
+// DefReplayLimit is the maximum number of events replayed from the store to a subscriber of a topic.
+const DefReplayLimit = 1000
+
 // EventSource is a struct for topic channel and its subscribers.
 type EventSource struct {
-	Topic                 string
-	DataChannel           chan []byte
-	Subscribers           []Subscriber
-	IncomingSubscribers   chan Subscriber
-	SubscriberWaitingList []Subscriber
-	Metrics               Metrics
-	Cleaning              *atomic.Bool
-	CleaningInterval      time.Duration
+	Topic       string
+	DataChannel chan []byte
+	Metrics     Metrics
+	Done        <-chan struct{}
+	// Overflow is applied when the queue of a subscriber is full.
+	Overflow Overflow
+	Logger   *zap.Logger
+
+	// LastID is the ID of the latest event, IDs are increasing per topic.
+	LastID  uint64
+	History *History
+	// Store keeps the history durable, it is nil when the history is only in memory.
+	Store store.EventStore
+	// ReplayLimit is the maximum number of events replayed from Store, the latest ones are replayed.
+	ReplayLimit int
+
+	// subscribers is replaced on every change, so readers use a snapshot without locking.
+	// changes and recording events are serialized by lock.
+	subscribers atomic.Pointer[[]*Subscriber]
+	lock        sync.Mutex
+	// replays are the subscribers which are replaying the missed events, they are added
+	// to subscribers when they are caught up.
+	replays map[*Subscriber]*replayCursor
+
+	// active is the time of the latest event or subscriber change.
+	active atomic.Time
+	// removed is closed when the topic is removed.
+	removed chan struct{}
+	closed  bool
+
+	// publishing is held by the publishers while they queue events, so the removed topic is drained after them.
+	publishing sync.RWMutex
+	// stopped is closed when the events are not distributed anymore and works tracks the distributed events
+	// which are being sent to the subscribers.
+	stopped chan struct{}
+	works   sync.WaitGroup
+}
+
+// replayCursor keeps the events recorded while its subscriber is replaying.
+type replayCursor struct {
+	events []*Event
+	// overflowed is set when the subscriber falls more than the replay limit behind.
+	overflowed bool
 }
 
 type Event struct {
+	ID    uint64 `json:"id,omitempty"`
 	Topic string `json:"topic,omitempty"`
 	Data  []byte `json:"data,omitempty"`
 }
 
+// NewEventSource returns the event source of topic. its IDs continue from the latest event of
+// topic in eventStore, so it fails when the latest ID can't be read.
 func NewEventSource(
 	topic string,
 	dataChannel chan []byte,
-	subscribers []Subscriber,
 	metric Metrics,
-	cleaningInterval time.Duration,
-) *EventSource {
-	return &EventSource{
-		Topic:                 topic,
-		DataChannel:           dataChannel,
-		Subscribers:           subscribers,
-		IncomingSubscribers:   make(chan Subscriber),
-		SubscriberWaitingList: make([]Subscriber, 0),
-		Metrics:               metric,
-		Cleaning:              atomic.NewBool(false),
-		CleaningInterval:      cleaningInterval,
+	history *History,
+	eventStore store.EventStore,
+	done <-chan struct{},
+) (*EventSource, error) {
+	var lastID uint64
+
+	if eventStore != nil {
+		id, err := eventStore.LastID(topic)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read last event id of %s from store: %w", topic, err)
+		}
+
+		lastID = id
 	}
+
+	source := &EventSource{
+		Topic:       topic,
+		DataChannel: dataChannel,
+		Metrics:     metric,
+		Done:        done,
+		Logger:      zap.NewNop(),
+		LastID:      lastID,
+		History:     history,
+		Store:       eventStore,
+		ReplayLimit: DefReplayLimit,
+		replays:     make(map[*Subscriber]*replayCursor),
+		removed:     make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+
+	source.subscribers.Store(&[]*Subscriber{})
+	source.active.Store(time.Now())
+
+	return source, nil
 }
 
 func NewEvent(topic string, data []byte) *Event {
 	return &Event{Topic: topic, Data: data}
 }
 
-// DistributeEvents distribute events from channel between subscribers.
+// DistributeEvents distribute events from channel between subscribers until done is closed.
+// the events that are already queued on the channel are distributed before returning.
+// it returns as soon as the event source is closed and the queued events are dropped.
 func (e *EventSource) DistributeEvents(worker Worker) {
-	for event := range e.DataChannel {
-		work := NewDistributeWork(event, e)
-		worker.AddDistributeWork(work)
+	defer close(e.stopped)
+
+	for {
+		select {
+		case data := <-e.DataChannel:
+			e.distribute(worker, data)
+		case <-e.removed:
+			e.drop()
+
+			return
+		case <-e.Done:
+			for {
+				select {
+				case data := <-e.DataChannel:
+					e.distribute(worker, data)
+				default:
+					return
+				}
+			}
+		}
 	}
 }
 
-func (e *EventSource) CleanCorruptSubscribers() {
-	for range time.Tick(e.CleaningInterval) {
-		e.Cleaning.Store(true)
+// drop drops the queued events of the removed topic. the publishers which are queuing events are
+// waited and the later ones fail, so no event is left behind.
+func (e *EventSource) drop() {
+	e.publishing.Lock()
+	defer e.publishing.Unlock()
 
-		i := 0
+	for {
+		select {
+		case <-e.DataChannel:
+			e.Metrics.DecEvent(e.Topic)
+		default:
+			return
+		}
+	}
+}
 
-		for _, subscriber := range e.Subscribers {
-			if subscriber.Corrupt.Load() {
-				e.Metrics.DecSubscriber(e.Topic)
-			} else {
-				e.Subscribers[i] = subscriber
-				i++
-			}
+func (e *EventSource) distribute(worker Worker, data []byte) {
+	event, subscribers := e.record(data)
+	if event == nil {
+		e.Metrics.DecEvent(e.Topic)
+
+		return
+	}
+
+	e.works.Add(1)
+	worker.AddDistributeWork(NewDistributeWork(event, e, subscribers))
+}
+
+// Publish queues an event by send and counts it in the queued events of the topic. it fails with
+// ErrTopicNotAvailable when the event source is closed, so no event is counted after it is drained.
+func (e *EventSource) Publish(send func() error) error {
+	e.publishing.RLock()
+	defer e.publishing.RUnlock()
+
+	select {
+	case <-e.removed:
+		return ErrTopicNotAvailable
+	default:
+	}
+
+	e.Metrics.IncEvent(e.Topic)
+
+	if err := send(); err != nil {
+		e.Metrics.DecEvent(e.Topic)
+
+		return err
+	}
+
+	return nil
+}
+
+// Wait waits for the events to stop being distributed after the event source is closed, including the
+// events which are being sent to the subscribers, so the metrics of the topic aren't changed afterward.
+func (e *EventSource) Wait() {
+	<-e.stopped
+	e.works.Wait()
+}
+
+// record assigns the next ID to the event and keeps it in the history. it returns the
+// subscribers at the time of recording, so subscribers added later get the event by replay.
+// the event is dropped when the event source is closed, so nothing is stored after removing the topic.
+func (e *EventSource) record(data []byte) (*Event, []*Subscriber) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.closed {
+		return nil, nil
+	}
+
+	e.LastID++
+	e.active.Store(time.Now())
+
+	event := NewEvent(e.Topic, data)
+	event.ID = e.LastID
+
+	e.History.Add(event)
+
+	if e.Store != nil {
+		err := e.Store.Append(store.Event{ID: event.ID, Topic: event.Topic, Data: event.Data, Time: time.Now()})
+		if err != nil {
+			e.Logger.Error("failed to append event to store", zap.Uint64("id", event.ID), zap.Error(err))
 		}
+	}
 
-		diff := len(e.Subscribers) - i
-		if diff > 0 {
-			log.Printf("cleaned %d corrupt subscribers\n", diff)
+	for _, cursor := range e.replays {
+		if cursor.overflowed {
+			continue
 		}
 
-		e.Subscribers = append(e.Subscribers[:i], e.SubscriberWaitingList...)
-		e.SubscriberWaitingList = make([]Subscriber, 0)
+		if len(cursor.events) >= e.replayLimit() {
+			cursor.overflowed = true
+			cursor.events = nil
+
+			continue
+		}
 
-		e.Cleaning.Store(false)
+		cursor.events = append(cursor.events, event)
 	}
+
+	return event, e.Subscribers()
 }
 
-func (e *EventSource) HandleNewSubscriber() {
-	for subscriber := range e.IncomingSubscribers {
-		if e.Cleaning.Load() {
-			e.SubscriberWaitingList = append(e.SubscriberWaitingList, subscriber)
-		} else {
-			e.Subscribers = append(e.Subscribers, subscriber)
-		}
+// replayLimit bounds the events kept for a replaying subscriber.
+func (e *EventSource) replayLimit() int {
+	if e.ReplayLimit > 0 {
+		return e.ReplayLimit
 	}
+
+	return DefReplayLimit
 }
 
-// WriteData writes data to stream.
-func WriteData(data any, sendStream *quic.SendStream) error {
-	switch data := data.(type) {
-	case []byte:
-		if _, err := sendStream.Write(data); err != nil {
-			return fmt.Errorf("write on stream failed %w", err)
+// historyEvents returns the events after lastEventID from the memory history and
+// reports whether they are all the events after lastEventID.
+func (e *EventSource) historyEvents(lastEventID uint64) ([]*Event, bool) {
+	events := e.History.Since(lastEventID)
+
+	covered := lastEventID == e.LastID || (len(events) > 0 && events[0].ID == lastEventID+1)
+
+	return events, covered || e.Store == nil
+}
+
+// storedEvents reads the events after lastEventID up to lastID from the store. they are limited
+// by the age of the history and ReplayLimit.
+func (e *EventSource) storedEvents(lastEventID, lastID uint64) ([]*Event, error) {
+	var since time.Time
+	if e.History.MaxAge > 0 {
+		since = time.Now().Add(-e.History.MaxAge)
+	}
+
+	stored, err := e.Store.ReadFrom(e.Topic, lastEventID, since, e.ReplayLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read events from store: %w", err)
+	}
+
+	events := make([]*Event, 0, len(stored))
+	for _, event := range stored {
+		// the later events are recorded for the replay cursor.
+		if event.ID > lastID {
+			break
 		}
+
+		events = append(events, &Event{ID: event.ID, Topic: event.Topic, Data: event.Data})
+	}
+
+	return events, nil
+}
+
+// keepsHistory reports whether events are recorded for replay.
+func (e *EventSource) keepsHistory() bool {
+	return e.History.Size > 0 || e.Store != nil
+}
+
+// Subscribers returns a snapshot of the subscribers which must not be modified.
+func (e *EventSource) Subscribers() []*Subscriber {
+	return *e.subscribers.Load()
+}
+
+// SubscriberCount returns the number of subscribers.
+func (e *EventSource) SubscriberCount() int {
+	return len(e.Subscribers())
+}
+
+// AddSubscriber replays the events after lastEventID to the subscriber when replay is true
+// and then adds it to the subscribers. the client has seen the events of a previous
+// server run when lastEventID is ahead of LastID, so all the history is replayed.
+// the replay happens without holding the lock, the events recorded meanwhile are kept by a
+// replay cursor and replayed afterward, so the subscriber doesn't miss or repeat any event.
+// adding a subscriber again has no effect. it fails with ErrTopicNotAvailable when the event source is closed.
+func (e *EventSource) AddSubscriber(subscriber *Subscriber, lastEventID uint64, replay bool) error {
+	e.lock.Lock()
+
+	select {
+	case <-e.Done:
+		e.lock.Unlock()
+
+		return ErrServerClosed
 	default:
-		bytes, err := json.Marshal(data)
+	}
+
+	if e.closed {
+		e.lock.Unlock()
+
+		return ErrTopicNotAvailable
+	}
+
+	if subscriber.subscribed(e.Topic) {
+		e.lock.Unlock()
+
+		return nil
+	}
+
+	// subscriber is removed from all its topics on disconnect, it must not be added afterward.
+	if !subscriber.join(e.Topic) {
+		e.lock.Unlock()
+
+		return ErrWriterClosed
+	}
+
+	if !replay {
+		e.addSubscriber(subscriber)
+		e.lock.Unlock()
+
+		return nil
+	}
+
+	if lastEventID > e.LastID {
+		lastEventID = 0
+	}
+
+	lastID := e.LastID
+	events, covered := e.historyEvents(lastEventID)
+
+	cursor := new(replayCursor)
+	e.replays[subscriber] = cursor
+
+	e.lock.Unlock()
+
+	if !covered {
+		stored, err := e.storedEvents(lastEventID, lastID)
 		if err != nil {
-			return fmt.Errorf("marshaling data to json failed %w", err)
+			e.abortReplay(subscriber, cursor)
+
+			return err
 		}
 
-		if _, err := sendStream.Write(bytes); err != nil {
-			return fmt.Errorf("write on stream failed %w", err)
+		events = stored
+	}
+
+	return e.replay(subscriber, cursor, events)
+}
+
+// replay pushes events to the subscriber and then the events of its cursor until
+// it is caught up, then the subscriber is added to the subscribers.
+func (e *EventSource) replay(subscriber *Subscriber, cursor *replayCursor, events []*Event) error {
+	for {
+		for _, event := range events {
+			frame, err := subscriber.Writer.Encoding.Encode(event)
+			if err != nil {
+				e.abortReplay(subscriber, cursor)
+
+				return err
+			}
+
+			// replayed events bypass the overflow policy, the cursor bounds how far behind the subscriber is.
+			if err := subscriber.Writer.Send(frame); err != nil {
+				e.abortReplay(subscriber, cursor)
+
+				return err
+			}
 		}
+
+		e.lock.Lock()
+
+		// the subscriber is removed or the event source is closed meanwhile.
+		if e.replays[subscriber] != cursor {
+			closed := e.closed
+			e.lock.Unlock()
+
+			if closed {
+				return ErrTopicNotAvailable
+			}
+
+			return nil
+		}
+
+		if cursor.overflowed {
+			delete(e.replays, subscriber)
+			subscriber.leave(e.Topic)
+			e.lock.Unlock()
+
+			e.Metrics.IncDrop(e.Topic, e.Overflow.Policy)
+
+			if subscriber.Disconnect != nil {
+				subscriber.Disconnect()
+			}
+
+			return ErrSlowConsumer
+		}
+
+		if len(cursor.events) == 0 {
+			delete(e.replays, subscriber)
+			e.addSubscriber(subscriber)
+			e.lock.Unlock()
+
+			return nil
+		}
+
+		events, cursor.events = cursor.events, nil
+
+		e.lock.Unlock()
+	}
+}
+
+// abortReplay removes the replay cursor of the subscriber when it is still replaying.
+func (e *EventSource) abortReplay(subscriber *Subscriber, cursor *replayCursor) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.replays[subscriber] == cursor {
+		delete(e.replays, subscriber)
+		subscriber.leave(e.Topic)
+	}
+}
+
+// addSubscriber adds the subscriber to the subscribers, it must be called by holding lock.
+func (e *EventSource) addSubscriber(subscriber *Subscriber) {
+	current := e.Subscribers()
+
+	subscribers := make([]*Subscriber, len(current), len(current)+1)
+	copy(subscribers, current)
+	subscribers = append(subscribers, subscriber)
+
+	e.subscribers.Store(&subscribers)
+	e.Metrics.IncSubscriber(e.Topic)
+}
+
+// Push queues the frame for the subscriber by the overflow policy and
+// disconnects the subscriber when it is too slow.
+func (e *EventSource) Push(subscriber *Subscriber, frame []byte) error {
+	dropped, err := subscriber.Writer.Push(frame, e.Topic, e.Overflow)
+	if dropped {
+		e.Metrics.IncDrop(e.Topic, e.Overflow.Policy)
+	}
+
+	if errors.Is(err, ErrSlowConsumer) {
+		e.Metrics.IncDrop(e.Topic, e.Overflow.Policy)
+
+		if subscriber.Disconnect != nil {
+			subscriber.Disconnect()
+		}
+	}
+
+	return err
+}
+
+// RemoveSubscriber removes the subscriber and reports whether it was subscribed.
+func (e *EventSource) RemoveSubscriber(subscriber *Subscriber) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if _, ok := e.replays[subscriber]; ok {
+		delete(e.replays, subscriber)
+		subscriber.leave(e.Topic)
+		e.active.Store(time.Now())
+
+		return true
+	}
+
+	current := e.Subscribers()
+
+	subscribers := make([]*Subscriber, 0, len(current))
+	for _, s := range current {
+		if s != subscriber {
+			subscribers = append(subscribers, s)
+		}
+	}
+
+	if len(subscribers) == len(current) {
+		return false
+	}
+
+	subscriber.leave(e.Topic)
+
+	e.subscribers.Store(&subscribers)
+	e.Metrics.DecSubscriber(e.Topic)
+	e.active.Store(time.Now())
+
+	return true
+}
+
+// Close stops distributing events and detaches all the subscribers, it returns the detached subscribers.
+// no subscriber can be added afterward.
+func (e *EventSource) Close() []*Subscriber {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.close()
+}
+
+// CloseIfIdle closes the event source when it has no subscriber and no event since idle ago.
+// it reports whether the event source is closed.
+func (e *EventSource) CloseIfIdle(idle time.Duration) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	busy := len(e.Subscribers()) > 0 || len(e.replays) > 0 || len(e.DataChannel) > 0
+
+	if e.closed || busy || time.Since(e.active.Load()) < idle {
+		return false
+	}
+
+	e.close()
+
+	return true
+}
+
+func (e *EventSource) close() []*Subscriber {
+	if e.closed {
+		return nil
+	}
+
+	e.closed = true
+	close(e.removed)
+
+	subscribers := slices.Clip(e.Subscribers())
+	for _, subscriber := range subscribers {
+		subscriber.leave(e.Topic)
+		e.Metrics.DecSubscriber(e.Topic)
+	}
+
+	e.subscribers.Store(&[]*Subscriber{})
+
+	for subscriber := range e.replays {
+		subscriber.leave(e.Topic)
+		subscribers = append(subscribers, subscriber)
+	}
+
+	clear(e.replays)
+
+	return subscribers
+}
+
+// WriteData writes data to stream.
+func WriteData(data any, sendStream *quic.SendStream) error {
+	frame, err := EncodeFrame(data)
+	if err != nil {
+		return err
 	}
 
-	if _, err := sendStream.Write([]byte{DELIMITER}); err != nil {
+	if _, err := sendStream.Write(frame); err != nil {
 		return fmt.Errorf("write on stream failed %w", err)
 	}
 
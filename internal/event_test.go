@@ -0,0 +1,520 @@
+package internal_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/snapp-incubator/qsse/internal"
+	"github.com/snapp-incubator/qsse/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// sink is a send stream that keeps the written frames.
+type sink struct {
+	lock   sync.Mutex
+	frames [][]byte
+}
+
+func (s *sink) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.frames = append(s.frames, bytes.Clone(p))
+
+	return len(p), nil
+}
+
+func (s *sink) Close() error {
+	return nil
+}
+
+func (s *sink) events(t *testing.T) []internal.Event {
+	t.Helper()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	events := make([]internal.Event, 0, len(s.frames))
+
+	for _, frame := range s.frames {
+		var event internal.Event
+
+		require.NoError(t, json.Unmarshal(frame, &event))
+
+		events = append(events, event)
+	}
+
+	return events
+}
+
+// newSubscriber returns a subscriber whose writer runs until ctx is done.
+func newSubscriber(ctx context.Context) (*internal.Subscriber, *sink) {
+	stream := new(sink)
+	writer := internal.NewWriter(stream, internal.DefWriterQueueSize)
+
+	go writer.Run(ctx)
+
+	return internal.NewSubscriber(writer), stream
+}
+
+// newEventSource returns an event source and a function that stops it after
+// distributing all the received events.
+func newEventSource(t *testing.T, topic string, distributors int64, historySize int) (*internal.EventSource, func()) {
+	t.Helper()
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	worker := internal.NewWorker(internal.WorkerConfig{
+		ClientAcceptorCount:       1,
+		ClientAcceptorQueueSize:   1,
+		EventDistributorCount:     distributors,
+		EventDistributorQueueSize: 16,
+	}, zap.NewNop())
+
+	source, err := internal.NewEventSource(
+		topic,
+		make(chan []byte),
+		internal.NewMetrics("qsse_test", "event_source"),
+		internal.NewHistory(historySize, 0),
+		nil,
+		done,
+	)
+	require.NoError(t, err)
+
+	go func() {
+		defer close(stopped)
+
+		source.DistributeEvents(worker)
+	}()
+
+	var once sync.Once
+
+	stop := func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+			worker.Pending.Wait()
+			worker.Stop()
+		})
+	}
+	t.Cleanup(stop)
+
+	return source, stop
+}
+
+func TestEventSourceChurn(t *testing.T) {
+	t.Parallel()
+
+	const (
+		publishers  = 4
+		subscribers = 8
+		events      = 500
+		rounds      = 50
+	)
+
+	source, stop := newEventSource(t, "churn", 4, 0)
+
+	var group sync.WaitGroup
+
+	for range publishers {
+		group.Add(1)
+
+		go func() {
+			defer group.Done()
+
+			for range events {
+				source.DataChannel <- []byte("data")
+			}
+		}()
+	}
+
+	for range subscribers {
+		group.Add(1)
+
+		go func() {
+			defer group.Done()
+
+			for range rounds {
+				ctx, cancel := context.WithCancel(context.Background())
+				subscriber, _ := newSubscriber(ctx)
+
+				assert.NoError(t, source.AddSubscriber(subscriber, 0, false))
+				assert.Positive(t, source.SubscriberCount())
+
+				// disconnecting before removal must not block or break the distribution.
+				cancel()
+				<-subscriber.Writer.Done()
+
+				assert.True(t, source.RemoveSubscriber(subscriber))
+				assert.False(t, source.RemoveSubscriber(subscriber))
+			}
+		}()
+	}
+
+	group.Wait()
+	stop()
+
+	assert.Zero(t, source.SubscriberCount())
+}
+
+func TestEventSourceSubscribeWhilePublishing(t *testing.T) {
+	t.Parallel()
+
+	const (
+		subscribers = 16
+		events      = 1000
+	)
+
+	// a single distributor keeps the order of events.
+	source, stop := newEventSource(t, "replay", 1, events)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	streams := make([]*sink, subscribers)
+
+	var group sync.WaitGroup
+
+	group.Add(1)
+
+	go func() {
+		defer group.Done()
+
+		for range events {
+			source.DataChannel <- []byte("data")
+		}
+	}()
+
+	for i := range subscribers {
+		group.Add(1)
+
+		go func() {
+			defer group.Done()
+
+			subscriber, stream := newSubscriber(ctx)
+			streams[i] = stream
+
+			assert.NoError(t, source.AddSubscriber(subscriber, 0, true))
+		}()
+	}
+
+	group.Wait()
+	stop()
+
+	assert.Equal(t, subscribers, source.SubscriberCount())
+
+	// every subscriber receives all the events exactly once, by replay or distribution.
+	for _, stream := range streams {
+		require.Eventually(t, func() bool { return len(stream.events(t)) >= events }, 5*time.Second, 10*time.Millisecond)
+
+		received := stream.events(t)
+		require.Len(t, received, events)
+
+		for i, event := range received {
+			assert.Equal(t, uint64(i+1), event.ID)
+		}
+	}
+}
+
+// stalledSink is a sink whose writes wait until it is released.
+type stalledSink struct {
+	sink
+
+	writing  chan struct{}
+	released chan struct{}
+	once     sync.Once
+}
+
+func newStalledSink() *stalledSink {
+	return &stalledSink{sink: sink{}, writing: make(chan struct{}), released: make(chan struct{}), once: sync.Once{}}
+}
+
+func (s *stalledSink) Write(p []byte) (int, error) {
+	s.once.Do(func() { close(s.writing) })
+	<-s.released
+
+	return s.sink.Write(p)
+}
+
+func TestEventSourceSlowReplay(t *testing.T) {
+	t.Parallel()
+
+	source, stop := newEventSource(t, "stall", 1, 10)
+	source.Overflow = internal.Overflow{Policy: internal.OverflowBlock, Timeout: 0}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subscriber, stream := newSubscriber(ctx)
+	require.NoError(t, source.AddSubscriber(subscriber, 0, false))
+
+	for range 5 {
+		source.DataChannel <- []byte("before")
+	}
+
+	require.Eventually(t, func() bool { return len(stream.events(t)) == 5 }, time.Second, 10*time.Millisecond)
+
+	// the replay blocks on the queue of the slow subscriber until its stream is released.
+	slow := newStalledSink()
+	writer := internal.NewWriter(slow, 1)
+
+	go writer.Run(ctx)
+
+	replayed := make(chan error, 1)
+
+	go func() { replayed <- source.AddSubscriber(internal.NewSubscriber(writer), 0, true) }()
+
+	<-slow.writing
+
+	published := make(chan struct{})
+
+	go func() {
+		defer close(published)
+
+		for range 5 {
+			source.DataChannel <- []byte("after")
+		}
+	}()
+
+	// events are recorded and distributed to the other subscribers while the replay is blocked.
+	assert.Eventually(t, func() bool { return len(stream.events(t)) == 10 }, time.Second, 10*time.Millisecond)
+
+	close(slow.released)
+
+	select {
+	case err := <-replayed:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("replay is not finished")
+	}
+
+	<-published
+	stop()
+
+	assert.Equal(t, 2, source.SubscriberCount())
+
+	// the slow subscriber receives all the events exactly once, by replay or distribution.
+	require.Eventually(t, func() bool { return len(slow.events(t)) == 10 }, time.Second, 10*time.Millisecond)
+
+	for i, event := range slow.events(t) {
+		assert.Equal(t, uint64(i+1), event.ID)
+	}
+}
+
+func TestEventSourceReplayBypassesOverflow(t *testing.T) {
+	t.Parallel()
+
+	source, _ := newEventSource(t, "bypass", 1, 10)
+	source.Overflow = internal.Overflow{Policy: internal.OverflowDisconnect, Timeout: 0}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	observer, observed := newSubscriber(ctx)
+	require.NoError(t, source.AddSubscriber(observer, 0, false))
+
+	for range 10 {
+		source.DataChannel <- []byte("data")
+	}
+
+	require.Eventually(t, func() bool { return len(observed.events(t)) == 10 }, time.Second, 10*time.Millisecond)
+
+	// the history is larger than the queue, so the replay waits for the client.
+	stream := newStalledSink()
+	writer := internal.NewWriter(stream, 1)
+
+	go writer.Run(ctx)
+
+	subscriber := internal.NewSubscriber(writer)
+	subscriber.Disconnect = func() { t.Error("replaying subscriber is disconnected") }
+
+	time.AfterFunc(10*time.Millisecond, func() { close(stream.released) })
+
+	require.NoError(t, source.AddSubscriber(subscriber, 0, true))
+	require.Eventually(t, func() bool { return len(stream.events(t)) == 10 }, time.Second, 10*time.Millisecond)
+}
+
+func TestEventSourceDisconnectSlowSubscriber(t *testing.T) {
+	t.Parallel()
+
+	source, stop := newEventSource(t, "slow", 1, 0)
+	source.Overflow = internal.Overflow{Policy: internal.OverflowDisconnect, Timeout: 0}
+
+	// the writer doesn't run, so the queue is never drained.
+	subscriber := internal.NewSubscriber(internal.NewWriter(new(sink), 1))
+
+	disconnected := make(chan struct{})
+	subscriber.Disconnect = func() { close(disconnected) }
+
+	require.NoError(t, source.AddSubscriber(subscriber, 0, false))
+
+	source.DataChannel <- []byte("first")
+	source.DataChannel <- []byte("second")
+
+	stop()
+
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("slow subscriber is not disconnected")
+	}
+}
+
+func TestEventSourceClose(t *testing.T) {
+	t.Parallel()
+
+	source, _ := newEventSource(t, "close", 1, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subscriber, _ := newSubscriber(ctx)
+	require.NoError(t, source.AddSubscriber(subscriber, 0, false))
+
+	// the topic is not idle while it has a subscriber.
+	assert.False(t, source.CloseIfIdle(0))
+
+	assert.Equal(t, []*internal.Subscriber{subscriber}, source.Close())
+	assert.Zero(t, source.SubscriberCount())
+	assert.Empty(t, source.Close())
+
+	require.ErrorIs(t, source.AddSubscriber(subscriber, 0, false), internal.ErrTopicNotAvailable)
+}
+
+func TestEventSourceCloseIfIdle(t *testing.T) {
+	t.Parallel()
+
+	source, _ := newEventSource(t, "idle", 1, 0)
+
+	assert.False(t, source.CloseIfIdle(time.Hour))
+	assert.True(t, source.CloseIfIdle(0))
+	assert.False(t, source.CloseIfIdle(0))
+}
+
+// brokenStore fails all the operations.
+type brokenStore struct{}
+
+var errBrokenStore = errors.New("broken store")
+
+func (brokenStore) Append(store.Event) error { return errBrokenStore }
+
+func (brokenStore) ReadFrom(string, uint64, time.Time, int) ([]store.Event, error) {
+	return nil, errBrokenStore
+}
+
+func (brokenStore) LastID(string) (uint64, error) { return 0, errBrokenStore }
+
+func (brokenStore) Delete(string) error { return errBrokenStore }
+
+func (brokenStore) Truncate(time.Duration, int64) error { return errBrokenStore }
+
+func (brokenStore) Close() error { return nil }
+
+func TestEventSourceLastIDError(t *testing.T) {
+	t.Parallel()
+
+	_, err := internal.NewEventSource(
+		"broken",
+		make(chan []byte),
+		internal.NewMetrics("qsse_test", "event_source"),
+		internal.NewHistory(0, 0),
+		brokenStore{},
+		make(chan struct{}),
+	)
+	require.ErrorIs(t, err, errBrokenStore)
+}
+
+func TestEventSourceReplayLimit(t *testing.T) {
+	t.Parallel()
+
+	eventStore, err := store.NewFileStore(t.TempDir(), nil)
+	require.NoError(t, err)
+
+	defer func() { _ = eventStore.Close() }()
+
+	for id := uint64(1); id <= 10; id++ {
+		require.NoError(t, eventStore.Append(store.Event{ID: id, Topic: "limit", Data: []byte("data"), Time: time.Now()}))
+	}
+
+	source, err := internal.NewEventSource(
+		"limit",
+		make(chan []byte),
+		internal.NewMetrics("qsse_test", "event_source"),
+		internal.NewHistory(0, 0),
+		eventStore,
+		make(chan struct{}),
+	)
+	require.NoError(t, err)
+
+	source.ReplayLimit = 3
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subscriber, stream := newSubscriber(ctx)
+	require.NoError(t, source.AddSubscriber(subscriber, 2, true))
+
+	require.Eventually(t, func() bool { return len(stream.events(t)) == 3 }, time.Second, 10*time.Millisecond)
+
+	ids := make([]uint64, 0, 3)
+	for _, event := range stream.events(t) {
+		ids = append(ids, event.ID)
+	}
+
+	assert.Equal(t, []uint64{8, 9, 10}, ids)
+}
+
+// queuedEvents returns the event count of topic.
+func queuedEvents(t *testing.T, metrics internal.Metrics, topic string) float64 {
+	t.Helper()
+
+	var metric dto.Metric
+	require.NoError(t, metrics.EventCounter.WithLabelValues(topic).Write(&metric))
+
+	return metric.GetGauge().GetValue()
+}
+
+func TestEventSourceRemovedDrain(t *testing.T) {
+	metrics := internal.NewMetrics("qsse_test", "removed_drain")
+
+	source, err := internal.NewEventSource(
+		"removed", make(chan []byte, 1), metrics, internal.NewHistory(0, 0), nil, make(chan struct{}),
+	)
+	require.NoError(t, err)
+
+	require.NoError(t, source.Publish(func() error {
+		source.DataChannel <- []byte("data")
+
+		return nil
+	}))
+	assert.InDelta(t, 1, queuedEvents(t, metrics, "removed"), 0)
+
+	source.Close()
+
+	// the events of the removed topic aren't counted anymore.
+	require.ErrorIs(t, source.Publish(func() error { return nil }), internal.ErrTopicNotAvailable)
+
+	worker := internal.NewWorker(internal.WorkerConfig{
+		ClientAcceptorCount:       1,
+		ClientAcceptorQueueSize:   1,
+		EventDistributorCount:     1,
+		EventDistributorQueueSize: 1,
+	}, zap.NewNop())
+	defer worker.Stop()
+
+	go source.DistributeEvents(worker)
+
+	source.Wait()
+
+	assert.Empty(t, source.DataChannel)
+	assert.InDelta(t, 0, queuedEvents(t, metrics, "removed"), 0)
+}
@@ -0,0 +1,242 @@
+package internal
+
+import (
+	"maps"
+	"time"
+
+	quic "github.com/quic-go/quic-go"
+	"github.com/snapp-incubator/qsse/auth"
+	"go.uber.org/zap"
+)
+
+// watchExpiry notifies the client of subscriber by CodeTokenExpiring ExpiryNotice before its principal
+// expires and closes the connection with CodeNotAuthorized when it expires. refreshing restarts the watch.
+func (s *Server) watchExpiry(connection *quic.Conn, subscriber *Subscriber) {
+	s.connectionGroup.Add(1)
+
+	go func() {
+		defer s.connectionGroup.Done()
+
+		refreshed := true
+		for refreshed {
+			refreshed = s.waitExpiry(connection, subscriber)
+		}
+	}()
+}
+
+// waitExpiry waits for the principal of subscriber to expire and reports whether it is refreshed meanwhile.
+// principals without expiry only wait for refreshing.
+func (s *Server) waitExpiry(connection *quic.Conn, subscriber *Subscriber) bool {
+	principal := subscriber.Principal()
+
+	var notice, expiry <-chan time.Time
+
+	if !principal.ExpiresAt.IsZero() {
+		noticeTimer := time.NewTimer(time.Until(principal.ExpiresAt.Add(-s.ExpiryNotice)))
+		defer noticeTimer.Stop()
+
+		expiryTimer := time.NewTimer(time.Until(principal.ExpiresAt))
+		defer expiryTimer.Stop()
+
+		notice, expiry = noticeTimer.C, expiryTimer.C
+	}
+
+	for {
+		select {
+		case <-notice:
+			data := map[string]any{"expires_at": principal.ExpiresAt.Unix()}
+
+			if err := SendError(subscriber.Writer, NewErr(CodeTokenExpiring, data)); err != nil {
+				subscriber.Logger.Warn("failed to notify client of token expiry", zap.Error(err))
+			}
+		case <-expiry:
+			subscriber.Logger.Info("token of client is expired")
+
+			if err := CloseClientConnection(connection, CodeNotAuthorized, ErrTokenExpired); err != nil {
+				subscriber.Logger.Error("failed to close connection with client", zap.Error(err))
+			}
+
+			return false
+		case <-subscriber.refreshed:
+			return true
+		case <-connection.Context().Done():
+			return false
+		}
+	}
+}
+
+// refresh authenticates the client of subscriber again by the token of request. the principal is replaced when
+// the token is of the same principal, the subscriptions are authorized again and the denied topics are detached
+// and rejected in the response. the connection is closed when the previous principal expires otherwise.
+func (s *Server) refresh(connection *quic.Conn, subscriber *Subscriber, request *ControlRequest) *ControlResponse {
+	response := &ControlResponse{ID: request.ID, Code: 0, Ack: Ack{Accepted: nil, Rejected: nil}}
+
+	principal, err := s.authenticate(subscriber.Logger, connection, request.Token, subscriber.metadata)
+	if err != nil {
+		response.Code = CodeNotAuthorized
+
+		return response
+	}
+
+	// principals without an ID, e.g. of auth.TokenAuthenticator, can't be told apart,
+	// so their tokens aren't refreshed.
+	previous := subscriber.Principal()
+	if principal.ID == "" || principal.ID != previous.ID {
+		subscriber.Logger.Warn("client refreshed the token of another principal", zap.String("refreshed", principal.ID))
+		s.Metrics.IncAuthFailure(AuthFailureForbidden)
+
+		response.Code = CodeNotAuthorized
+
+		return response
+	}
+
+	if label, previousLabel := s.principalLabel(principal), s.principalLabel(previous); label != previousLabel {
+		s.Metrics.DecConnection(previousLabel)
+		s.Metrics.IncConnection(label)
+	}
+
+	subscriber.setPrincipal(principal)
+
+	response.Rejected = s.reauthorize(subscriber, principal)
+
+	subscriber.Logger.Info("client refreshed its token")
+
+	return response
+}
+
+// reauthorize detaches the subscriber from the topics that principal is not authorized for. the wildcard
+// subscriptions are kept, their topics are authorized one by one.
+func (s *Server) reauthorize(subscriber *Subscriber, principal *auth.Principal) []RejectedTopic {
+	var rejected []RejectedTopic
+
+	for _, topic := range subscriber.topicList() {
+		if s.authorize(principal, topic) {
+			continue
+		}
+
+		subscriber.unchoose(topic)
+
+		if source, ok := s.eventSource(topic); ok {
+			source.RemoveSubscriber(subscriber)
+		}
+
+		rejected = append(rejected, RejectedTopic{Topic: topic, Code: CodeNotAuthorized})
+	}
+
+	if len(rejected) > 0 {
+		subscriber.Logger.Warn("refreshed client is not authorized for topics", zap.Any("topics", rejected))
+	}
+
+	return rejected
+}
+
+// RevokeToken closes the connections authenticated by token with CodeNotAuthorized and refuses the token
+// until the latest of their principals expires, or for RevocationTTL when the token has no connection or expiry.
+// the expired revocations are pruned, so they don't grow without bound. it returns the number of closed connections.
+func (s *Server) RevokeToken(token string) int {
+	if token == "" {
+		return 0
+	}
+
+	s.lock.Lock()
+
+	connections := s.principalConnections(func(principal *auth.Principal) bool { return principal.Token == token })
+
+	now := time.Now()
+
+	var until time.Time
+
+	for _, subscriber := range connections {
+		expiresAt := subscriber.Principal().ExpiresAt
+		if expiresAt.IsZero() {
+			until = time.Time{}
+
+			break
+		}
+
+		if expiresAt.After(until) {
+			until = expiresAt
+		}
+	}
+
+	if until.IsZero() {
+		until = now.Add(s.RevocationTTL)
+	}
+
+	if s.revoked == nil {
+		s.revoked = make(map[string]time.Time)
+	}
+
+	maps.DeleteFunc(s.revoked, func(_ string, until time.Time) bool { return !now.Before(until) })
+
+	s.revoked[token] = until
+
+	s.lock.Unlock()
+
+	s.Logger.Info("token is revoked", zap.Int("connections", len(connections)))
+
+	return s.closePrincipalConnections(connections, ErrTokenRevoked)
+}
+
+// KickPrincipal closes the connections of the principal with id with CodeNotAuthorized. the principal
+// can connect again, its tokens must be revoked to prevent it. it returns the number of closed connections.
+func (s *Server) KickPrincipal(id string) int {
+	// principals of tokens have no ID, they are kicked by revoking their token.
+	if id == "" {
+		return 0
+	}
+
+	s.lock.Lock()
+	connections := s.principalConnections(func(principal *auth.Principal) bool { return principal.ID == id })
+	s.lock.Unlock()
+
+	s.Logger.Info("principal is kicked", zap.String("principal", id), zap.Int("connections", len(connections)))
+
+	return s.closePrincipalConnections(connections, ErrNotAuthorized)
+}
+
+// principalConnections returns the connections whose principal matches. lock must be held.
+func (s *Server) principalConnections(match func(principal *auth.Principal) bool) map[*quic.Conn]*Subscriber {
+	connections := make(map[*quic.Conn]*Subscriber)
+
+	for connection, subscriber := range s.connections {
+		if match(subscriber.Principal()) {
+			connections[connection] = subscriber
+		}
+	}
+
+	return connections
+}
+
+func (s *Server) closePrincipalConnections(connections map[*quic.Conn]*Subscriber, reason error) int {
+	for connection, subscriber := range connections {
+		if err := CloseClientConnection(connection, CodeNotAuthorized, reason); err != nil {
+			subscriber.Logger.Error("failed to close connection with client", zap.Error(err))
+		}
+	}
+
+	return len(connections)
+}
+
+// revokedToken reports whether token is revoked. the revocations are forgotten when they expire.
+func (s *Server) revokedToken(token string) bool {
+	if token == "" {
+		return false
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	until, ok := s.revoked[token]
+	if !ok {
+		return false
+	}
+
+	if !time.Now().Before(until) {
+		delete(s.revoked, token)
+
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,225 @@
+// Package frame is the binary wire format of events.
+//
+// every frame starts with the version byte and the uvarint length of the rest, so
+// frames of unsupported versions can be skipped. the rest of version 1 is:
+//
+//	type (1 byte) | flags (1 byte) | topic | id (uvarint) | header count (uvarint) |
+//	headers (key, value) | payload
+//
+// where topic, header keys, header values and payload are a uvarint length followed by the bytes.
+// the version byte is never '{', so a reader can tell binary frames from JSON events.
+package frame
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Version is the version of frames written by Append.
+const Version = 1
+
+// MaxSize is the maximum size of a frame after the version and length.
+const MaxSize = 16 << 20
+
+// Type is the kind of frame.
+type Type uint8
+
+const (
+	TypeEvent Type = iota + 1
+	TypeError
+	TypeHandshake
+	TypeControl
+)
+
+var (
+	ErrUnsupportedVersion = errors.New("frame version is not supported")
+	ErrTooLarge           = errors.New("frame is too large")
+	ErrMalformed          = errors.New("frame is malformed")
+)
+
+// Frame is a message on the wire. Flags are reserved and zero in version 1.
+type Frame struct {
+	Type    Type
+	Flags   uint8
+	Topic   string
+	ID      uint64
+	Headers map[string]string
+	Payload []byte
+}
+
+// Size returns the encoded size of the frame after the version and length.
+func (f *Frame) Size() int {
+	size := 2 + sizeOf(len(f.Topic)) + uvarintLen(f.ID) + uvarintLen(uint64(len(f.Headers))) + sizeOf(len(f.Payload))
+
+	for key, value := range f.Headers {
+		size += sizeOf(len(key)) + sizeOf(len(value))
+	}
+
+	return size
+}
+
+// Append appends the encoded frame to dst.
+func Append(dst []byte, f *Frame) []byte {
+	size := f.Size()
+
+	dst = append(dst, Version)
+	dst = binary.AppendUvarint(dst, uint64(size))
+	dst = append(dst, byte(f.Type), f.Flags)
+	dst = appendBytes(dst, f.Topic)
+	dst = binary.AppendUvarint(dst, f.ID)
+	dst = binary.AppendUvarint(dst, uint64(len(f.Headers)))
+
+	for key, value := range f.Headers {
+		dst = appendBytes(dst, key)
+		dst = appendBytes(dst, value)
+	}
+
+	return appendBytes(dst, f.Payload)
+}
+
+// Encode returns the encoded frame.
+func Encode(f *Frame) []byte {
+	size := f.Size()
+
+	return Append(make([]byte, 0, 1+uvarintLen(uint64(size))+size), f)
+}
+
+// Decode reads the next frame. frames of unsupported versions and malformed frames
+// are consumed entirely, so the next frame can be read after ErrUnsupportedVersion or ErrMalformed.
+func Decode(reader *bufio.Reader) (*Frame, error) {
+	version, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, unexpected(err)
+	}
+
+	if size > MaxSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrTooLarge, size)
+	}
+
+	if version != Version {
+		if _, err := reader.Discard(int(size)); err != nil {
+			return nil, unexpected(err)
+		}
+
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, unexpected(err)
+	}
+
+	return parse(body)
+}
+
+// parse parses the frame body of version 1, the returned payload refers to body.
+func parse(body []byte) (*Frame, error) {
+	if len(body) < 2 { //nolint:mnd
+		return nil, ErrMalformed
+	}
+
+	f := &Frame{Type: Type(body[0]), Flags: body[1], Topic: "", ID: 0, Headers: nil, Payload: nil}
+	p := parser{body: body[2:], err: nil}
+
+	f.Topic = string(p.bytes())
+	f.ID = p.uvarint()
+
+	count := p.uvarint()
+	if count > uint64(len(p.body)) {
+		return nil, ErrMalformed
+	}
+
+	if count > 0 {
+		f.Headers = make(map[string]string, count)
+
+		for range count {
+			key := string(p.bytes())
+			f.Headers[key] = string(p.bytes())
+		}
+	}
+
+	f.Payload = p.bytes()
+
+	if p.err != nil || len(p.body) != 0 {
+		return nil, ErrMalformed
+	}
+
+	return f, nil
+}
+
+type parser struct {
+	body []byte
+	err  error
+}
+
+func (p *parser) uvarint() uint64 {
+	if p.err != nil {
+		return 0
+	}
+
+	value, n := binary.Uvarint(p.body)
+	if n <= 0 {
+		p.err = ErrMalformed
+
+		return 0
+	}
+
+	p.body = p.body[n:]
+
+	return value
+}
+
+func (p *parser) bytes() []byte {
+	length := p.uvarint()
+	if p.err != nil {
+		return nil
+	}
+
+	if length > uint64(len(p.body)) {
+		p.err = ErrMalformed
+
+		return nil
+	}
+
+	b := p.body[:length:length]
+	p.body = p.body[length:]
+
+	return b
+}
+
+func appendBytes[T string | []byte](dst []byte, b T) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(b)))
+
+	return append(dst, b...)
+}
+
+func sizeOf(length int) int {
+	return uvarintLen(uint64(length)) + length
+}
+
+func uvarintLen(x uint64) int {
+	n := 1
+
+	for ; x >= 0x80; x >>= 7 {
+		n++
+	}
+
+	return n
+}
+
+// unexpected converts EOF in the middle of a frame to io.ErrUnexpectedEOF.
+func unexpected(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
@@ -0,0 +1,123 @@
+package frame_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/snapp-incubator/qsse/internal/frame"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func reader(b ...[]byte) *bufio.Reader {
+	return bufio.NewReader(bytes.NewReader(bytes.Join(b, nil)))
+}
+
+func TestRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	frames := []*frame.Frame{
+		{Type: frame.TypeEvent, Flags: 0, Topic: "people.1", ID: 1, Headers: nil, Payload: []byte("data")},
+		{Type: frame.TypeError, Flags: 0, Topic: "error", ID: 0, Headers: nil, Payload: []byte(`{"code":1}`)},
+		{
+			Type:    frame.TypeEvent,
+			Flags:   0,
+			Topic:   "t",
+			ID:      1 << 40,
+			Headers: map[string]string{"content-type": "application/json", "trace": ""},
+			Payload: bytes.Repeat([]byte{0, '\n', 0xff}, 1000),
+		},
+		{Type: frame.TypeEvent, Flags: 0, Topic: "", ID: 0, Headers: nil, Payload: nil},
+	}
+
+	encoded := make([][]byte, 0, len(frames))
+	for _, f := range frames {
+		encoded = append(encoded, frame.Encode(f))
+	}
+
+	r := reader(encoded...)
+
+	for _, f := range frames {
+		decoded, err := frame.Decode(r)
+		require.NoError(t, err)
+
+		assert.Equal(t, f.Type, decoded.Type)
+		assert.Equal(t, f.Topic, decoded.Topic)
+		assert.Equal(t, f.ID, decoded.ID)
+		assert.Equal(t, f.Headers, decoded.Headers)
+		assert.Equal(t, len(f.Payload), len(decoded.Payload))
+		assert.True(t, bytes.Equal(f.Payload, decoded.Payload))
+	}
+
+	_, err := frame.Decode(r)
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestUnsupportedVersionIsSkipped(t *testing.T) {
+	t.Parallel()
+
+	future := []byte{frame.Version + 1, 3, 'a', 'b', 'c'}
+	next := frame.Encode(&frame.Frame{Type: frame.TypeEvent, Flags: 0, Topic: "t", ID: 2, Headers: nil, Payload: nil})
+
+	r := reader(future, next)
+
+	_, err := frame.Decode(r)
+	require.ErrorIs(t, err, frame.ErrUnsupportedVersion)
+
+	decoded, err := frame.Decode(r)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), decoded.ID)
+}
+
+func TestMalformed(t *testing.T) {
+	t.Parallel()
+
+	valid := frame.Encode(&frame.Frame{Type: frame.TypeEvent, Flags: 0, Topic: "topic", ID: 1, Headers: nil, Payload: []byte("data")})
+
+	tests := map[string][]byte{
+		// topic length is larger than the frame.
+		"topic overflow": {frame.Version, 4, byte(frame.TypeEvent), 0, 100, 'a'},
+		// payload length is missing.
+		"missing payload": {frame.Version, 4, byte(frame.TypeEvent), 0, 0, 1},
+		// bytes are left after payload.
+		"trailing bytes": {frame.Version, 7, byte(frame.TypeEvent), 0, 0, 1, 0, 0, 'x'},
+		"short":          {frame.Version, 1, byte(frame.TypeEvent)},
+	}
+
+	for name, b := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// a malformed frame is consumed, so the next one is read.
+			r := reader(b, valid)
+
+			_, err := frame.Decode(r)
+			require.ErrorIs(t, err, frame.ErrMalformed)
+
+			decoded, err := frame.Decode(r)
+			require.NoError(t, err)
+			assert.Equal(t, "topic", decoded.Topic)
+		})
+	}
+}
+
+func TestTooLarge(t *testing.T) {
+	t.Parallel()
+
+	b := binary.AppendUvarint([]byte{frame.Version}, frame.MaxSize+1)
+
+	_, err := frame.Decode(reader(b))
+	assert.ErrorIs(t, err, frame.ErrTooLarge)
+}
+
+func TestTruncated(t *testing.T) {
+	t.Parallel()
+
+	b := frame.Encode(&frame.Frame{Type: frame.TypeEvent, Flags: 0, Topic: "topic", ID: 1, Headers: nil, Payload: []byte("data")})
+
+	_, err := frame.Decode(reader(b[:len(b)-1]))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
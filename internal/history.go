@@ -0,0 +1,67 @@
+package internal
+
+import "time"
+
+// History is a bounded ring of the latest events of a topic which is used for
+// replaying the events that a reconnecting client has missed. it is not safe for
+// concurrent use.
+type History struct {
+	Size   int
+	MaxAge time.Duration
+
+	entries []historyEntry
+	head    int
+	count   int
+}
+
+type historyEntry struct {
+	event *Event
+	time  time.Time
+}
+
+// NewHistory creates a history that keeps at most size events which are younger
+// than maxAge. zero size disables the history and zero maxAge keeps events until
+// they are overwritten.
+func NewHistory(size int, maxAge time.Duration) *History {
+	return &History{
+		Size:    size,
+		MaxAge:  maxAge,
+		entries: make([]historyEntry, size),
+		head:    0,
+		count:   0,
+	}
+}
+
+// Add appends the event and overwrites the oldest one when history is full.
+func (h *History) Add(event *Event) {
+	if h.Size == 0 {
+		return
+	}
+
+	h.entries[(h.head+h.count)%h.Size] = historyEntry{event: event, time: time.Now()}
+
+	if h.count < h.Size {
+		h.count++
+	} else {
+		h.head = (h.head + 1) % h.Size
+	}
+}
+
+// Since returns the events with an ID greater than id from oldest to newest.
+func (h *History) Since(id uint64) []*Event {
+	events := make([]*Event, 0)
+
+	for i := range h.count {
+		entry := h.entries[(h.head+i)%h.Size]
+
+		if h.MaxAge > 0 && time.Since(entry.time) > h.MaxAge {
+			continue
+		}
+
+		if entry.event.ID > id {
+			events = append(events, entry.event)
+		}
+	}
+
+	return events
+}
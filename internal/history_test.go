@@ -0,0 +1,89 @@
+package internal_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/snapp-incubator/qsse/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func newHistory(size int, maxAge time.Duration, count int) *internal.History {
+	history := internal.NewHistory(size, maxAge)
+
+	for i := 1; i <= count; i++ {
+		event := internal.NewEvent("topic", []byte("data"))
+		event.ID = uint64(i)
+
+		history.Add(event)
+	}
+
+	return history
+}
+
+func ids(events []*internal.Event) []uint64 {
+	result := make([]uint64, 0, len(events))
+
+	for _, event := range events {
+		result = append(result, event.ID)
+	}
+
+	return result
+}
+
+func TestHistorySince(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		count   int
+		since   uint64
+		matched []uint64
+	}{
+		{
+			name:    "disabled history",
+			size:    0,
+			count:   5,
+			since:   0,
+			matched: []uint64{},
+		},
+		{
+			name:    "not full history",
+			size:    5,
+			count:   3,
+			since:   1,
+			matched: []uint64{2, 3},
+		},
+		{
+			name:    "overwritten history",
+			size:    3,
+			count:   7,
+			since:   2,
+			matched: []uint64{5, 6, 7},
+		},
+		{
+			name:    "up to date client",
+			size:    3,
+			count:   7,
+			since:   7,
+			matched: []uint64{},
+		},
+	}
+
+	for _, test := range tests {
+		testCase := test
+		t.Run(test.name, func(t *testing.T) {
+			history := newHistory(testCase.size, 0, testCase.count)
+			assert.Equal(t, testCase.matched, ids(history.Since(testCase.since)))
+		})
+	}
+}
+
+func TestHistoryMaxAge(t *testing.T) {
+	history := newHistory(10, 50*time.Millisecond, 3)
+
+	assert.Len(t, history.Since(0), 3)
+
+	time.Sleep(100 * time.Millisecond)
+
+	assert.Empty(t, history.Since(0))
+}
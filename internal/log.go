@@ -10,27 +10,18 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
-const (
-	level   = "debug"
-	enabled = false
-)
-
+// NewLogger returns the default logger which writes the logs of lvl and above to stderr
+// and also to syslog when syslogEnabled is set.
+//
 //nolint:nosnakecase
-func NewLogger() *zap.Logger {
-	var lvl zapcore.Level
-	if err := lvl.Set(level); err != nil {
-		log.Printf("cannot parse log level %s: %s", level, err)
-
-		lvl = zapcore.WarnLevel
-	}
-
+func NewLogger(lvl zapcore.Level, syslogEnabled bool) *zap.Logger {
 	encoder := zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
 	defaultCore := zapcore.NewCore(encoder, zapcore.Lock(zapcore.AddSync(os.Stderr)), lvl)
 	cores := []zapcore.Core{
 		defaultCore,
 	}
 
-	if enabled {
+	if syslogEnabled {
 		p := getPriorityFromLevel(lvl.String()) | syslog.LOG_LOCAL0
 		encoder := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
 
@@ -1,35 +1,121 @@
 package internal
 
 import (
+	"errors"
+
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+// AuthFailure is the reason label of failed authentications. the reasons of authenticators are
+// free-form, so they are mapped to these to bound the cardinality of the label.
+type AuthFailure int
+
+const (
+	// AuthFailureOther is the failure of the authenticator itself.
+	AuthFailureOther AuthFailure = iota
+	// AuthFailureExpired is an expired token or principal.
+	AuthFailureExpired
+	// AuthFailureInvalid is a credential that the authenticator rejects.
+	AuthFailureInvalid
+	// AuthFailureMissing is a missing credential, e.g. a client certificate.
+	AuthFailureMissing
+	// AuthFailureForbidden is a revoked token or a refreshed token of another principal.
+	AuthFailureForbidden
+)
+
+func (f AuthFailure) String() string {
+	switch f {
+	case AuthFailureOther:
+		return "other"
+	case AuthFailureExpired:
+		return "expired"
+	case AuthFailureInvalid:
+		return "invalid"
+	case AuthFailureMissing:
+		return "missing"
+	case AuthFailureForbidden:
+		return "forbidden"
+	default:
+		return "unknown"
+	}
+}
+
 type Metrics struct {
 	EventCounter      *prometheus.GaugeVec
 	SubscriberCounter *prometheus.GaugeVec
+	DropCounter       *prometheus.CounterVec
+	// ConnectionCounter counts the connected clients by their principal label.
+	ConnectionCounter  *prometheus.GaugeVec
+	AuthFailureCounter *prometheus.CounterVec
+	// AuthCacheCounter counts the lookups of the authorization cache by their result.
+	AuthCacheCounter *prometheus.CounterVec
 }
 
 func NewMetrics(namespace, subSystem string) Metrics {
 	var metric Metrics
 
-	metric.EventCounter = promauto.NewGaugeVec(prometheus.GaugeOpts{ //nolint:exhaustruct
+	metric.EventCounter = register(prometheus.NewGaugeVec(prometheus.GaugeOpts{ //nolint:exhaustruct
 		Namespace: namespace,
 		Subsystem: subSystem,
 		Name:      "event_count",
 		Help:      "count of events in eventsource",
-	}, []string{"topic"})
+	}, []string{"topic"}))
 
-	metric.SubscriberCounter = promauto.NewGaugeVec(prometheus.GaugeOpts{ //nolint:exhaustruct
+	metric.SubscriberCounter = register(prometheus.NewGaugeVec(prometheus.GaugeOpts{ //nolint:exhaustruct
 		Namespace: namespace,
 		Subsystem: subSystem,
 		Name:      "subscriber_count",
 		Help:      "count of topic's subscribers",
-	}, []string{"topic"})
+	}, []string{"topic"}))
+
+	metric.DropCounter = register(prometheus.NewCounterVec(prometheus.CounterOpts{ //nolint:exhaustruct
+		Namespace: namespace,
+		Subsystem: subSystem,
+		Name:      "dropped_event_count",
+		Help:      "count of events dropped for slow subscribers by overflow policy",
+	}, []string{"topic", "policy"}))
+
+	metric.ConnectionCounter = register(prometheus.NewGaugeVec(prometheus.GaugeOpts{ //nolint:exhaustruct
+		Namespace: namespace,
+		Subsystem: subSystem,
+		Name:      "connection_count",
+		Help:      "count of authenticated connections by principal",
+	}, []string{"principal"}))
+
+	metric.AuthFailureCounter = register(prometheus.NewCounterVec(prometheus.CounterOpts{ //nolint:exhaustruct
+		Namespace: namespace,
+		Subsystem: subSystem,
+		Name:      "authentication_failure_count",
+		Help:      "count of failed authentications by reason",
+	}, []string{"reason"}))
+
+	metric.AuthCacheCounter = register(prometheus.NewCounterVec(prometheus.CounterOpts{ //nolint:exhaustruct
+		Namespace: namespace,
+		Subsystem: subSystem,
+		Name:      "authorization_cache_count",
+		Help:      "count of authorization cache lookups by result",
+	}, []string{"result"}))
 
 	return metric
 }
 
+// register registers the collector on the default registry. the already registered
+// collector is returned when another server with the same namespace has registered it before.
+func register[T prometheus.Collector](collector T) T {
+	if err := prometheus.Register(collector); err != nil {
+		var registered prometheus.AlreadyRegisteredError
+		if errors.As(err, &registered) {
+			if existing, ok := registered.ExistingCollector.(T); ok {
+				return existing
+			}
+		}
+
+		panic(err)
+	}
+
+	return collector
+}
+
 func (m Metrics) IncEvent(topic string) {
 	m.EventCounter.With(map[string]string{
 		"topic": topic,
@@ -53,3 +139,41 @@ func (m Metrics) DecSubscriber(topic string) {
 		"topic": topic,
 	}).Dec()
 }
+
+func (m Metrics) IncDrop(topic string, policy OverflowPolicy) {
+	m.DropCounter.With(map[string]string{
+		"topic":  topic,
+		"policy": policy.String(),
+	}).Inc()
+}
+
+func (m Metrics) IncConnection(principal string) {
+	m.ConnectionCounter.With(map[string]string{
+		"principal": principal,
+	}).Inc()
+}
+
+func (m Metrics) DecConnection(principal string) {
+	m.ConnectionCounter.With(map[string]string{
+		"principal": principal,
+	}).Dec()
+}
+
+func (m Metrics) IncAuthFailure(reason AuthFailure) {
+	m.AuthFailureCounter.With(map[string]string{
+		"reason": reason.String(),
+	}).Inc()
+}
+
+func (m Metrics) IncAuthCache(result string) {
+	m.AuthCacheCounter.With(map[string]string{
+		"result": result,
+	}).Inc()
+}
+
+// DeleteTopic deletes the metrics of a removed topic.
+func (m Metrics) DeleteTopic(topic string) {
+	m.EventCounter.DeleteLabelValues(topic)
+	m.SubscriberCounter.DeleteLabelValues(topic)
+	m.DropCounter.DeletePartialMatch(prometheus.Labels{"topic": topic})
+}
@@ -6,23 +6,45 @@ import (
 	"encoding/json"
 
 	quic "github.com/quic-go/quic-go"
+	"github.com/snapp-incubator/qsse/internal/frame"
 )
 
 type Offer struct {
 	Token  string   `json:"token,omitempty"`
 	Topics []string `json:"topics,omitempty"`
+	// LastEventIDs is the ID of the latest received event per topic,
+	// the server replays the events after them.
+	LastEventIDs map[string]uint64 `json:"last_event_ids,omitempty"`
+	// Frame is the latest binary frame version that the client reads,
+	// events are sent as JSON when it is zero.
+	Frame int `json:"frame,omitempty"`
+	// Version is the protocol version negotiated by ALPN, clients before versioning don't send it.
+	Version int `json:"version,omitempty"`
+	// Metadata is passed to the authenticator of the server.
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
-func NewOffer(token string, topics []string) Offer {
-	return Offer{Token: token, Topics: topics}
+func NewOffer(token string, topics []string, lastEventIDs map[string]uint64, version int) Offer {
+	return Offer{
+		Token:        token,
+		Topics:       topics,
+		LastEventIDs: lastEventIDs,
+		Frame:        frame.Version,
+		Version:      version,
+		Metadata:     nil,
+	}
 }
 
-func AcceptOffer(connection *quic.Conn) (*Offer, error) {
-	stream, err := connection.AcceptUniStream(context.Background())
+// AcceptOffer reads the client offer. it gives up as soon as ctx is done.
+func AcceptOffer(ctx context.Context, connection *quic.Conn) (*Offer, error) {
+	stream, err := connection.AcceptUniStream(ctx)
 	if err != nil {
 		return nil, ErrFailedToCreateStream
 	}
 
+	stop := context.AfterFunc(ctx, func() { stream.CancelRead(0) })
+	defer stop()
+
 	reader := bufio.NewReader(stream)
 
 	bytes, err := reader.ReadBytes(DELIMITER)
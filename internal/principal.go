@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	quic "github.com/quic-go/quic-go"
+	"github.com/snapp-incubator/qsse/auth"
+	"go.uber.org/zap"
+)
+
+// authenticate returns the principal of the client of connection by its token and metadata. the returned
+// error has only the reason of auth.Error, so the cause isn't leaked to the client.
+func (s *Server) authenticate(
+	logger *zap.Logger,
+	connection *quic.Conn,
+	token string,
+	metadata map[string]string,
+) (*auth.Principal, error) {
+	state := connection.ConnectionState().TLS
+
+	info := &auth.ConnInfo{
+		RemoteAddr:       connection.RemoteAddr(),
+		PeerCertificates: state.PeerCertificates,
+		VerifiedChains:   state.VerifiedChains,
+		ServerName:       state.ServerName,
+		Protocol:         state.NegotiatedProtocol,
+		Token:            token,
+		Metadata:         metadata,
+	}
+
+	var (
+		principal *auth.Principal
+		err       error
+	)
+
+	if s.revokedToken(token) {
+		err = auth.NewError("revoked", ErrTokenRevoked)
+	} else {
+		principal, err = s.Authenticator.AuthenticateConn(connection.Context(), info)
+	}
+
+	switch {
+	case err != nil:
+	case principal == nil:
+		err = auth.NewError("no principal", nil)
+	case principal.Expired(time.Now()):
+		err = auth.NewError("expired", ErrTokenExpired)
+	default:
+		return principal, nil
+	}
+
+	logger.Warn("client is not authenticated", zap.Error(err))
+
+	s.Metrics.IncAuthFailure(authFailure(err))
+
+	var authErr *auth.Error
+	if !errors.As(err, &authErr) {
+		return nil, ErrNotAuthorized
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrNotAuthorized, authErr.Reason)
+}
+
+// authFailure returns the metrics label of an authentication error. the reasons of auth.Error are free-form,
+// so the errors are told apart by their causes and the reasons of the built-in authenticators.
+func authFailure(err error) AuthFailure {
+	var authErr *auth.Error
+
+	switch {
+	case errors.Is(err, ErrTokenRevoked):
+		return AuthFailureForbidden
+	case errors.Is(err, ErrTokenExpired):
+		return AuthFailureExpired
+	case errors.Is(err, auth.ErrNoCertificate):
+		return AuthFailureMissing
+	case !errors.As(err, &authErr):
+		return AuthFailureOther
+	}
+
+	switch authErr.Reason {
+	case "token is expired":
+		return AuthFailureExpired
+	case "no principal", "no authenticator":
+		return AuthFailureOther
+	default:
+		return AuthFailureInvalid
+	}
+}
+
+// connect records the connection of an authenticated subscriber. removeSubscriber records its disconnection.
+func (s *Server) connect(subscriber *Subscriber) {
+	principal := subscriber.Principal()
+
+	s.Metrics.IncConnection(s.principalLabel(principal))
+
+	if s.OnConnect != nil {
+		s.OnConnect(principal)
+	}
+}
+
+// principalLabel returns the metrics label of principal.
+func (s *Server) principalLabel(principal *auth.Principal) string {
+	if s.PrincipalLabel == nil {
+		return ""
+	}
+
+	return s.PrincipalLabel(principal)
+}
@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	quic "github.com/quic-go/quic-go"
+	"github.com/snapp-incubator/qsse/internal/frame"
+)
+
+// protocol versions. version 2 answers the offer with a handshake frame.
+const (
+	Version1 = 1
+	Version2 = 2
+)
+
+// ALPN tokens of protocol versions. ProtocolLegacy is the token of clients before versioning which speak version 1.
+const (
+	ProtocolLegacy = "PROTOCOL_QUIC"
+	ProtocolV1     = "qsse/1"
+	ProtocolV2     = "qsse/2"
+)
+
+// noApplicationProtocol is the QUIC crypto error of the TLS no_application_protocol alert.
+const noApplicationProtocol = quic.TransportErrorCode(0x100 + 120)
+
+const sessionIDSize = 16
+
+// SupportedVersions are the supported protocol versions from the newest.
+var SupportedVersions = []int{Version2, Version1} //nolint:gochecknoglobals
+
+// Session is the handshake response to the offer, it is the first frame of the events stream in version 2.
+type Session struct {
+	Version int `json:"version"`
+	// ID identifies the connection on the server.
+	ID string `json:"session_id,omitempty"`
+	// Accepted are the offered topics that the client is subscribed to.
+	Accepted []string `json:"accepted,omitempty"`
+	// Rejected are the offered topics that the client is not subscribed to with the reason.
+	Rejected []RejectedTopic `json:"rejected,omitempty"`
+	Limits   Limits          `json:"limits"`
+}
+
+// RejectedTopic is an offered topic that the server refused, Code is CodeTopicNotAvailable or CodeNotAuthorized.
+type RejectedTopic struct {
+	Topic string `json:"topic"`
+	Code  int    `json:"code"`
+}
+
+// Limits are the server limits that apply to the session.
+type Limits struct {
+	// QueueSize is the number of events that can wait for the client.
+	QueueSize int `json:"queue_size,omitempty"`
+	// MaxFrameSize is the maximum size of a frame.
+	MaxFrameSize int `json:"max_frame_size,omitempty"`
+	// HistorySize is the number of events kept per topic for replay.
+	HistorySize int `json:"history_size,omitempty"`
+}
+
+// NewSessionID returns a random session ID.
+func NewSessionID() string {
+	id := make([]byte, sessionIDSize)
+	_, _ = rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
+
+// Protocols returns the ALPN tokens of versions in the same order.
+func Protocols(versions []int) []string {
+	protocols := make([]string, 0, len(versions)+1)
+
+	for _, version := range versions {
+		switch version {
+		case Version2:
+			protocols = append(protocols, ProtocolV2)
+		case Version1:
+			protocols = append(protocols, ProtocolV1, ProtocolLegacy)
+		}
+	}
+
+	return protocols
+}
+
+// ValidateVersions checks that all versions are supported.
+func ValidateVersions(versions []int) error {
+	if len(versions) == 0 {
+		return fmt.Errorf("%w: no version", ErrUnsupportedVersion)
+	}
+
+	for _, version := range versions {
+		if !slices.Contains(SupportedVersions, version) {
+			return fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+		}
+	}
+
+	return nil
+}
+
+// ConnectionVersion returns the protocol version negotiated by ALPN on connection.
+func ConnectionVersion(connection *quic.Conn) int {
+	if connection.ConnectionState().TLS.NegotiatedProtocol == ProtocolV2 {
+		return Version2
+	}
+
+	return Version1
+}
+
+// NegotiateVersion returns the protocol version of the client. the version of offer
+// must be the one negotiated by ALPN, clients before versioning don't send it.
+func NegotiateVersion(connection *quic.Conn, offer *Offer, versions []int) (int, error) {
+	version := ConnectionVersion(connection)
+
+	if offer.Version != 0 && offer.Version != version {
+		return 0, fmt.Errorf("%w: offered %d over %d", ErrUnsupportedVersion, offer.Version, version)
+	}
+
+	if !slices.Contains(versions, version) {
+		return 0, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+
+	return version, nil
+}
+
+// IsNoCommonProtocol reports whether dialing failed because the server accepts none of the offered ALPN tokens.
+func IsNoCommonProtocol(err error) bool {
+	var transportErr *quic.TransportError
+
+	return errors.As(err, &transportErr) && transportErr.ErrorCode == noApplicationProtocol
+}
+
+// EncodeHandshake encodes the session as a handshake frame.
+func EncodeHandshake(session *Session) ([]byte, error) {
+	payload, err := json.Marshal(session)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling handshake failed %w", err)
+	}
+
+	return frame.Encode(&frame.Frame{
+		Type:    frame.TypeHandshake,
+		Flags:   0,
+		Topic:   "",
+		ID:      0,
+		Headers: nil,
+		Payload: payload,
+	}), nil
+}
+
+// ReadHandshake reads the session which must be the first frame of the events stream.
+func ReadHandshake(reader *bufio.Reader) (*Session, error) {
+	f, err := frame.Decode(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read handshake: %w", err)
+	}
+
+	if f.Type != frame.TypeHandshake {
+		return nil, fmt.Errorf("failed to read handshake: %w", frame.ErrMalformed)
+	}
+
+	var session Session
+	if err := json.Unmarshal(f.Payload, &session); err != nil {
+		return nil, fmt.Errorf("failed to read handshake: %w", err)
+	}
+
+	return &session, nil
+}
@@ -0,0 +1,51 @@
+package internal_test
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/snapp-incubator/qsse/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProtocols(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t,
+		[]string{internal.ProtocolV2, internal.ProtocolV1, internal.ProtocolLegacy},
+		internal.Protocols(internal.SupportedVersions),
+	)
+	assert.Equal(t, []string{internal.ProtocolV2}, internal.Protocols([]int{internal.Version2}))
+
+	require.NoError(t, internal.ValidateVersions([]int{internal.Version1}))
+	require.ErrorIs(t, internal.ValidateVersions(nil), internal.ErrUnsupportedVersion)
+	require.ErrorIs(t, internal.ValidateVersions([]int{0}), internal.ErrUnsupportedVersion)
+}
+
+func TestHandshake(t *testing.T) {
+	t.Parallel()
+
+	session := &internal.Session{
+		Version:  internal.Version2,
+		ID:       internal.NewSessionID(),
+		Accepted: []string{"people"},
+		Rejected: []internal.RejectedTopic{{Topic: "cars", Code: internal.CodeTopicNotAvailable}},
+		Limits:   internal.Limits{QueueSize: 64, MaxFrameSize: 1024, HistorySize: 10},
+	}
+
+	frame, err := internal.EncodeHandshake(session)
+	require.NoError(t, err)
+
+	read, err := internal.ReadHandshake(bufio.NewReader(bytes.NewReader(frame)))
+	require.NoError(t, err)
+	assert.Equal(t, session, read)
+
+	// an event is not a handshake.
+	event, err := internal.EncodingBinary.Encode(&internal.Event{ID: 1, Topic: "topic", Data: nil})
+	require.NoError(t, err)
+
+	_, err = internal.ReadHandshake(bufio.NewReader(bytes.NewReader(event)))
+	assert.Error(t, err)
+}
@@ -1,13 +1,22 @@
 package internal
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
 	"net/http"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	quic "github.com/quic-go/quic-go"
 	"github.com/snapp-incubator/qsse/auth"
+	"github.com/snapp-incubator/qsse/internal/frame"
+	"github.com/snapp-incubator/qsse/store"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
 
@@ -17,17 +26,88 @@ const DELIMITER = '\n'
 // Server is the main struct for the server.
 type Server struct {
 	Worker       Worker
+	Transport    *quic.Transport
 	Listener     *quic.Listener
 	EventSources map[string]*EventSource
 	Topics       []string
 	Logger       *zap.Logger
 	Finder       Finder
 
-	Authenticator auth.Authenticator
-	Authorizer    auth.Authorizer
-	Metrics       Metrics
+	// Versions are the accepted protocol versions.
+	Versions []int
 
-	CleaningInterval time.Duration
+	// AutoCreate are the patterns of topics that are created on the first publish or subscribe.
+	// AddTemplate adds the patterns of templates to them.
+	AutoCreate []string
+	// TopicIdleTimeout is how long an auto created topic without subscribers and events is kept.
+	// zero keeps them.
+	TopicIdleTimeout time.Duration
+
+	Authenticator auth.ConnAuthenticator
+	Authorizer    auth.PrincipalAuthorizer
+	// AuthCache configures the cache of the authorizers which replace Authorizer, nil doesn't cache them.
+	AuthCache *auth.CacheConfig
+	Metrics   Metrics
+	// PrincipalLabel returns the principal label of the connection metrics, e.g. a role. principal
+	// IDs have a high cardinality, so all the principals have the same label when it is nil.
+	PrincipalLabel func(principal *auth.Principal) string
+
+	// OnConnect is called when a client is authenticated and OnDisconnect when it is disconnected.
+	OnConnect    func(principal *auth.Principal)
+	OnDisconnect func(principal *auth.Principal)
+	// ExpiryNotice is how long before the principal of a client expires it is notified by CodeTokenExpiring.
+	ExpiryNotice time.Duration
+	// RevocationTTL is how long the tokens without expiry are revoked.
+	RevocationTTL time.Duration
+
+	HistorySize   int
+	HistoryMaxAge time.Duration
+	// ReplayLimit is the maximum number of events replayed from Store per topic, DefReplayLimit when it is zero.
+	ReplayLimit int
+	// PublishQueueSize is the number of published events that can wait for the distributor of each topic.
+	PublishQueueSize int
+
+	// QueueSize is the number of events that can wait for each client.
+	QueueSize     int
+	Overflow      Overflow
+	TopicOverflow map[string]Overflow
+
+	Store                 store.EventStore
+	StoreMaxSize          int64
+	StoreTruncateInterval time.Duration
+
+	once   sync.Once
+	ctx    context.Context //nolint:containedctx
+	cancel context.CancelFunc
+
+	closing atomic.Bool
+	group   sync.WaitGroup
+
+	// topicLock guards EventSources, Topics, AutoCreate, topicIndex, autoCreateIndex, autoCreated, purging,
+	// wildcards, patterns and templates which change at runtime.
+	topicLock sync.RWMutex
+	// topicIndex indexes Topics for matching patterns, it is built on first use.
+	topicIndex *Trie
+	// autoCreateIndex indexes AutoCreate for matching topics, it is built on first use.
+	autoCreateIndex *Trie
+	autoCreated     map[string]struct{}
+	// purging are the removed topics whose history and metrics are being deleted, each channel is closed
+	// when it is done. the topics aren't created again meanwhile.
+	purging map[string]chan struct{}
+	// wildcards are the subscribers of each wildcard pattern, they are added to the new topics
+	// matching the pattern. patterns indexes the patterns of wildcards.
+	wildcards map[string]map[*Subscriber]struct{}
+	patterns  *Trie
+	// templates authorize the topics matching them in order.
+	templates []templateAuthorizer
+
+	// lock guards connections and revoked.
+	lock        sync.Mutex
+	connections map[*quic.Conn]*Subscriber
+	// revoked are the revoked tokens until they expire, they are pruned on revoking.
+	revoked map[string]time.Time
+	// connectionGroup tracks the goroutines of connections which stop when the connections are closed.
+	connectionGroup sync.WaitGroup
 }
 
 // DefaultAuthenticationFunc is the default authentication function. it accepts all clients.
@@ -40,64 +120,610 @@ func DefaultAuthorizationFunc(_, _ string) bool {
 	return true
 }
 
+// PublishResult is the outcome of publishing an event.
+type PublishResult struct {
+	// Topics are the topics matching the published topic.
+	Topics []string
+	// Subscribers is the number of subscribers of each topic that the event is queued on.
+	// topics without subscribers and history are not queued.
+	Subscribers map[string]int
+	// Failed are the topics that the event is not queued on because of an error,
+	// only they may be retried, the other topics would get the event twice.
+	Failed []string
+}
+
 // Publish publishes an event to all the subscribers of the given topic.
+// events published after shutdown are dropped.
 func (s *Server) Publish(topic string, event []byte) {
-	matchedTopics := s.Finder.FindTopicsList(s.Topics, topic)
-	for _, matchedTopic := range matchedTopics {
-		if source, ok := s.EventSources[matchedTopic]; ok && len(source.Subscribers) > 0 {
-			s.Metrics.IncEvent(matchedTopic)
+	_, _ = s.PublishContext(context.Background(), topic, event)
+}
+
+// PublishContext publishes an event to all the subscribers of the given topic.
+// it waits for the distributors of the matched topics until ctx is done.
+func (s *Server) PublishContext(ctx context.Context, topic string, event []byte) (PublishResult, error) {
+	return s.publish(topic, event, func(source *EventSource) error {
+		select {
+		case source.DataChannel <- event:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.context().Done():
+			return ErrServerClosed
+		case <-source.removed:
+			return ErrTopicNotAvailable
+		}
+	})
+}
+
+// TryPublish publishes an event to all the subscribers of the given topic.
+// it fails with ErrQueueFull instead of waiting when the distributor of a matched topic is saturated.
+func (s *Server) TryPublish(topic string, event []byte) (PublishResult, error) {
+	return s.publish(topic, event, func(source *EventSource) error {
+		select {
+		case source.DataChannel <- event:
+			return nil
+		default:
+			return ErrQueueFull
+		}
+	})
+}
+
+// publish queues the event on the topics matching topic by send. a failure of a topic doesn't stop
+// the others, the result reports the topics that the event is queued on and the failed ones.
+// events that don't fit in a frame are rejected before queuing on any topic.
+func (s *Server) publish(topic string, event []byte, send func(source *EventSource) error) (PublishResult, error) {
+	result := PublishResult{Topics: nil, Subscribers: make(map[string]int), Failed: nil}
+
+	if s.closing.Load() {
+		return result, ErrServerClosed
+	}
+
+	if err := ValidatePattern(topic); err != nil {
+		return result, err
+	}
 
-			source.DataChannel <- event
+	s.createTopic(topic)
+
+	result.Topics = s.matchTopics(topic)
+	if len(result.Topics) == 0 {
+		return result, ErrNoTopicMatched
+	}
+
+	for _, matchedTopic := range result.Topics {
+		if !EventFits(matchedTopic, event) {
+			return result, fmt.Errorf("%w: %d bytes on topic %s", ErrEventTooLarge, len(event), matchedTopic)
+		}
+	}
+
+	var errs []error
+
+	for _, matchedTopic := range result.Topics {
+		source, ok := s.eventSource(matchedTopic)
+		if !ok {
+			continue
+		}
+
+		// events are kept in history even if there is no subscriber for replaying them later.
+		subscribers := source.SubscriberCount()
+		if subscribers == 0 && !source.keepsHistory() {
+			continue
 		}
+
+		if err := source.Publish(func() error { return send(source) }); err != nil {
+			// the topic is removed while publishing.
+			if errors.Is(err, ErrTopicNotAvailable) {
+				continue
+			}
+
+			result.Failed = append(result.Failed, matchedTopic)
+			errs = append(errs, fmt.Errorf("failed to publish on topic %s: %w", matchedTopic, err))
+
+			continue
+		}
+
+		result.Subscribers[matchedTopic] = subscribers
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// Shutdown gracefully shuts down the server. it stops accepting new clients,
+// flushes the events that are already queued for distribution, sends a going away
+// error to every subscriber and waits for them to disconnect. connections that are
+// still open when ctx is done are closed forcefully and ctx's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if !s.closing.CompareAndSwap(false, true) {
+		return ErrServerClosed
+	}
+
+	s.context()
+	s.cancel()
+
+	if err := s.Listener.Close(); err != nil {
+		s.Logger.Error("failed to close listener", zap.Error(err))
+	}
+
+	err := waitGroup(ctx, &s.group)
+	if err == nil {
+		err = waitGroup(ctx, s.Worker.Pending)
 	}
+
+	if err == nil {
+		s.Worker.Stop()
+
+		s.sendGoingAway(ctx)
+
+		err = s.waitForConnections(ctx)
+	}
+
+	s.closeConnections()
+
+	if e := waitGroup(ctx, &s.connectionGroup); e != nil {
+		s.Logger.Warn("some connection goroutines are not stopped", zap.Error(e))
+	}
+
+	if e := s.Transport.Close(); e != nil {
+		s.Logger.Error("failed to close transport", zap.Error(e))
+	}
+
+	if e := s.Transport.Conn.Close(); e != nil {
+		s.Logger.Error("failed to close udp connection", zap.Error(e))
+	}
+
+	return err
 }
 
 // SetAuthenticator replaces the authentication function.
 func (s *Server) SetAuthenticator(authenticator auth.Authenticator) {
-	s.Authenticator = authenticator
+	s.Authenticator = auth.TokenAuthenticator(authenticator)
 }
 
 // SetAuthenticatorFunc replaces the authentication function.
 func (s *Server) SetAuthenticatorFunc(authenticator auth.AuthenticatorFunc) {
+	s.Authenticator = auth.TokenAuthenticator(authenticator)
+}
+
+// SetConnAuthenticator replaces the authentication function by one which returns the principal.
+func (s *Server) SetConnAuthenticator(authenticator auth.ConnAuthenticator) {
+	s.Authenticator = authenticator
+}
+
+// SetConnAuthenticatorFunc replaces the authentication function by one which returns the principal.
+func (s *Server) SetConnAuthenticatorFunc(authenticator auth.ConnAuthenticatorFunc) {
 	s.Authenticator = authenticator
 }
 
-// SetAuthorizer replaces the authentication function.
+// SetAuthorizer replaces the authorization function.
 func (s *Server) SetAuthorizer(authorizer auth.Authorizer) {
-	s.Authorizer = authorizer
+	s.setAuthorizer(auth.TokenAuthorizer(authorizer))
 }
 
-// SetAuthorizerFunc replaces the authentication function.
+// SetAuthorizerFunc replaces the authorization function.
 func (s *Server) SetAuthorizerFunc(authorizer auth.AuthorizerFunc) {
+	s.setAuthorizer(auth.TokenAuthorizer(authorizer))
+}
+
+// SetPrincipalAuthorizer replaces the authorization function by one which receives the principal.
+func (s *Server) SetPrincipalAuthorizer(authorizer auth.PrincipalAuthorizer) {
+	s.setAuthorizer(authorizer)
+}
+
+// SetPrincipalAuthorizerFunc replaces the authorization function by one which receives the principal.
+func (s *Server) SetPrincipalAuthorizerFunc(authorizer auth.PrincipalAuthorizerFunc) {
+	s.setAuthorizer(authorizer)
+}
+
+// setAuthorizer replaces Authorizer by authorizer, which is cached when AuthCache is set.
+// the cached decisions of the previous authorizer are forgotten with it.
+func (s *Server) setAuthorizer(authorizer auth.PrincipalAuthorizer) {
+	if s.AuthCache != nil {
+		authorizer = auth.NewCachedAuthorizer(authorizer, s.AuthCache)
+	}
+
 	s.Authorizer = authorizer
 }
 
-// GenerateEventSources generates eventSources for each topic.
-func (s *Server) GenerateEventSources(topics []string) {
+// GenerateEventSources generates eventSources for each topic and adds them to the topics.
+// auto created topics among them are kept even when they are idle. it stops at the first
+// topic whose event source can't be created.
+func (s *Server) GenerateEventSources(topics []string) error {
+	created := make([]*EventSource, 0, len(topics))
+
+	var err error
+
+	s.topicLock.Lock()
+	s.awaitPurge(topics...)
+
+	for _, topic := range topics {
+		delete(s.autoCreated, topic)
+
+		if _, ok := s.EventSources[topic]; ok {
+			continue
+		}
+
+		var source *EventSource
+
+		source, err = s.newEventSource(topic)
+		if err != nil {
+			break
+		}
+
+		created = append(created, source)
+	}
+
+	s.topicLock.Unlock()
+
+	for _, source := range created {
+		s.attachWildcards(source)
+	}
+
+	return err
+}
+
+// newEventSource creates the event source of topic and starts distributing its events. topicLock must be held.
+func (s *Server) newEventSource(topic string) (*EventSource, error) {
+	s.Logger.Info("creating new event source for topic", zap.String("topic", topic))
+
+	eventSource, err := NewEventSource(
+		topic,
+		make(chan []byte, s.PublishQueueSize),
+		s.Metrics,
+		NewHistory(s.HistorySize, s.HistoryMaxAge),
+		s.Store,
+		s.context().Done(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	eventSource.Overflow = s.overflow(topic)
+	eventSource.Logger = s.Logger.With(zap.String("topic", topic))
+
+	if s.ReplayLimit > 0 {
+		eventSource.ReplayLimit = s.ReplayLimit
+	}
+
+	s.EventSources[topic] = eventSource
+	s.Topics = AppendIfMissing(s.Topics, topic)
+	s.index().Insert(topic)
+
+	s.spawn(func() { eventSource.DistributeEvents(s.Worker) })
+
+	return eventSource, nil
+}
+
+// AddTopics adds topics that clients can subscribe to and events can be published on.
+// topics must follow the topic syntax without wildcards.
+func (s *Server) AddTopics(topics ...string) error {
 	for _, topic := range topics {
-		if _, ok := s.EventSources[topic]; !ok {
-			s.Logger.Info("creating new event source for topic", zap.String("topic", topic))
-			s.EventSources[topic] = NewEventSource(
-				topic,
-				make(chan []byte),
-				make([]Subscriber, 0),
-				s.Metrics,
-				s.CleaningInterval,
-			)
+		if err := ValidateTopic(topic); err != nil {
+			return err
+		}
+	}
+
+	if s.closing.Load() {
+		return ErrServerClosed
+	}
+
+	return s.GenerateEventSources(topics)
+}
+
+// RemoveTopic removes topic with its history in memory and in the store, so a topic with the same name
+// starts over. its subscribers are detached and notified by an error with CodeTopicNotAvailable and the topic.
+func (s *Server) RemoveTopic(topic string) error {
+	if s.closing.Load() {
+		return ErrServerClosed
+	}
+
+	s.topicLock.Lock()
+
+	source, ok := s.EventSources[topic]
+	if !ok {
+		s.topicLock.Unlock()
+
+		return fmt.Errorf("%w: %s", ErrTopicNotAvailable, topic)
+	}
+
+	s.deleteTopic(topic)
+
+	s.topicLock.Unlock()
+
+	subscribers := source.Close()
+
+	s.Logger.Info("topic is removed", zap.String("topic", topic), zap.Int("subscribers", len(subscribers)))
+
+	s.notifyTopicRemoved(source, subscribers)
+	s.purgeTopic(source)
+
+	return nil
+}
+
+// deleteTopic deletes topic from the topics and marks it as purging until purgeTopic. topicLock must be held.
+func (s *Server) deleteTopic(topic string) {
+	if s.purging == nil {
+		s.purging = make(map[string]chan struct{})
+	}
+
+	s.purging[topic] = make(chan struct{})
+
+	delete(s.EventSources, topic)
+	delete(s.autoCreated, topic)
+	s.index().Delete(topic)
+
+	// Topics is copied, so the snapshots that publishers use don't change.
+	s.Topics = slices.DeleteFunc(slices.Clone(s.Topics), func(t string) bool { return t == topic })
+}
+
+// purgeTopic deletes the history and the metrics of the closed event source of a removed topic after its
+// events are distributed. the topic can be created again afterward.
+func (s *Server) purgeTopic(source *EventSource) {
+	source.Wait()
+
+	if s.Store != nil {
+		if err := s.Store.Delete(source.Topic); err != nil {
+			s.Logger.Error("failed to delete topic from store", zap.String("topic", source.Topic), zap.Error(err))
+		}
+	}
+
+	s.Metrics.DeleteTopic(source.Topic)
+
+	s.topicLock.Lock()
+	defer s.topicLock.Unlock()
+
+	close(s.purging[source.Topic])
+	delete(s.purging, source.Topic)
+}
+
+// awaitPurge waits for the topics which are purging among topics. topicLock must be held, it is
+// released while waiting.
+func (s *Server) awaitPurge(topics ...string) {
+	for {
+		var purged chan struct{}
 
-			go s.EventSources[topic].DistributeEvents(s.Worker)
-			go s.EventSources[topic].CleanCorruptSubscribers()
-			go s.EventSources[topic].HandleNewSubscriber()
+		for _, topic := range topics {
+			if done, ok := s.purging[topic]; ok {
+				purged = done
+
+				break
+			}
+		}
+
+		if purged == nil {
+			return
+		}
+
+		s.topicLock.Unlock()
+		<-purged
+		s.topicLock.Lock()
+	}
+}
+
+// notifyTopicRemoved notifies the subscribers of a removed topic.
+func (s *Server) notifyTopicRemoved(source *EventSource, subscribers []*Subscriber) {
+	errBytes, _ := json.Marshal(NewErr(CodeTopicNotAvailable, map[string]any{"topic": source.Topic})) //nolint:errchkjson
+	event := NewEvent(ErrorTopic, errBytes)
+
+	for _, subscriber := range subscribers {
+		frame, err := subscriber.Writer.Encoding.Encode(event)
+		if err != nil {
+			s.Logger.Error("failed to encode event", zap.Error(err))
+
+			continue
 		}
+
+		if err := source.Push(subscriber, frame); err != nil {
+			subscriber.Logger.Debug("failed to notify subscriber of removed topic",
+				zap.String("topic", source.Topic), zap.Error(err))
+		}
+	}
+}
+
+// eventSource returns the event source of topic.
+func (s *Server) eventSource(topic string) (*EventSource, bool) {
+	s.topicLock.RLock()
+	defer s.topicLock.RUnlock()
+
+	source, ok := s.EventSources[topic]
+
+	return source, ok
+}
+
+// index returns the index of topics. topicLock must be held.
+func (s *Server) index() *Trie {
+	if s.topicIndex == nil {
+		s.topicIndex = NewTrie(s.Topics...)
+	}
+
+	return s.topicIndex
+}
+
+// matchTopics returns the topics matching pattern in order.
+func (s *Server) matchTopics(pattern string) []string {
+	s.topicLock.RLock()
+
+	if s.topicIndex != nil {
+		defer s.topicLock.RUnlock()
+
+		return s.topicIndex.Match(pattern)
 	}
+
+	s.topicLock.RUnlock()
+
+	s.topicLock.Lock()
+	defer s.topicLock.Unlock()
+
+	return s.index().Match(pattern)
+}
+
+// creatable reports whether topic matches the auto create patterns.
+func (s *Server) creatable(topic string) bool {
+	if ValidateTopic(topic) != nil {
+		return false
+	}
+
+	s.topicLock.RLock()
+
+	if s.autoCreateIndex != nil {
+		defer s.topicLock.RUnlock()
+
+		return len(s.autoCreateIndex.MatchPatterns(topic)) > 0
+	}
+
+	s.topicLock.RUnlock()
+
+	s.topicLock.Lock()
+	defer s.topicLock.Unlock()
+
+	if s.autoCreateIndex == nil {
+		s.autoCreateIndex = NewTrie(s.AutoCreate...)
+	}
+
+	return len(s.autoCreateIndex.MatchPatterns(topic)) > 0
+}
+
+// createTopic returns the event source of topic and creates it when topic is creatable.
+func (s *Server) createTopic(topic string) (*EventSource, bool) {
+	if source, ok := s.eventSource(topic); ok || !s.creatable(topic) {
+		return source, ok
+	}
+
+	s.topicLock.Lock()
+	s.awaitPurge(topic)
+
+	if source, ok := s.EventSources[topic]; ok {
+		s.topicLock.Unlock()
+
+		return source, true
+	}
+
+	if s.closing.Load() {
+		s.topicLock.Unlock()
+
+		return nil, false
+	}
+
+	if s.autoCreated == nil {
+		s.autoCreated = make(map[string]struct{})
+	}
+
+	source, err := s.newEventSource(topic)
+	if err != nil {
+		s.topicLock.Unlock()
+
+		s.Logger.Error("failed to create topic", zap.String("topic", topic), zap.Error(err))
+
+		return nil, false
+	}
+
+	s.autoCreated[topic] = struct{}{}
+
+	s.topicLock.Unlock()
+
+	s.attachWildcards(source)
+
+	return source, true
+}
+
+// StartTopicCollection removes the auto created topics which are idle for TopicIdleTimeout periodically.
+func (s *Server) StartTopicCollection() {
+	// templates can add auto create patterns after the collection starts.
+	if s.TopicIdleTimeout <= 0 {
+		return
+	}
+
+	s.spawn(func() {
+		ticker := time.NewTicker(max(s.TopicIdleTimeout/2, time.Millisecond))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.collectIdleTopics()
+			case <-s.context().Done():
+				return
+			}
+		}
+	})
+}
+
+// collectIdleTopics removes the auto created topics without subscribers and events for TopicIdleTimeout.
+// the candidates are closed without holding topicLock, so a busy event source doesn't stall the others.
+func (s *Server) collectIdleTopics() {
+	s.topicLock.RLock()
+
+	candidates := make(map[string]*EventSource, len(s.autoCreated))
+	for topic := range s.autoCreated {
+		candidates[topic] = s.EventSources[topic]
+	}
+
+	s.topicLock.RUnlock()
+
+	for topic, source := range candidates {
+		if !source.CloseIfIdle(s.TopicIdleTimeout) {
+			continue
+		}
+
+		s.topicLock.Lock()
+
+		// the closed event source is only replaced after it is deleted.
+		deleted := s.EventSources[topic] == source
+		if deleted {
+			s.deleteTopic(topic)
+		}
+
+		s.topicLock.Unlock()
+
+		if !deleted {
+			continue
+		}
+
+		s.purgeTopic(source)
+
+		s.Logger.Info("idle topic is removed", zap.String("topic", topic))
+	}
+}
+
+// StartStoreTruncation truncates the store periodically by the history max age and store max size.
+func (s *Server) StartStoreTruncation() {
+	if s.Store == nil {
+		return
+	}
+
+	s.spawn(func() {
+		ticker := time.NewTicker(s.StoreTruncateInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.Store.Truncate(s.HistoryMaxAge, s.StoreMaxSize); err != nil {
+					s.Logger.Error("failed to truncate event store", zap.Error(err))
+				}
+			case <-s.context().Done():
+				return
+			}
+		}
+	})
 }
 
 // SendError send input error to client.
-func SendError(sendStream *quic.SendStream, e *Error) error {
+func SendError(writer *Writer, e *Error) error {
 	errBytes, _ := json.Marshal(e) //nolint:errchkjson
 	errEvent := NewEvent(ErrorTopic, errBytes)
 
-	return WriteData(errEvent, sendStream)
+	return writer.SendEvent(errEvent)
+}
+
+// SendErrorContext sends input error to client and gives up when ctx is done.
+func SendErrorContext(ctx context.Context, writer *Writer, e *Error) error {
+	errBytes, _ := json.Marshal(e) //nolint:errchkjson
+
+	frame, err := writer.Encoding.Encode(NewEvent(ErrorTopic, errBytes))
+	if err != nil {
+		return err
+	}
+
+	return writer.SendContext(ctx, frame)
 }
 
 func CloseClientConnection(connection *quic.Conn, code uint64, err error) error {
@@ -116,80 +742,389 @@ func (s *Server) MetricHandler() http.Handler {
 
 // handleClient authenticate client and If the authentication is successful,
 // opens sendStream for each topic and add them to eventSources.
+//
+//nolint:funlen
 func (s *Server) handleClient(connection *quic.Conn) {
-	offer, err := AcceptOffer(connection)
+	// the connection ID is the session ID of protocol version 2.
+	id := NewSessionID()
+	logger := s.Logger.With(zap.String("connection", id), zap.Stringer("remote_addr", connection.RemoteAddr()))
+
+	offer, err := AcceptOffer(s.context(), connection)
 	if err != nil {
-		s.Logger.Error("failed to handle new subscriber", zap.Error(err))
+		logger.Error("failed to handle new subscriber", zap.Error(err))
 
 		return
 	}
 
-	isValid := s.Authenticator.Authenticate(offer.Token)
-	if !isValid {
-		s.Logger.Warn("client is not valid")
+	version, err := NegotiateVersion(connection, offer, s.Versions)
+	if err != nil {
+		logger.Warn("client protocol version is not supported", zap.Error(err))
 
-		err := CloseClientConnection(connection, CodeNotAuthorized, ErrNotAuthorized)
-		if err != nil {
-			s.Logger.Error("failed to close connection with client", zap.Error(err))
+		if err := CloseClientConnection(connection, CodeUnsupportedVersion, err); err != nil {
+			logger.Error("failed to close connection with client", zap.Error(err))
 		}
 
 		return
 	}
 
-	s.Logger.Info("client is authenticated")
+	principal, err := s.authenticate(logger, connection, offer.Token, offer.Metadata)
+	if err != nil {
+		if err := CloseClientConnection(connection, CodeNotAuthorized, err); err != nil {
+			logger.Error("failed to close connection with client", zap.Error(err))
+		}
+
+		return
+	}
+
+	// principals of tokens have no ID, the token is a secret and is never logged.
+	if principal.ID != "" {
+		logger = logger.With(zap.String("principal", principal.ID))
+	}
+
+	logger.Info("client is authenticated")
 
 	sendStream, err := connection.OpenUniStream()
 	if err != nil {
-		s.Logger.Error("failed to open send stream to client", zap.Error(err))
+		logger.Error("failed to open send stream to client", zap.Error(err))
 
 		er := CloseClientConnection(connection, CodeUnknown, err)
 		if er != nil {
-			s.Logger.Error("failed to close connection with client", zap.Error(err))
+			logger.Error("failed to close connection with client", zap.Error(err))
 		}
 
 		return
 	}
 
-	subscriber := NewSubscriber(sendStream)
+	writer := NewWriter(sendStream, s.queueSize())
+	writer.Encoding = NegotiateEncoding(offer, version)
+
+	subscriber := NewSubscriber(writer)
+	subscriber.Logger = logger
+	subscriber.principal = principal
+	subscriber.metadata = offer.Metadata
+	subscriber.Disconnect = func() {
+		logger.Warn("disconnecting slow client")
+
+		if err := CloseClientConnection(connection, CodeSlowConsumer, ErrSlowConsumer); err != nil {
+			logger.Error("failed to close connection with client", zap.Error(err))
+		}
+	}
+
+	s.connect(subscriber)
+	s.runWriter(connection, subscriber)
+	s.watchExpiry(connection, subscriber)
+
+	accepted, rejected := s.checkTopics(subscriber, offer.Topics)
+
+	if version >= Version2 {
+		// the handshake is the first frame and announces the stream before any event.
+		if err := s.sendHandshake(subscriber, id, version, accepted, rejected); err != nil {
+			logger.Error("failed to send handshake to client", zap.Error(err))
+
+			return
+		}
+	} else if err := s.sendRejectedTopics(writer, rejected); err != nil {
+		logger.Error("failed to send error to client", zap.Error(err))
+	}
+
+	s.addClientTopicsToEventSources(offer, subscriber, accepted)
 
-	s.addClientTopicsToEventSources(offer, subscriber)
+	// clients of version 1 don't open the control stream.
+	if version >= Version2 {
+		s.serveControl(connection, subscriber)
+	}
 }
 
-// addClientTopicsToEventSources adds the client's sendStream to the eventSources.
-func (s *Server) addClientTopicsToEventSources(offer *Offer, subscriber Subscriber) {
-	for _, topic := range offer.Topics {
-		valid, err := s.isTopicValid(offer, subscriber.Stream, topic)
+// sendHandshake answers the offer with the session of id.
+func (s *Server) sendHandshake(
+	subscriber *Subscriber,
+	id string,
+	version int,
+	accepted []string,
+	rejected []RejectedTopic,
+) error {
+	session := &Session{
+		Version:  version,
+		ID:       id,
+		Accepted: accepted,
+		Rejected: rejected,
+		Limits: Limits{
+			QueueSize:    subscriber.Writer.Cap(),
+			MaxFrameSize: frame.MaxSize,
+			HistorySize:  s.HistorySize,
+		},
+	}
+
+	subscriber.Logger.Info("session is started", zap.Int("version", version))
+
+	bytes, err := EncodeHandshake(session)
+	if err != nil {
+		return err
+	}
+
+	return subscriber.Writer.Send(bytes)
+}
+
+// sendRejectedTopics notifies clients of version 1 about the rejected topics by error events.
+func (s *Server) sendRejectedTopics(writer *Writer, rejected []RejectedTopic) error {
+	for _, topic := range rejected {
+		if err := SendError(writer, NewErr(topic.Code, map[string]any{"topic": topic.Topic})); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// overflow returns the overflow policy of topic.
+func (s *Server) overflow(topic string) Overflow {
+	if overflow, ok := s.TopicOverflow[topic]; ok {
+		return overflow
+	}
+
+	return s.Overflow
+}
+
+func (s *Server) queueSize() int {
+	if s.QueueSize <= 0 {
+		return DefWriterQueueSize
+	}
+
+	return s.QueueSize
+}
+
+// context returns the server context which is canceled when shutdown begins.
+func (s *Server) context() context.Context {
+	s.once.Do(func() {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	})
+
+	return s.ctx
+}
+
+// spawn runs f in a new goroutine that shutdown waits for.
+func (s *Server) spawn(f func()) {
+	s.group.Add(1)
+
+	go func() {
+		defer s.group.Done()
+
+		f()
+	}()
+}
+
+// runWriter runs the connection writer and keeps the connection until it is closed so shutdown can reach it.
+// the subscriber is removed from all its topics as soon as the writer stops.
+func (s *Server) runWriter(connection *quic.Conn, subscriber *Subscriber) {
+	writer := subscriber.Writer
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.connections == nil {
+		s.connections = make(map[*quic.Conn]*Subscriber)
+	}
+
+	s.connections[connection] = subscriber
+
+	s.connectionGroup.Add(1)
+
+	go func() {
+		defer s.connectionGroup.Done()
+
+		writer.Run(connection.Context())
+
+		s.removeSubscriber(subscriber)
+	}()
+
+	context.AfterFunc(connection.Context(), func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		delete(s.connections, connection)
+	})
+}
+
+// removeSubscriber removes the subscriber from all the topics that it is subscribed.
+func (s *Server) removeSubscriber(subscriber *Subscriber) {
+	topics := subscriber.close()
+
+	s.forgetWildcards(subscriber)
+
+	for _, topic := range topics {
+		if source, ok := s.eventSource(topic); ok {
+			source.RemoveSubscriber(subscriber)
+		}
+	}
+
+	principal := subscriber.Principal()
+
+	s.Metrics.DecConnection(s.principalLabel(principal))
+
+	if s.OnDisconnect != nil {
+		s.OnDisconnect(principal)
+	}
+}
+
+// sendGoingAway notifies every connected client that the server is shutting down and closes their
+// streams, so they can disconnect after reading the remaining events. each client is notified in
+// its own goroutine, so a client with a full queue only waits for itself until ctx is done.
+func (s *Server) sendGoingAway(ctx context.Context) {
+	s.lock.Lock()
+	subscribers := slices.Collect(maps.Values(s.connections))
+	s.lock.Unlock()
+
+	for _, subscriber := range subscribers {
+		s.connectionGroup.Add(1)
+
+		go func() {
+			defer s.connectionGroup.Done()
+
+			if err := SendErrorContext(ctx, subscriber.Writer, NewErr(CodeGoingAway, nil)); err != nil {
+				subscriber.Logger.Warn("failed to send going away to client", zap.Error(err))
+			}
+
+			subscriber.Writer.Close()
+		}()
+	}
+}
+
+// waitForConnections waits until all clients are disconnected or ctx is done.
+func (s *Server) waitForConnections(ctx context.Context) error {
+	for _, connection := range s.trackedConnections() {
+		select {
+		case <-connection.Context().Done():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// closeConnections closes all the remaining client connections.
+func (s *Server) closeConnections() {
+	for _, connection := range s.trackedConnections() {
+		if err := CloseClientConnection(connection, CodeGoingAway, ErrServerClosed); err != nil {
+			s.Logger.Error("failed to close connection with client", zap.Error(err))
+		}
+	}
+}
+
+// trackedConnections returns a snapshot of the open connections.
+func (s *Server) trackedConnections() []*quic.Conn {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	connections := make([]*quic.Conn, 0, len(s.connections))
+
+	for connection := range s.connections {
+		connections = append(connections, connection)
+	}
+
+	return connections
+}
+
+// waitGroup waits for the group or until ctx is done.
+func waitGroup(ctx context.Context, group *sync.WaitGroup) error {
+	done := make(chan struct{})
+
+	go func() {
+		group.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// addClientTopicsToEventSources adds the client's subscriber to the eventSources of topics.
+// wildcard topics subscribe to the matching topics.
+func (s *Server) addClientTopicsToEventSources(offer *Offer, subscriber *Subscriber, topics []string) {
+	for _, topic := range topics {
+		if TopicHasWildcard(topic) {
+			if err := s.subscribePattern(subscriber, topic, offer.LastEventIDs); err != nil {
+				subscriber.Logger.Warn("failed to add subscriber", zap.String("pattern", topic), zap.Error(err))
+
+				return
+			}
+
+			continue
+		}
+
+		lastEventID, replay := offer.LastEventIDs[topic]
+
+		subscriber.choose(topic)
+
+		err := ErrTopicNotAvailable
+		if source, ok := s.eventSource(topic); ok {
+			err = source.AddSubscriber(subscriber, lastEventID, replay)
+		}
+
+		// the topic is removed after it is accepted.
+		if errors.Is(err, ErrTopicNotAvailable) {
+			subscriber.Logger.Warn("topic is removed before subscribing", zap.String("topic", topic))
+
+			continue
+		}
+
 		if err != nil {
-			s.Logger.Error("failed to send error to client", zap.Error(err))
+			subscriber.Logger.Warn("failed to add subscriber", zap.String("topic", topic), zap.Error(err))
 
-			break
+			return
 		}
+	}
+}
 
-		if valid {
-			s.EventSources[topic].IncomingSubscribers <- subscriber
+// checkTopics splits topics to the ones that the subscriber can subscribe to and the rejected ones with the reason.
+func (s *Server) checkTopics(subscriber *Subscriber, topics []string) ([]string, []RejectedTopic) {
+	accepted := make([]string, 0, len(topics))
+	rejected := make([]RejectedTopic, 0)
 
-			s.Metrics.IncSubscriber(topic)
+	for _, topic := range topics {
+		if code := s.topicError(subscriber, topic); code != 0 {
+			rejected = append(rejected, RejectedTopic{Topic: topic, Code: code})
+		} else {
+			accepted = append(accepted, topic)
 		}
 	}
+
+	return accepted, rejected
 }
 
-// isTopicValid check whether topic exists and client is authorized on it or not.
-func (s *Server) isTopicValid(offer *Offer, sendStream *quic.SendStream, topic string) (bool, error) {
-	if _, ok := s.EventSources[topic]; !ok {
-		s.Logger.Warn("topic doesn't exists", zap.String("topic", topic))
+// topicError returns the reason that client can't subscribe to topic or zero if it can.
+// the topics matching the auto create patterns are created after authorization.
+// wildcard topics are accepted and the topics matching them are authorized one by one.
+func (s *Server) topicError(subscriber *Subscriber, topic string) int {
+	if err := ValidatePattern(topic); err != nil {
+		subscriber.Logger.Warn("topic is not valid", zap.String("topic", topic), zap.Error(err))
 
-		err := SendError(sendStream, NewErr(CodeTopicNotAvailable, map[string]any{"topic": topic}))
+		return CodeInvalidTopic
+	}
 
-		return false, err
+	if TopicHasWildcard(topic) {
+		return 0
 	}
 
-	if !s.Authorizer.Authorize(offer.Token, topic) {
-		s.Logger.Warn("client is not authorized for topic", zap.String("topic", topic))
+	if _, ok := s.eventSource(topic); !ok && !s.creatable(topic) {
+		subscriber.Logger.Warn("topic doesn't exists", zap.String("topic", topic))
+
+		return CodeTopicNotAvailable
+	}
 
-		err := SendError(sendStream, NewErr(CodeNotAuthorized, map[string]any{"topic": topic}))
+	if !s.authorize(subscriber.Principal(), topic) {
+		subscriber.Logger.Warn("client is not authorized for topic", zap.String("topic", topic))
+
+		return CodeNotAuthorized
+	}
 
-		return false, err
+	if _, ok := s.createTopic(topic); !ok {
+		return CodeTopicNotAvailable
 	}
 
-	return true, nil
+	return 0
 }
@@ -0,0 +1,142 @@
+package internal_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/snapp-incubator/qsse/internal"
+	"github.com/snapp-incubator/qsse/internal/frame"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// newPublishServer returns a server whose event sources are not distributed, so their queues
+// of size one fill up. subscribers of "people.1" is one and the other topics have none.
+func newPublishServer(t *testing.T) *internal.Server {
+	t.Helper()
+
+	topics := []string{"people.1", "people.2", "cars"}
+	metrics := internal.NewMetrics("qsse_test", "publish")
+
+	server := &internal.Server{ //nolint:exhaustruct
+		EventSources: make(map[string]*internal.EventSource),
+		Topics:       topics,
+		Logger:       zap.NewNop(),
+		Finder:       internal.Finder{},
+		Metrics:      metrics,
+	}
+
+	for _, topic := range topics {
+		source, err := internal.NewEventSource(
+			topic, make(chan []byte, 1), metrics, internal.NewHistory(0, 0), nil, make(chan struct{}),
+		)
+		require.NoError(t, err)
+
+		server.EventSources[topic] = source
+	}
+
+	subscriber := internal.NewSubscriber(internal.NewWriter(new(sink), 1))
+	require.NoError(t, server.EventSources["people.1"].AddSubscriber(subscriber, 0, false))
+
+	return server
+}
+
+func TestPublishResult(t *testing.T) {
+	t.Parallel()
+
+	server := newPublishServer(t)
+
+	result, err := server.TryPublish("people.*", []byte("data"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"people.1", "people.2"}, result.Topics)
+	assert.Equal(t, map[string]int{"people.1": 1}, result.Subscribers)
+}
+
+func TestPublishNoTopicMatched(t *testing.T) {
+	t.Parallel()
+
+	server := newPublishServer(t)
+
+	_, err := server.PublishContext(context.Background(), "trains", []byte("data"))
+	assert.ErrorIs(t, err, internal.ErrNoTopicMatched)
+
+	_, err = server.TryPublish("trains", []byte("data"))
+	assert.ErrorIs(t, err, internal.ErrNoTopicMatched)
+}
+
+func TestPublishInvalidTopic(t *testing.T) {
+	t.Parallel()
+
+	server := newPublishServer(t)
+
+	_, err := server.TryPublish("people..1", []byte("data"))
+	assert.ErrorIs(t, err, internal.ErrInvalidTopic)
+
+	_, err = server.TryPublish("people.>.1", []byte("data"))
+	assert.ErrorIs(t, err, internal.ErrInvalidTopic)
+}
+
+func TestPublishEventTooLarge(t *testing.T) {
+	t.Parallel()
+
+	server := newPublishServer(t)
+
+	result, err := server.TryPublish("people.*", make([]byte, frame.MaxSize))
+	require.ErrorIs(t, err, internal.ErrEventTooLarge)
+
+	assert.Empty(t, result.Subscribers)
+	assert.Empty(t, result.Failed)
+}
+
+func TestTryPublishQueueFull(t *testing.T) {
+	t.Parallel()
+
+	server := newPublishServer(t)
+
+	_, err := server.TryPublish("people.1", []byte("data"))
+	require.NoError(t, err)
+
+	result, err := server.TryPublish("people.1", []byte("data"))
+	require.ErrorIs(t, err, internal.ErrQueueFull)
+
+	assert.Equal(t, []string{"people.1"}, result.Topics)
+	assert.Empty(t, result.Subscribers)
+	assert.Equal(t, []string{"people.1"}, result.Failed)
+}
+
+func TestTryPublishPartial(t *testing.T) {
+	t.Parallel()
+
+	server := newPublishServer(t)
+
+	subscriber := internal.NewSubscriber(internal.NewWriter(new(sink), 1))
+	require.NoError(t, server.EventSources["people.2"].AddSubscriber(subscriber, 0, false))
+
+	_, err := server.TryPublish("people.1", []byte("data"))
+	require.NoError(t, err)
+
+	// the event is queued on the other topics, only the failed topic is reported for retrying.
+	result, err := server.TryPublish("people.*", []byte("data"))
+	require.ErrorIs(t, err, internal.ErrQueueFull)
+
+	assert.Equal(t, map[string]int{"people.2": 1}, result.Subscribers)
+	assert.Equal(t, []string{"people.1"}, result.Failed)
+}
+
+func TestPublishContextDeadline(t *testing.T) {
+	t.Parallel()
+
+	server := newPublishServer(t)
+
+	_, err := server.PublishContext(context.Background(), "people.1", []byte("data"))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = server.PublishContext(ctx, "people.1", []byte("data"))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
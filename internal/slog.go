@@ -0,0 +1,236 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// NewSlogLogger returns a logger which writes to handler, so the logs go wherever the application's slog logs go.
+func NewSlogLogger(handler slog.Handler) *zap.Logger {
+	return zap.New(&slogCore{handler: handler}, zap.AddCaller())
+}
+
+// slogCore is a zap core which converts entries to the records of a slog handler.
+type slogCore struct {
+	handler slog.Handler
+}
+
+func (c *slogCore) Enabled(level zapcore.Level) bool {
+	return c.handler.Enabled(context.Background(), slogLevel(level))
+}
+
+func (c *slogCore) With(fields []zapcore.Field) zapcore.Core {
+	encoder := encodeSlog(fields)
+	handler := c.handler.WithAttrs(encoder.attrs)
+
+	// the fields which are added later are in the open namespaces.
+	for _, namespace := range encoder.namespaces {
+		handler = handler.WithGroup(namespace.key).WithAttrs(namespace.attrs)
+	}
+
+	return &slogCore{handler: handler}
+}
+
+func (c *slogCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+
+	return checked
+}
+
+func (c *slogCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	record := slog.NewRecord(entry.Time, slogLevel(entry.Level), entry.Message, entry.Caller.PC)
+
+	if entry.LoggerName != "" {
+		record.AddAttrs(slog.String("logger", entry.LoggerName))
+	}
+
+	record.AddAttrs(slogAttrs(fields)...)
+
+	return c.handler.Handle(context.Background(), record)
+}
+
+func (c *slogCore) Sync() error {
+	return nil
+}
+
+// slogAttrs converts fields to attributes in the same order. objects and namespaces are groups.
+func slogAttrs(fields []zapcore.Field) []slog.Attr {
+	return encodeSlog(fields).close()
+}
+
+// encodeSlog encodes fields in order.
+func encodeSlog(fields []zapcore.Field) *slogEncoder {
+	encoder := new(slogEncoder)
+
+	for _, field := range fields {
+		field.AddTo(encoder)
+	}
+
+	return encoder
+}
+
+// slogEncoder is a zap object encoder which keeps the fields as slog attributes in order.
+type slogEncoder struct {
+	attrs []slog.Attr
+	// namespaces are the open namespaces, the next fields are added to the last one.
+	namespaces []slogNamespace
+}
+
+type slogNamespace struct {
+	key   string
+	attrs []slog.Attr
+}
+
+func (e *slogEncoder) add(attr slog.Attr) {
+	if n := len(e.namespaces); n > 0 {
+		e.namespaces[n-1].attrs = append(e.namespaces[n-1].attrs, attr)
+
+		return
+	}
+
+	e.attrs = append(e.attrs, attr)
+}
+
+// close returns the attributes in which the open namespaces are groups.
+func (e *slogEncoder) close() []slog.Attr {
+	for n := len(e.namespaces); n > 0; n = len(e.namespaces) {
+		namespace := e.namespaces[n-1]
+		e.namespaces = e.namespaces[:n-1]
+
+		e.add(slog.Attr{Key: namespace.key, Value: slog.GroupValue(namespace.attrs...)})
+	}
+
+	return e.attrs
+}
+
+func (e *slogEncoder) AddArray(key string, marshaler zapcore.ArrayMarshaler) error {
+	// the elements are kept in order by the map encoder.
+	encoder := zapcore.NewMapObjectEncoder()
+	err := encoder.AddArray(key, marshaler)
+
+	e.add(slog.Any(key, encoder.Fields[key]))
+
+	return err
+}
+
+func (e *slogEncoder) AddObject(key string, marshaler zapcore.ObjectMarshaler) error {
+	encoder := new(slogEncoder)
+	err := marshaler.MarshalLogObject(encoder)
+
+	e.add(slog.Attr{Key: key, Value: slog.GroupValue(encoder.close()...)})
+
+	return err
+}
+
+func (e *slogEncoder) AddBinary(key string, value []byte) {
+	e.add(slog.Any(key, value))
+}
+
+func (e *slogEncoder) AddByteString(key string, value []byte) {
+	e.add(slog.String(key, string(value)))
+}
+
+func (e *slogEncoder) AddBool(key string, value bool) {
+	e.add(slog.Bool(key, value))
+}
+
+func (e *slogEncoder) AddComplex128(key string, value complex128) {
+	e.add(slog.Any(key, value))
+}
+
+func (e *slogEncoder) AddComplex64(key string, value complex64) {
+	e.add(slog.Any(key, value))
+}
+
+func (e *slogEncoder) AddDuration(key string, value time.Duration) {
+	e.add(slog.Duration(key, value))
+}
+
+func (e *slogEncoder) AddFloat64(key string, value float64) {
+	e.add(slog.Float64(key, value))
+}
+
+func (e *slogEncoder) AddFloat32(key string, value float32) {
+	e.add(slog.Float64(key, float64(value)))
+}
+
+func (e *slogEncoder) AddInt(key string, value int) {
+	e.add(slog.Int(key, value))
+}
+
+func (e *slogEncoder) AddInt64(key string, value int64) {
+	e.add(slog.Int64(key, value))
+}
+
+func (e *slogEncoder) AddInt32(key string, value int32) {
+	e.add(slog.Int64(key, int64(value)))
+}
+
+func (e *slogEncoder) AddInt16(key string, value int16) {
+	e.add(slog.Int64(key, int64(value)))
+}
+
+func (e *slogEncoder) AddInt8(key string, value int8) {
+	e.add(slog.Int64(key, int64(value)))
+}
+
+func (e *slogEncoder) AddString(key, value string) {
+	e.add(slog.String(key, value))
+}
+
+func (e *slogEncoder) AddTime(key string, value time.Time) {
+	e.add(slog.Time(key, value))
+}
+
+func (e *slogEncoder) AddUint(key string, value uint) {
+	e.add(slog.Uint64(key, uint64(value)))
+}
+
+func (e *slogEncoder) AddUint64(key string, value uint64) {
+	e.add(slog.Uint64(key, value))
+}
+
+func (e *slogEncoder) AddUint32(key string, value uint32) {
+	e.add(slog.Uint64(key, uint64(value)))
+}
+
+func (e *slogEncoder) AddUint16(key string, value uint16) {
+	e.add(slog.Uint64(key, uint64(value)))
+}
+
+func (e *slogEncoder) AddUint8(key string, value uint8) {
+	e.add(slog.Uint64(key, uint64(value)))
+}
+
+func (e *slogEncoder) AddUintptr(key string, value uintptr) {
+	e.add(slog.Uint64(key, uint64(value)))
+}
+
+func (e *slogEncoder) AddReflected(key string, value any) error {
+	e.add(slog.Any(key, value))
+
+	return nil
+}
+
+func (e *slogEncoder) OpenNamespace(key string) {
+	e.namespaces = append(e.namespaces, slogNamespace{key: key, attrs: nil})
+}
+
+func slogLevel(level zapcore.Level) slog.Level {
+	switch {
+	case level <= zapcore.DebugLevel:
+		return slog.LevelDebug
+	case level == zapcore.InfoLevel:
+		return slog.LevelInfo
+	case level == zapcore.WarnLevel:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
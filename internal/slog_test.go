@@ -0,0 +1,67 @@
+package internal_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/snapp-incubator/qsse/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buffer bytes.Buffer
+
+	handler := slog.NewJSONHandler(&buffer, &slog.HandlerOptions{AddSource: true, Level: slog.LevelInfo}) //nolint:exhaustruct
+	logger := internal.NewSlogLogger(handler).Named("server").With(zap.String("connection", "42"))
+
+	logger.Debug("dropped")
+	logger.Warn("topic is not valid", zap.String("topic", "a..b"), zap.Error(errors.New("empty segment")))
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buffer.Bytes(), &record))
+
+	assert.Equal(t, "WARN", record["level"])
+	assert.Equal(t, "topic is not valid", record["msg"])
+	assert.Equal(t, "server", record["logger"])
+	assert.Equal(t, "42", record["connection"])
+	assert.Equal(t, "a..b", record["topic"])
+	assert.Equal(t, "empty segment", record["error"])
+	assert.Contains(t, record["source"], "function")
+}
+
+func TestSlogLoggerFieldOrder(t *testing.T) {
+	var buffer bytes.Buffer
+
+	handler := slog.NewJSONHandler(&buffer, &slog.HandlerOptions{ //nolint:exhaustruct
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if len(groups) == 0 && attr.Key == slog.TimeKey {
+				return slog.Attr{Key: "", Value: slog.Value{}}
+			}
+
+			return attr
+		},
+	})
+	logger := internal.NewSlogLogger(handler)
+
+	ride := zapcore.ObjectMarshalerFunc(func(encoder zapcore.ObjectEncoder) error {
+		encoder.AddString("id", "42")
+		encoder.AddString("driver", "7")
+
+		return nil
+	})
+
+	logger.Info("ride", zap.Int("b", 2), zap.Int("a", 1), zap.Object("ride", ride), zap.Namespace("request"), zap.Int("id", 1))
+	logger.With(zap.Namespace("request")).Info("request", zap.Int("id", 2))
+
+	assert.Equal(t,
+		`{"level":"INFO","msg":"ride","b":2,"a":1,"ride":{"id":"42","driver":"7"},"request":{"id":1}}`+"\n"+
+			`{"level":"INFO","msg":"request","request":{"id":2}}`+"\n",
+		buffer.String(),
+	)
+}
@@ -1,18 +1,174 @@
 package internal
 
 import (
-	quic "github.com/quic-go/quic-go"
-	"go.uber.org/atomic"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/snapp-incubator/qsse/auth"
+	"go.uber.org/zap"
 )
 
+// Subscriber is a client connection, it is shared between all the topics that the client subscribed.
 type Subscriber struct {
-	Stream  *quic.SendStream
-	Corrupt *atomic.Bool
+	Writer *Writer
+	// Disconnect closes the client connection, it is called when the client is too slow.
+	Disconnect func()
+	// Logger has the connection ID, remote address and principal of the client.
+	Logger *zap.Logger
+
+	lock sync.Mutex
+	// principal is the authenticated client, the topics matching its patterns are authorized for it.
+	// it is replaced when the client refreshes its token and refreshed is signaled.
+	principal *auth.Principal
+	refreshed chan struct{}
+	// metadata is the metadata of the offer, it is authenticated again on refresh.
+	metadata map[string]string
+	topics   map[string]struct{}
+	closed   bool
+	// explicit are the topics subscribed by name and patterns are the wildcard subscriptions.
+	explicit map[string]struct{}
+	patterns *Trie
 }
 
-func NewSubscriber(stream *quic.SendStream) Subscriber {
-	return Subscriber{
-		Stream:  stream,
-		Corrupt: atomic.NewBool(false),
+func NewSubscriber(writer *Writer) *Subscriber {
+	return &Subscriber{
+		Writer:     writer,
+		Disconnect: nil,
+		Logger:     zap.NewNop(),
+		lock:       sync.Mutex{},
+		principal:  nil,
+		refreshed:  make(chan struct{}, 1),
+		metadata:   nil,
+		topics:     make(map[string]struct{}),
+		closed:     false,
+		explicit:   make(map[string]struct{}),
+		patterns:   NewTrie(),
+	}
+}
+
+// Principal returns the authenticated client.
+func (s *Subscriber) Principal() *auth.Principal {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.principal
+}
+
+// setPrincipal replaces the principal and signals refreshed.
+func (s *Subscriber) setPrincipal(principal *auth.Principal) {
+	s.lock.Lock()
+	s.principal = principal
+	s.lock.Unlock()
+
+	select {
+	case s.refreshed <- struct{}{}:
+	default:
+	}
+}
+
+// join records that the subscriber is added to topic. it fails when the subscriber is closed.
+func (s *Subscriber) join(topic string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return false
 	}
+
+	s.topics[topic] = struct{}{}
+
+	return true
+}
+
+// subscribed reports whether the subscriber is added to topic.
+func (s *Subscriber) subscribed(topic string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, ok := s.topics[topic]
+
+	return ok
+}
+
+// leave records that the subscriber is removed from topic.
+func (s *Subscriber) leave(topic string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.topics, topic)
+}
+
+// topicList returns the topics that the subscriber is in.
+func (s *Subscriber) topicList() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return slices.Collect(maps.Keys(s.topics))
+}
+
+// choose records that topic is subscribed by name.
+func (s *Subscriber) choose(topic string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.explicit[topic] = struct{}{}
+}
+
+// unchoose records that topic is unsubscribed by name.
+func (s *Subscriber) unchoose(topic string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.explicit, topic)
+}
+
+// follow records the wildcard subscription of pattern. it fails when the subscriber is closed.
+func (s *Subscriber) follow(pattern string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return false
+	}
+
+	s.patterns.Insert(pattern)
+
+	return true
+}
+
+// unfollow removes the wildcard subscription of pattern.
+func (s *Subscriber) unfollow(pattern string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.patterns.Delete(pattern)
+}
+
+// patternList returns the wildcard subscriptions.
+func (s *Subscriber) patternList() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.patterns.Values()
+}
+
+// wants reports whether topic is subscribed by name or matches a wildcard subscription.
+func (s *Subscriber) wants(topic string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, ok := s.explicit[topic]
+
+	return ok || len(s.patterns.MatchPatterns(topic)) > 0
+}
+
+// close prevents joining new topics and following new patterns and returns the topics that the subscriber is in.
+func (s *Subscriber) close() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.closed = true
+
+	return slices.Collect(maps.Keys(s.topics))
 }
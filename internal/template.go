@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"slices"
+
+	"github.com/snapp-incubator/qsse/auth"
+)
+
+// templateAuthorizer authorizes the topics matching its template.
+type templateAuthorizer struct {
+	template   *auth.Template
+	authorizer auth.ParamAuthorizer
+}
+
+// AddTemplate authorizes the topics matching template by authorizer with their parameters
+// instead of Authorizer. the first added template which matches a topic is used. the topics
+// matching template are auto created like the topics matching AutoCreate.
+func (s *Server) AddTemplate(template string, authorizer auth.ParamAuthorizer) error {
+	t, err := auth.ParseTemplate(template)
+	if err != nil {
+		return err
+	}
+
+	s.topicLock.Lock()
+	defer s.topicLock.Unlock()
+
+	// templates are copied, so the snapshots that authorize uses don't change.
+	s.templates = append(slices.Clip(s.templates), templateAuthorizer{template: t, authorizer: authorizer})
+
+	s.AutoCreate = append(slices.Clip(s.AutoCreate), t.Pattern())
+	if s.autoCreateIndex != nil {
+		s.autoCreateIndex.Insert(t.Pattern())
+	}
+
+	return nil
+}
+
+// AddTemplateFunc authorizes the topics matching template by authorizer with their parameters.
+func (s *Server) AddTemplateFunc(template string, authorizer auth.ParamAuthorizerFunc) error {
+	return s.AddTemplate(template, authorizer)
+}
+
+// authorize reports whether principal can subscribe to topic, by the authorizer of the first
+// template matching topic or by Authorizer when no template matches.
+func (s *Server) authorize(principal *auth.Principal, topic string) bool {
+	s.topicLock.RLock()
+	templates := s.templates
+	s.topicLock.RUnlock()
+
+	for _, t := range templates {
+		if params, ok := t.template.Match(topic); ok {
+			return t.authorizer.AuthorizeParams(principal, params)
+		}
+	}
+
+	return s.Authorizer.AuthorizePrincipal(principal, topic)
+}
@@ -1,33 +1,93 @@
 package internal
 
 import (
-	"path/filepath"
-
-	"go.uber.org/zap"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
 )
 
 const (
-	pwc = '*' // placeholder wild character
-	sep = '.'
+	sep = "."
+	// wildcard matches exactly one segment.
+	wildcard = "*"
+	// multiWildcard matches one or more segments, it must be the last segment.
+	multiWildcard = ">"
 )
 
 // Finder for topics.
-type Finder struct {
-	Logger *zap.Logger
-}
+type Finder struct{}
 
 // TopicHasWildcard checks if the topic is a wildcard.
 func TopicHasWildcard(topic string) bool {
-	for i, c := range topic {
-		if c == pwc {
-			if (i == 0 || topic[i-1] == sep) &&
-				(i+1 == len(topic) || topic[i+1] == sep) {
-				return true
+	for {
+		segment, rest, more := strings.Cut(topic, sep)
+		if segment == wildcard || segment == multiWildcard {
+			return true
+		}
+
+		if !more {
+			return false
+		}
+
+		topic = rest
+	}
+}
+
+// ValidateTopic checks that topic is dot separated segments which are not empty
+// and have no wildcard, whitespace or control character.
+func ValidateTopic(topic string) error {
+	return validate(topic, false)
+}
+
+// ValidatePattern checks that pattern is a valid topic in which a segment can be the
+// wildcard "*" and the last segment can be the multi-level wildcard ">".
+func ValidatePattern(pattern string) error {
+	return validate(pattern, true)
+}
+
+func validate(topic string, pattern bool) error {
+	for i, segment := range strings.Split(topic, sep) {
+		switch {
+		case segment == "":
+			return fmt.Errorf("%w: %q has an empty segment", ErrInvalidTopic, topic)
+		case pattern && segment == wildcard:
+		case pattern && segment == multiWildcard:
+			if i != strings.Count(topic, sep) {
+				return fmt.Errorf("%w: %q has %s before the last segment", ErrInvalidTopic, topic, multiWildcard)
 			}
+		case strings.ContainsFunc(segment, invalidRune):
+			return fmt.Errorf("%w: %q has an invalid character", ErrInvalidTopic, topic)
 		}
 	}
 
-	return false
+	return nil
+}
+
+func invalidRune(r rune) bool {
+	return r == '*' || r == '>' || unicode.IsSpace(r) || unicode.IsControl(r)
+}
+
+// MatchTopic reports whether topic matches pattern segment by segment. "*" matches
+// any one segment and ">" matches all the remaining segments, at least one.
+func MatchTopic(pattern, topic string) bool {
+	for {
+		p, patternRest, patternMore := strings.Cut(pattern, sep)
+		if p == multiWildcard {
+			return topic != ""
+		}
+
+		t, topicRest, topicMore := strings.Cut(topic, sep)
+		if p != wildcard && p != t {
+			return false
+		}
+
+		if !patternMore || !topicMore {
+			return patternMore == topicMore
+		}
+
+		pattern, topic = patternRest, topicRest
+	}
 }
 
 // FindTopicsList find topics that match the topic pattern.
@@ -35,11 +95,8 @@ func (f *Finder) FindTopicsList(topics []string, pattern string) []string {
 	var matchedTopics []string
 
 	for _, topic := range topics {
-		ok, err := filepath.Match(pattern, topic)
-		if ok {
+		if MatchTopic(pattern, topic) {
 			matchedTopics = append(matchedTopics, topic)
-		} else if err != nil {
-			f.Logger.Warn("error in topic matching")
 		}
 	}
 
@@ -51,11 +108,8 @@ func (f *Finder) FindRelatedWildcardTopics(topic string, topics []string) []stri
 	var matchedTopics []string
 
 	for _, pattern := range topics {
-		ok, err := filepath.Match(pattern, topic)
-		if ok {
+		if MatchTopic(pattern, topic) {
 			matchedTopics = append(matchedTopics, pattern)
-		} else if err != nil {
-			f.Logger.Warn("error in topic matching")
 		}
 	}
 
@@ -65,8 +119,7 @@ func (f *Finder) FindRelatedWildcardTopics(topic string, topics []string) []stri
 // IsSubscribeTopicValid check the subscribed topic exist or matched with client topics.
 func IsSubscribeTopicValid(topic string, topics []string) bool {
 	for _, t := range topics {
-		ok, _ := filepath.Match(topic, t)
-		if ok {
+		if MatchTopic(topic, t) {
 			return true
 		}
 	}
@@ -84,3 +137,201 @@ func AppendIfMissing(topics []string, topic string) []string {
 
 	return append(topics, topic)
 }
+
+// Trie indexes topics by their segments. it finds the stored topics matching a pattern without
+// scanning all of them and the stored patterns matching a topic without matching all of them.
+// it is not safe for concurrent changes.
+type Trie struct {
+	root trieNode
+	size int
+}
+
+type trieNode struct {
+	children map[string]*trieNode
+	// value is the stored topic which ends at the node.
+	value string
+	end   bool
+}
+
+// NewTrie returns a trie of values.
+func NewTrie(values ...string) *Trie {
+	trie := new(Trie)
+
+	for _, value := range values {
+		trie.Insert(value)
+	}
+
+	return trie
+}
+
+// Len returns the number of stored values.
+func (t *Trie) Len() int {
+	return t.size
+}
+
+// Insert stores value and reports whether it was not stored before.
+func (t *Trie) Insert(value string) bool {
+	node := &t.root
+
+	for _, segment := range strings.Split(value, sep) {
+		child, ok := node.children[segment]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[string]*trieNode)
+			}
+
+			child = new(trieNode)
+			node.children[segment] = child
+		}
+
+		node = child
+	}
+
+	if node.end {
+		return false
+	}
+
+	node.value = value
+	node.end = true
+	t.size++
+
+	return true
+}
+
+// Delete removes value and reports whether it was stored.
+func (t *Trie) Delete(value string) bool {
+	segments := strings.Split(value, sep)
+	path := make([]*trieNode, 0, len(segments)+1)
+
+	node := &t.root
+	path = append(path, node)
+
+	for _, segment := range segments {
+		child, ok := node.children[segment]
+		if !ok {
+			return false
+		}
+
+		node = child
+		path = append(path, node)
+	}
+
+	if !node.end {
+		return false
+	}
+
+	node.value = ""
+	node.end = false
+	t.size--
+
+	// the nodes which lead to no value are pruned.
+	for i := len(segments) - 1; i >= 0; i-- {
+		if child := path[i+1]; child.end || len(child.children) > 0 {
+			break
+		}
+
+		delete(path[i].children, segments[i])
+	}
+
+	return true
+}
+
+// Values returns the stored values in order.
+func (t *Trie) Values() []string {
+	values := t.root.collect(nil)
+	slices.Sort(values)
+
+	return values
+}
+
+// Match returns the stored topics matching pattern in order.
+func (t *Trie) Match(pattern string) []string {
+	matches := t.root.match(pattern, nil)
+	slices.Sort(matches)
+
+	return matches
+}
+
+// MatchPatterns returns the stored patterns which match topic in order.
+func (t *Trie) MatchPatterns(topic string) []string {
+	matches := t.root.matchPatterns(topic, nil)
+	slices.Sort(matches)
+
+	return matches
+}
+
+func (n *trieNode) match(pattern string, matches []string) []string {
+	segment, rest, more := strings.Cut(pattern, sep)
+
+	switch segment {
+	case multiWildcard:
+		for _, child := range n.children {
+			matches = child.collect(matches)
+		}
+	case wildcard:
+		for _, child := range n.children {
+			matches = child.next(rest, more, matches)
+		}
+	default:
+		if child, ok := n.children[segment]; ok {
+			matches = child.next(rest, more, matches)
+		}
+	}
+
+	return matches
+}
+
+// next matches the rest of pattern under the node or the node itself when pattern is finished.
+func (n *trieNode) next(rest string, more bool, matches []string) []string {
+	if more {
+		return n.match(rest, matches)
+	}
+
+	if n.end {
+		matches = append(matches, n.value)
+	}
+
+	return matches
+}
+
+// collect returns the values of the node and all its descendants.
+func (n *trieNode) collect(matches []string) []string {
+	if n.end {
+		matches = append(matches, n.value)
+	}
+
+	for _, child := range n.children {
+		matches = child.collect(matches)
+	}
+
+	return matches
+}
+
+func (n *trieNode) matchPatterns(topic string, matches []string) []string {
+	// the multi-level wildcard matches the remaining segments of topic.
+	if child, ok := n.children[multiWildcard]; ok && child.end {
+		matches = append(matches, child.value)
+	}
+
+	segment, rest, more := strings.Cut(topic, sep)
+
+	for _, key := range []string{segment, wildcard} {
+		child, ok := n.children[key]
+		if !ok {
+			continue
+		}
+
+		if more {
+			matches = child.matchPatterns(rest, matches)
+		} else if child.end {
+			matches = append(matches, child.value)
+		}
+
+		// a literal wildcard segment in topic is matched once.
+		if segment == wildcard {
+			break
+		}
+	}
+
+	return matches
+}
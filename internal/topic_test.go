@@ -1,6 +1,8 @@
 package internal_test
 
 import (
+	"errors"
+	"strconv"
 	"testing"
 
 	"github.com/snapp-incubator/qsse/internal"
@@ -28,6 +30,16 @@ func TestTopicHasWildcard(t *testing.T) {
 			topic:       "*.ride.*",
 			hasWildcard: true,
 		},
+		{
+			name:        "topic has multi-level wildcard",
+			topic:       "ride.>",
+			hasWildcard: true,
+		},
+		{
+			name:        "wildcard in a segment is not a wildcard",
+			topic:       "ride.a*",
+			hasWildcard: false,
+		},
 		{
 			name:        "topic has not  wildcard",
 			topic:       "ride.passenger.125",
@@ -63,6 +75,12 @@ func TestFindTopicsList(t *testing.T) {
 			topics:        []string{"ride.accepted", "ride.rejected", "ride.finished", "offer.first"},
 			matchedTopics: []string{"ride.accepted", "ride.rejected", "ride.finished"},
 		},
+		{
+			name:          "multi-level wildcard",
+			pattern:       "ride.>",
+			topics:        []string{"ride", "ride.accepted", "ride.driver.accepted", "offer.first"},
+			matchedTopics: []string{"ride.accepted", "ride.driver.accepted"},
+		},
 		{
 			name:          "has matched topics",
 			pattern:       "ride.accepted",
@@ -71,9 +89,7 @@ func TestFindTopicsList(t *testing.T) {
 		},
 	}
 
-	f := internal.Finder{
-		Logger: internal.NewLogger().Named("topic-finder"),
-	}
+	var f internal.Finder
 
 	for _, test := range tests {
 		testCase := test
@@ -105,16 +121,20 @@ func TestFindRelatedWildcardTopics(t *testing.T) {
 			matchedTopics: []string{"ride.*"},
 		},
 		{
-			name:          "has multiple matched topic",
+			name:          "wildcard matches one segment",
 			topic:         "ride.driver.*",
 			topics:        []string{"ride.*", "call.start", "ride.driver.*"},
-			matchedTopics: []string{"ride.*", "ride.driver.*"},
+			matchedTopics: []string{"ride.driver.*"},
+		},
+		{
+			name:          "has multiple matched topic",
+			topic:         "ride.driver.start",
+			topics:        []string{"ride.>", "call.start", "ride.*.start", "ride.driver.*"},
+			matchedTopics: []string{"ride.>", "ride.*.start", "ride.driver.*"},
 		},
 	}
 
-	f := internal.Finder{
-		Logger: internal.NewLogger().Named("topic-finder"),
-	}
+	var f internal.Finder
 
 	for _, test := range tests {
 		testCase := test
@@ -127,3 +147,172 @@ func TestFindRelatedWildcardTopics(t *testing.T) {
 		})
 	}
 }
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		pattern string
+		topic   string
+		match   bool
+	}{
+		{pattern: "ride.accepted", topic: "ride.accepted", match: true},
+		{pattern: "ride.accepted", topic: "ride.accepted.now", match: false},
+		{pattern: "ride.accepted.now", topic: "ride.accepted", match: false},
+		{pattern: "ride.*", topic: "ride.accepted", match: true},
+		{pattern: "ride.*", topic: "ride.driver.accepted", match: false},
+		{pattern: "ride.*", topic: "ride", match: false},
+		{pattern: "*.accepted", topic: "ride.accepted", match: true},
+		{pattern: "ride.*.accepted", topic: "ride.driver.accepted", match: true},
+		{pattern: "ride.>", topic: "ride.accepted", match: true},
+		{pattern: "ride.>", topic: "ride.driver.accepted", match: true},
+		{pattern: "ride.>", topic: "ride", match: false},
+		{pattern: ">", topic: "ride.driver.accepted", match: true},
+		{pattern: "ride.*.>", topic: "ride.driver", match: false},
+		{pattern: "ride.*.>", topic: "ride.driver.accepted", match: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pattern+" "+test.topic, func(t *testing.T) {
+			assert.Equal(t, test.match, internal.MatchTopic(test.pattern, test.topic))
+		})
+	}
+}
+
+func TestValidateTopic(t *testing.T) {
+	tests := []struct {
+		topic        string
+		topicValid   bool
+		patternValid bool
+	}{
+		{topic: "ride.accepted", topicValid: true, patternValid: true},
+		{topic: "ride_1.driver-2", topicValid: true, patternValid: true},
+		{topic: "", topicValid: false, patternValid: false},
+		{topic: "ride..accepted", topicValid: false, patternValid: false},
+		{topic: ".ride", topicValid: false, patternValid: false},
+		{topic: "ride.", topicValid: false, patternValid: false},
+		{topic: "ride accepted", topicValid: false, patternValid: false},
+		{topic: "ride.\taccepted", topicValid: false, patternValid: false},
+		{topic: "ride.*", topicValid: false, patternValid: true},
+		{topic: "*.*", topicValid: false, patternValid: true},
+		{topic: "ride.>", topicValid: false, patternValid: true},
+		{topic: ">", topicValid: false, patternValid: true},
+		{topic: "ride.>.accepted", topicValid: false, patternValid: false},
+		{topic: "ride.a*", topicValid: false, patternValid: false},
+		{topic: "ride.a>", topicValid: false, patternValid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.topic, func(t *testing.T) {
+			err := internal.ValidateTopic(test.topic)
+			assert.Equal(t, test.topicValid, err == nil)
+			assert.True(t, err == nil || errors.Is(err, internal.ErrInvalidTopic))
+
+			err = internal.ValidatePattern(test.topic)
+			assert.Equal(t, test.patternValid, err == nil)
+			assert.True(t, err == nil || errors.Is(err, internal.ErrInvalidTopic))
+		})
+	}
+}
+
+func TestTrie(t *testing.T) {
+	trie := internal.NewTrie("ride.accepted", "ride.driver.accepted", "ride.driver.rejected", "offer.first")
+
+	assert.Equal(t, 4, trie.Len())
+	assert.False(t, trie.Insert("ride.accepted"))
+
+	assert.Equal(t, []string{"ride.accepted"}, trie.Match("ride.accepted"))
+	assert.Equal(t, []string{"ride.accepted"}, trie.Match("ride.*"))
+	assert.Equal(t, []string{"ride.driver.accepted", "ride.driver.rejected"}, trie.Match("ride.driver.*"))
+	assert.Equal(t, []string{"ride.driver.accepted"}, trie.Match("*.*.accepted"))
+	assert.Equal(t, []string{"ride.accepted", "ride.driver.accepted", "ride.driver.rejected"}, trie.Match("ride.>"))
+	assert.Empty(t, trie.Match("ride"))
+	assert.Empty(t, trie.Match("ride.driver.accepted.now"))
+
+	assert.True(t, trie.Delete("ride.driver.accepted"))
+	assert.False(t, trie.Delete("ride.driver.accepted"))
+	assert.False(t, trie.Delete("ride.driver"))
+	assert.Equal(t, 3, trie.Len())
+	assert.Equal(t, []string{"ride.driver.rejected"}, trie.Match("ride.driver.*"))
+
+	assert.True(t, trie.Delete("ride.driver.rejected"))
+	assert.Empty(t, trie.Match("ride.*.*"))
+	assert.Equal(t, []string{"ride.accepted"}, trie.Match("ride.>"))
+	assert.Equal(t, []string{"offer.first", "ride.accepted"}, trie.Values())
+}
+
+func TestTrieMatchPatterns(t *testing.T) {
+	trie := internal.NewTrie("ride.*", "ride.>", "ride.*.accepted", "*.driver.*", "ride.driver.accepted", ">", "offer.*")
+
+	assert.Equal(t,
+		[]string{"*.driver.*", ">", "ride.*.accepted", "ride.>", "ride.driver.accepted"},
+		trie.MatchPatterns("ride.driver.accepted"),
+	)
+	assert.Equal(t, []string{">", "ride.*", "ride.>"}, trie.MatchPatterns("ride.accepted"))
+	assert.Equal(t, []string{">"}, trie.MatchPatterns("ride"))
+}
+
+// topics returns n topics of four segments, e.g. ride.12.driver.3.
+func topics(n int) []string {
+	topics := make([]string, 0, n)
+
+	for i := range n {
+		topics = append(topics, "ride."+strconv.Itoa(i/100)+".driver."+strconv.Itoa(i%100))
+	}
+
+	return topics
+}
+
+func BenchmarkTrieMatch(b *testing.B) {
+	trie := internal.NewTrie(topics(100_000)...)
+
+	b.ResetTimer()
+
+	for range b.N {
+		trie.Match("ride.500.driver.*")
+	}
+}
+
+func BenchmarkFindTopicsList(b *testing.B) {
+	topics := topics(100_000)
+
+	var f internal.Finder
+
+	b.ResetTimer()
+
+	for range b.N {
+		f.FindTopicsList(topics, "ride.500.driver.*")
+	}
+}
+
+func BenchmarkTrieMatchPatterns(b *testing.B) {
+	patterns := topics(100_000)
+	for i := range patterns {
+		if i%2 == 0 {
+			patterns[i] = "ride.*.driver." + strconv.Itoa(i%100)
+		}
+	}
+
+	trie := internal.NewTrie(patterns...)
+
+	b.ResetTimer()
+
+	for range b.N {
+		trie.MatchPatterns("ride.500.driver.1")
+	}
+}
+
+func BenchmarkFindRelatedWildcardTopics(b *testing.B) {
+	patterns := topics(100_000)
+	for i := range patterns {
+		if i%2 == 0 {
+			patterns[i] = "ride.*.driver." + strconv.Itoa(i%100)
+		}
+	}
+
+	var f internal.Finder
+
+	b.ResetTimer()
+
+	for range b.N {
+		f.FindRelatedWildcardTopics("ride.500.driver.1", patterns)
+	}
+}
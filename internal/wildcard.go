@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"errors"
+	"maps"
+
+	"go.uber.org/zap"
+)
+
+// subscribePattern subscribes the subscriber to the existing topics matching pattern and
+// to the topics which are added later. each topic is authorized for the subscriber principal.
+func (s *Server) subscribePattern(subscriber *Subscriber, pattern string, lastEventIDs map[string]uint64) error {
+	s.topicLock.Lock()
+
+	if !subscriber.follow(pattern) {
+		s.topicLock.Unlock()
+
+		return ErrWriterClosed
+	}
+
+	s.watch(subscriber, pattern)
+	topics := s.index().Match(pattern)
+
+	s.topicLock.Unlock()
+
+	for _, topic := range topics {
+		source, ok := s.eventSource(topic)
+		if !ok {
+			continue
+		}
+
+		lastEventID, replay := lastEventIDs[topic]
+
+		if err := s.attach(subscriber, source, lastEventID, replay); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unsubscribePattern removes the wildcard subscription of pattern and unsubscribes the subscriber
+// from the matching topics which are neither subscribed by name nor matching another pattern.
+func (s *Server) unsubscribePattern(subscriber *Subscriber, pattern string) {
+	s.topicLock.Lock()
+
+	subscriber.unfollow(pattern)
+	s.unwatch(subscriber, pattern)
+
+	s.topicLock.Unlock()
+
+	for _, topic := range s.Finder.FindTopicsList(subscriber.topicList(), pattern) {
+		if subscriber.wants(topic) {
+			continue
+		}
+
+		if source, ok := s.eventSource(topic); ok {
+			source.RemoveSubscriber(subscriber)
+		}
+	}
+}
+
+// attach adds the subscriber to a topic matching its pattern when the client is authorized for it.
+func (s *Server) attach(subscriber *Subscriber, source *EventSource, lastEventID uint64, replay bool) error {
+	if !s.authorize(subscriber.Principal(), source.Topic) {
+		subscriber.Logger.Debug("client is not authorized for topic of pattern", zap.String("topic", source.Topic))
+
+		return nil
+	}
+
+	err := source.AddSubscriber(subscriber, lastEventID, replay)
+
+	// the topic is removed after it is matched.
+	if errors.Is(err, ErrTopicNotAvailable) {
+		return nil
+	}
+
+	return err
+}
+
+// attachWildcards adds the subscribers with a pattern matching the new topic of source.
+func (s *Server) attachWildcards(source *EventSource) {
+	subscribers := make(map[*Subscriber]struct{})
+
+	s.topicLock.RLock()
+	if s.patterns != nil {
+		for _, pattern := range s.patterns.MatchPatterns(source.Topic) {
+			maps.Copy(subscribers, s.wildcards[pattern])
+		}
+	}
+	s.topicLock.RUnlock()
+
+	for subscriber := range subscribers {
+		if err := s.attach(subscriber, source, 0, false); err != nil {
+			subscriber.Logger.Debug("failed to add subscriber of pattern", zap.String("topic", source.Topic), zap.Error(err))
+		}
+	}
+}
+
+// forgetWildcards removes the wildcard subscriptions of a closed subscriber.
+func (s *Server) forgetWildcards(subscriber *Subscriber) {
+	s.topicLock.Lock()
+	defer s.topicLock.Unlock()
+
+	for _, pattern := range subscriber.patternList() {
+		s.unwatch(subscriber, pattern)
+	}
+}
+
+// watch records the subscriber of pattern, so it is added to the new topics matching pattern.
+// topicLock must be held.
+func (s *Server) watch(subscriber *Subscriber, pattern string) {
+	if s.wildcards == nil {
+		s.wildcards = make(map[string]map[*Subscriber]struct{})
+		s.patterns = NewTrie()
+	}
+
+	if _, ok := s.wildcards[pattern]; !ok {
+		s.wildcards[pattern] = make(map[*Subscriber]struct{})
+		s.patterns.Insert(pattern)
+	}
+
+	s.wildcards[pattern][subscriber] = struct{}{}
+}
+
+// unwatch removes the subscriber of pattern and the pattern when it has no subscriber.
+// topicLock must be held.
+func (s *Server) unwatch(subscriber *Subscriber, pattern string) {
+	subscribers, ok := s.wildcards[pattern]
+	if !ok {
+		return
+	}
+
+	delete(subscribers, subscriber)
+
+	if len(subscribers) == 0 {
+		delete(s.wildcards, pattern)
+		s.patterns.Delete(pattern)
+	}
+}
@@ -1,10 +1,12 @@
 package internal
 
 import (
-	"context"
+	"errors"
 	"runtime"
+	"sync"
 
 	"github.com/mehditeymorian/koi"
+	quic "github.com/quic-go/quic-go"
 	"go.uber.org/zap"
 )
 
@@ -16,6 +18,8 @@ const (
 type Worker struct {
 	Pond   *koi.Pond
 	Logger *zap.Logger
+	// Pending tracks distribute works that are queued or running.
+	Pending *sync.WaitGroup
 }
 
 type WorkerConfig struct {
@@ -29,6 +33,7 @@ func NewWorker(cfg WorkerConfig, l *zap.Logger) Worker {
 	var worker Worker
 
 	worker.Logger = l
+	worker.Pending = new(sync.WaitGroup)
 
 	pond := koi.NewPond()
 	worker.Pond = pond
@@ -39,30 +44,45 @@ func NewWorker(cfg WorkerConfig, l *zap.Logger) Worker {
 }
 
 type DistributeWork struct {
-	Event       []byte
+	Event       *Event
 	EventSource *EventSource
+	Subscribers []*Subscriber
 }
 
-func NewDistributeWork(event []byte, eventSource *EventSource) *DistributeWork {
-	return &DistributeWork{Event: event, EventSource: eventSource}
+func NewDistributeWork(event *Event, eventSource *EventSource, subscribers []*Subscriber) *DistributeWork {
+	return &DistributeWork{Event: event, EventSource: eventSource, Subscribers: subscribers}
 }
 
 func (w *Worker) AddDistributeWork(work *DistributeWork) {
+	w.Pending.Add(1)
+
 	_, err := w.Pond.AddWork(DistributeEvent, work)
 	if err != nil {
+		w.Pending.Done()
+		work.EventSource.works.Done()
 		w.Logger.Error("failed to add distribute work", zap.Error(err))
 	}
 }
 
 func (w *Worker) AddAcceptClientWork(server *Server, count int) {
 	for range count {
+		server.group.Add(1)
+
 		_, err := w.Pond.AddWork(AcceptClient, server)
 		if err != nil {
+			server.group.Done()
 			w.Logger.Error("failed to add accept client work", zap.Error(err))
 		}
 	}
 }
 
+// Stop closes the worker queues. no work can be added afterwards.
+func (w *Worker) Stop() {
+	for _, worker := range w.Pond.Workers {
+		close(worker.RequestChan)
+	}
+}
+
 // acceptClientWork accepts clients and do the following steps.
 // 1. Accept a receivedStream.
 // 2. Read client authentication token and topics.
@@ -77,11 +97,15 @@ func (w *Worker) acceptClientWork(work any) any {
 		return nil
 	}
 
-	for {
-		background := context.Background()
+	defer server.group.Done()
 
-		connection, err := server.Listener.Accept(background)
+	for {
+		connection, err := server.Listener.Accept(server.context())
 		if err != nil {
+			if server.closing.Load() || errors.Is(err, quic.ErrServerClosed) {
+				return nil
+			}
+
 			w.Logger.Error("failed to accept new client", zap.Error(err))
 
 			continue
@@ -89,7 +113,7 @@ func (w *Worker) acceptClientWork(work any) any {
 
 		w.Logger.Info("found a new client")
 
-		go server.handleClient(connection)
+		server.spawn(func() { server.handleClient(connection) })
 	}
 }
 
@@ -110,6 +134,8 @@ func (w *Worker) registerWorkers(cfg WorkerConfig) {
 }
 
 func (w *Worker) distributeWork(work any) any {
+	defer w.Pending.Done()
+
 	data, ok := work.(*DistributeWork)
 	if !ok {
 		w.Logger.Warn("Worker: invalid work input")
@@ -118,20 +144,36 @@ func (w *Worker) distributeWork(work any) any {
 	}
 
 	topic := data.EventSource.Topic
-	eventData := data.Event
+	event := data.Event
 	eventSource := data.EventSource
-	event := NewEvent(topic, eventData)
+
+	defer eventSource.works.Done()
 
 	eventSource.Metrics.DecEvent(topic)
 
-	for _, subscriber := range eventSource.Subscribers {
-		if subscriber.Corrupt.Load() {
-			continue
+	// the event is encoded once per encoding for all the subscribers.
+	frames := make(map[Encoding][]byte, 1)
+
+	// subscribers whose writer is stopped are being removed, so their errors are ignored.
+	for _, subscriber := range data.Subscribers {
+		encoding := subscriber.Writer.Encoding
+
+		frame, ok := frames[encoding]
+		if !ok {
+			var err error
+
+			frame, err = encoding.Encode(event)
+			if err != nil {
+				w.Logger.Error("failed to encode event", zap.Error(err))
+
+				return nil
+			}
+
+			frames[encoding] = frame
 		}
 
-		if err := WriteData(event, subscriber.Stream); err != nil {
-			w.Logger.Warn("err while sending event to client", zap.Error(err))
-			subscriber.Corrupt.Store(true)
+		if err := eventSource.Push(subscriber, frame); err != nil {
+			subscriber.Logger.Debug("failed to send event to client", zap.String("topic", topic), zap.Error(err))
 		}
 	}
 
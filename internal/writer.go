@@ -0,0 +1,354 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefWriterQueueSize is the number of frames that can wait for a client connection.
+const DefWriterQueueSize = 64
+
+// OverflowPolicy is what happens to an event when the queue of a client is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDropOldest drops the oldest queued event to make room for the new one. it is the
+	// zero policy, because it never waits for the client.
+	OverflowDropOldest OverflowPolicy = iota
+	// OverflowBlock waits for the client until the timeout and then drops the event.
+	OverflowBlock
+	// OverflowDropNewest drops the new event.
+	OverflowDropNewest
+	// OverflowDisconnect disconnects the client with CodeSlowConsumer.
+	OverflowDisconnect
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropOldest:
+		return "drop_oldest"
+	case OverflowDropNewest:
+		return "drop_newest"
+	case OverflowDisconnect:
+		return "disconnect"
+	default:
+		return "unknown"
+	}
+}
+
+// Overflow is the overflow policy of a topic. Timeout is only used by OverflowBlock,
+// zero or negative waits until the client reads the queued events.
+type Overflow struct {
+	Policy  OverflowPolicy
+	Timeout time.Duration
+}
+
+// Writer is the only one writing on a client send stream. frames of all the topics
+// are queued and a single goroutine writes them, so they never interleave.
+// Stream is the client send stream.
+type Writer struct {
+	Stream io.WriteCloser
+	// Encoding is the encoding of events that the client reads.
+	Encoding Encoding
+
+	size int
+	// queue has the frames waiting from the oldest, it is guarded by lock.
+	queue []queuedFrame
+	lock  sync.Mutex
+	// ready is signaled when a frame is queued and space is closed when a frame is taken.
+	ready chan struct{}
+	space chan struct{}
+
+	closing chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// queuedFrame is a queued frame and the topic of its event. topic is empty for the frames
+// which are not events of a topic, e.g. the handshake and errors, so they are never dropped.
+type queuedFrame struct {
+	frame []byte
+	topic string
+}
+
+func NewWriter(stream io.WriteCloser, queueSize int) *Writer {
+	return &Writer{
+		Stream:   stream,
+		Encoding: EncodingJSON,
+		size:     queueSize,
+		queue:    make([]queuedFrame, 0, queueSize),
+		lock:     sync.Mutex{},
+		ready:    make(chan struct{}, 1),
+		space:    make(chan struct{}),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+		once:     sync.Once{},
+	}
+}
+
+// Cap returns the number of frames that can be queued.
+func (w *Writer) Cap() int {
+	return w.size
+}
+
+// Run writes the queued frames until the writer is closed, writing fails or ctx is done.
+// frames that are queued before closing are written and then the stream is closed.
+func (w *Writer) Run(ctx context.Context) {
+	defer close(w.done)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		if frame, ok := w.take(); ok {
+			if _, err := w.Stream.Write(frame); err != nil {
+				return
+			}
+
+			continue
+		}
+
+		select {
+		case <-w.ready:
+		case <-w.closing:
+			w.flush()
+
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// take removes the oldest frame from the queue.
+func (w *Writer) take() ([]byte, bool) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if len(w.queue) == 0 {
+		return nil, false
+	}
+
+	frame := w.queue[0].frame
+	w.queue[0] = queuedFrame{frame: nil, topic: ""}
+	w.queue = w.queue[1:]
+
+	close(w.space)
+	w.space = make(chan struct{})
+
+	return frame, true
+}
+
+// offer queues the frame when the queue has space, otherwise it returns a channel
+// which is closed when a frame is taken.
+func (w *Writer) offer(frame queuedFrame) (<-chan struct{}, error) {
+	select {
+	case <-w.closing:
+		return nil, ErrWriterClosed
+	case <-w.done:
+		return nil, ErrWriterClosed
+	default:
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if len(w.queue) >= w.size {
+		return w.space, nil
+	}
+
+	w.enqueue(frame)
+
+	return nil, nil
+}
+
+// enqueue appends the frame to the queue and wakes up Run, lock must be held.
+func (w *Writer) enqueue(frame queuedFrame) {
+	w.queue = append(w.queue, frame)
+
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
+
+// wait queues the frame and waits for space while the queue is full. it gives up when
+// cancel is closed or timeout fires and reports whether the frame is queued.
+func (w *Writer) wait(frame queuedFrame, cancel <-chan struct{}, timeout <-chan time.Time) (bool, error) {
+	for {
+		space, err := w.offer(frame)
+		if err != nil {
+			return false, err
+		}
+
+		if space == nil {
+			return true, nil
+		}
+
+		select {
+		case <-space:
+		case <-w.closing:
+			return false, ErrWriterClosed
+		case <-w.done:
+			return false, ErrWriterClosed
+		case <-cancel:
+			return false, nil
+		case <-timeout:
+			return false, nil
+		}
+	}
+}
+
+// Send queues the frame. it blocks while the queue is full and fails when the writer is stopped.
+// the frame is never dropped by an overflow policy.
+func (w *Writer) Send(frame []byte) error {
+	_, err := w.wait(queuedFrame{frame: frame, topic: ""}, nil, nil)
+
+	return err
+}
+
+// SendContext queues the frame like Send and gives up when ctx is done.
+func (w *Writer) SendContext(ctx context.Context, frame []byte) error {
+	queued, err := w.wait(queuedFrame{frame: frame, topic: ""}, ctx.Done(), nil)
+	if err != nil {
+		return err
+	}
+
+	if !queued {
+		return ctx.Err()
+	}
+
+	return nil
+}
+
+// Push queues the frame of an event of topic and applies the overflow policy when the queue is full.
+// it reports whether a frame is dropped and fails with ErrSlowConsumer when the policy is OverflowDisconnect.
+// OverflowDropOldest only drops the frames of topic.
+func (w *Writer) Push(frame []byte, topic string, overflow Overflow) (bool, error) {
+	queued := queuedFrame{frame: frame, topic: topic}
+
+	space, err := w.offer(queued)
+	if err != nil || space == nil {
+		return false, err
+	}
+
+	switch overflow.Policy {
+	case OverflowDropNewest:
+		return true, nil
+	case OverflowDropOldest:
+		return w.replaceOldest(queued)
+	case OverflowDisconnect:
+		return false, ErrSlowConsumer
+	case OverflowBlock:
+	}
+
+	if overflow.Timeout <= 0 {
+		return false, w.Send(frame)
+	}
+
+	timer := time.NewTimer(overflow.Timeout)
+	defer timer.Stop()
+
+	ok, err := w.wait(queued, nil, timer.C)
+
+	return !ok && err == nil, err
+}
+
+// replaceOldest drops the oldest queued frame of the same topic to make room for the frame.
+// the frame itself is dropped when no frame of its topic is queued. it reports whether a frame is dropped.
+func (w *Writer) replaceOldest(frame queuedFrame) (bool, error) {
+	select {
+	case <-w.closing:
+		return false, ErrWriterClosed
+	case <-w.done:
+		return false, ErrWriterClosed
+	default:
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if len(w.queue) < w.size {
+		w.enqueue(frame)
+
+		return false, nil
+	}
+
+	for i, queued := range w.queue {
+		if frame.topic != "" && queued.topic == frame.topic {
+			w.queue = slices.Delete(w.queue, i, i+1)
+			w.enqueue(frame)
+
+			return true, nil
+		}
+	}
+
+	return true, nil
+}
+
+// SendEvent encodes the event by the writer encoding and queues it.
+func (w *Writer) SendEvent(event *Event) error {
+	frame, err := w.Encoding.Encode(event)
+	if err != nil {
+		return err
+	}
+
+	return w.Send(frame)
+}
+
+// Close stops accepting frames, the remaining ones are written before closing the stream.
+func (w *Writer) Close() {
+	w.once.Do(func() {
+		close(w.closing)
+	})
+}
+
+// Done is closed when the writer stops.
+func (w *Writer) Done() <-chan struct{} {
+	return w.done
+}
+
+func (w *Writer) flush() {
+	for {
+		frame, ok := w.take()
+		if !ok {
+			_ = w.Stream.Close()
+
+			return
+		}
+
+		if _, err := w.Stream.Write(frame); err != nil {
+			return
+		}
+	}
+}
+
+// EncodeFrame encodes data and appends the delimiter, so it can be written at once.
+func EncodeFrame(data any) ([]byte, error) {
+	var bytes []byte
+
+	switch data := data.(type) {
+	case []byte:
+		bytes = make([]byte, 0, len(data)+1)
+		bytes = append(bytes, data...)
+	default:
+		b, err := json.Marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("marshaling data to json failed %w", err)
+		}
+
+		bytes = b
+	}
+
+	return append(bytes, DELIMITER), nil
+}
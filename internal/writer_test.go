@@ -0,0 +1,155 @@
+package internal_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/snapp-incubator/qsse/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fullWriter returns a writer that is not running and its queue is full of frames "1" and "2" of topic.
+func fullWriter(t *testing.T) (*internal.Writer, *sink) {
+	t.Helper()
+
+	stream := new(sink)
+	writer := internal.NewWriter(stream, 2)
+
+	for _, frame := range []string{"1", "2"} {
+		dropped, err := writer.Push([]byte(frame), "topic", internal.Overflow{Policy: internal.OverflowDisconnect, Timeout: 0})
+		require.NoError(t, err)
+		require.False(t, dropped)
+	}
+
+	return writer, stream
+}
+
+// queued closes the writer and returns the frames that it writes on stream.
+func queued(t *testing.T, writer *internal.Writer, stream *sink) []string {
+	t.Helper()
+
+	writer.Close()
+	writer.Run(context.Background())
+
+	stream.lock.Lock()
+	defer stream.lock.Unlock()
+
+	frames := make([]string, 0, len(stream.frames))
+	for _, frame := range stream.frames {
+		frames = append(frames, string(frame))
+	}
+
+	return frames
+}
+
+func TestWriterOverflow(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		overflow internal.Overflow
+		dropped  bool
+		err      error
+		queued   []string
+	}{
+		{
+			name:     "drop oldest",
+			overflow: internal.Overflow{Policy: internal.OverflowDropOldest, Timeout: 0},
+			dropped:  true,
+			err:      nil,
+			queued:   []string{"2", "3"},
+		},
+		{
+			name:     "drop newest",
+			overflow: internal.Overflow{Policy: internal.OverflowDropNewest, Timeout: 0},
+			dropped:  true,
+			err:      nil,
+			queued:   []string{"1", "2"},
+		},
+		{
+			name:     "disconnect",
+			overflow: internal.Overflow{Policy: internal.OverflowDisconnect, Timeout: 0},
+			dropped:  false,
+			err:      internal.ErrSlowConsumer,
+			queued:   []string{"1", "2"},
+		},
+		{
+			name:     "block with timeout",
+			overflow: internal.Overflow{Policy: internal.OverflowBlock, Timeout: 10 * time.Millisecond},
+			dropped:  true,
+			err:      nil,
+			queued:   []string{"1", "2"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			writer, stream := fullWriter(t)
+
+			dropped, err := writer.Push([]byte("3"), "topic", test.overflow)
+
+			assert.ErrorIs(t, err, test.err)
+			assert.Equal(t, test.dropped, dropped)
+			assert.Equal(t, test.queued, queued(t, writer, stream))
+		})
+	}
+}
+
+func TestWriterBlockUntilWritten(t *testing.T) {
+	t.Parallel()
+
+	writer, _ := fullWriter(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	time.AfterFunc(10*time.Millisecond, func() { go writer.Run(ctx) })
+
+	dropped, err := writer.Push([]byte("3"), "topic", internal.Overflow{Policy: internal.OverflowBlock, Timeout: time.Second})
+
+	assert.NoError(t, err)
+	assert.False(t, dropped)
+}
+
+func TestWriterClosedOverflow(t *testing.T) {
+	t.Parallel()
+
+	writer, _ := fullWriter(t)
+	writer.Close()
+
+	_, err := writer.Push([]byte("3"), "topic", internal.Overflow{Policy: internal.OverflowBlock, Timeout: 0})
+
+	assert.ErrorIs(t, err, internal.ErrWriterClosed)
+}
+
+func TestWriterDropOldestOfTopic(t *testing.T) {
+	t.Parallel()
+
+	stream := new(sink)
+	writer := internal.NewWriter(stream, 3)
+	overflow := internal.Overflow{Policy: internal.OverflowDropOldest, Timeout: 0}
+
+	require.NoError(t, writer.Send([]byte("handshake")))
+
+	for _, frame := range []struct{ data, topic string }{{"a1", "a"}, {"b1", "b"}} {
+		dropped, err := writer.Push([]byte(frame.data), frame.topic, overflow)
+		require.NoError(t, err)
+		require.False(t, dropped)
+	}
+
+	// only the oldest frame of the same topic is dropped, never the control frames or other topics.
+	dropped, err := writer.Push([]byte("b2"), "b", overflow)
+	require.NoError(t, err)
+	assert.True(t, dropped)
+
+	// the frame is dropped when its topic has nothing to replace.
+	dropped, err = writer.Push([]byte("c1"), "c", overflow)
+	require.NoError(t, err)
+	assert.True(t, dropped)
+
+	assert.Equal(t, []string{"handshake", "a1", "b2"}, queued(t, writer, stream))
+}
@@ -0,0 +1,51 @@
+package qsse
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/snapp-incubator/qsse/internal"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const DefLogLevel = "info"
+
+// LogConfig configures the logs of a server or client. the logs of connections have the
+// connection, remote_addr and principal fields and the logs of topics have the topic field.
+type LogConfig struct {
+	// Logger replaces the default logger which writes to stderr.
+	Logger *zap.Logger
+	// Handler is a log/slog handler that the logs are written to when Logger is nil, e.g. slog.Default().Handler().
+	Handler slog.Handler
+	// Level is the minimum level of the default logger: "debug", "info", "warn" or "error", DefLogLevel by default.
+	Level string
+	// Syslog writes the logs of the default logger to the local syslog too.
+	Syslog bool
+}
+
+// newLogger returns the logger of config, nil config is the default logger.
+func newLogger(config *LogConfig) (*zap.Logger, error) {
+	if config == nil {
+		config = &LogConfig{Logger: nil, Handler: nil, Level: DefLogLevel, Syslog: false}
+	}
+
+	switch {
+	case config.Logger != nil:
+		return config.Logger, nil
+	case config.Handler != nil:
+		return internal.NewSlogLogger(config.Handler), nil
+	}
+
+	level := config.Level
+	if level == "" {
+		level = DefLogLevel
+	}
+
+	lvl, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level: %w", err)
+	}
+
+	return internal.NewLogger(lvl, config.Syslog), nil
+}
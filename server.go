@@ -1,7 +1,10 @@
 package qsse
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"net"
 	"net/http"
 	"time"
 
@@ -9,6 +12,7 @@ import (
 	quic "github.com/quic-go/quic-go"
 	"github.com/snapp-incubator/qsse/auth"
 	"github.com/snapp-incubator/qsse/internal"
+	"github.com/snapp-incubator/qsse/store"
 )
 
 const (
@@ -17,48 +21,267 @@ const (
 	DefClientAcceptorQueueSize   = 1
 	DefEventDistributorCount     = 1
 	DefEVentDistributorQueueSize = 10
+	DefPublishQueueSize          = 64
+	DefHistorySize               = 0
+	DefHistoryMaxAge             = 0
+	DefStoreTruncateInterval     = time.Minute
+	DefReplayLimit               = internal.DefReplayLimit
+	DefQueueSize                 = internal.DefWriterQueueSize
+	DefBlockTimeout              = time.Second
+	DefTopicIdleTimeout          = 5 * time.Minute
+	DefExpiryNotice              = 30 * time.Second
+	DefRevocationTTL             = 24 * time.Hour
+	DefAuthorizationCacheTTL     = auth.DefCacheTTL
+	DefAuthorizationCacheSize    = auth.DefCacheSize
 )
 
+// OverflowPolicy is what happens to an event when the queue of a client is full.
+type OverflowPolicy = internal.OverflowPolicy
+
+const (
+	// OverflowDropOldest drops the oldest queued event to make room for the new one. it is the default,
+	// because the events of all the topics are distributed by the same distributors, so waiting for a
+	// slow client delays the events of the other clients.
+	OverflowDropOldest = internal.OverflowDropOldest
+	// OverflowBlock waits for the client until the timeout and then drops the event.
+	OverflowBlock = internal.OverflowBlock
+	// OverflowDropNewest drops the new event.
+	OverflowDropNewest = internal.OverflowDropNewest
+	// OverflowDisconnect disconnects the client with CodeSlowConsumer.
+	OverflowDisconnect = internal.OverflowDisconnect
+)
+
+// Overflow is an overflow policy. Timeout is only used by OverflowBlock, zero is DefBlockTimeout
+// and negative waits until the client reads the queued events.
+type Overflow = internal.Overflow
+
 type ServerConfig struct {
 	Metric    *MetricConfig
 	TLSConfig *tls.Config
-	Worker    *WorkerConfig
+	// MTLS asks the clients for certificates, e.g. to authenticate them by auth.CertificateAuthenticator.
+	MTLS    *MTLSConfig
+	Worker  *WorkerConfig
+	History *HistoryConfig
+	Queue   *QueueConfig
+	Topics  *TopicConfig
+	// Versions are the accepted protocol versions from the most preferred, all the supported
+	// versions by default. NextProtos of TLSConfig is replaced by their ALPN tokens.
+	Versions []int
+	// Log replaces the default logger, e.g. by the logger of the application.
+	Log *LogConfig
+
+	// OnConnect is called when a client is authenticated.
+	OnConnect func(principal *auth.Principal)
+	// OnDisconnect is called when an authenticated client is disconnected.
+	OnDisconnect func(principal *auth.Principal)
+	// AuthorizationCache caches the decisions of the authorizer, e.g. of a remote permission service,
+	// so reconnecting clients don't check their topics again. nil doesn't cache them.
+	AuthorizationCache *AuthorizationCacheConfig
+	// ExpiryNotice is how long before the principal of a client expires, see auth.Principal.ExpiresAt, it is
+	// notified by CodeTokenExpiring to refresh its token. the client is disconnected when it expires.
+	ExpiryNotice time.Duration
+	// RevocationTTL is how long a token which has no connection or expiry is refused after Server.RevokeToken.
+	RevocationTTL time.Duration
+}
+
+// MTLSConfig configures mutual TLS. the certificates of clients are verified by ClientCAs in the TLS handshake.
+type MTLSConfig struct {
+	// ClientCAs verify the certificates of clients, it is required.
+	ClientCAs *x509.CertPool
+	// Required rejects the clients without a certificate in the TLS handshake. otherwise they
+	// can connect, e.g. to be authenticated by token with auth.Any.
+	Required bool
+}
+
+// AuthorizationCacheConfig configures the cache of the authorizer set by Server.SetAuthorizer or
+// Server.SetPrincipalAuthorizer with auth.CachedAuthorizer. the decisions are cached per principal and topic
+// and the concurrent checks of the same topic are done once. topic templates aren't cached.
+type AuthorizationCacheConfig struct {
+	// TTL is how long allowed topics are cached, DefAuthorizationCacheTTL by default.
+	TTL time.Duration
+	// NegativeTTL is how long denied topics are cached, zero doesn't cache them.
+	NegativeTTL time.Duration
+	// Size is the maximum number of cached decisions, the least recently used ones are removed first.
+	// DefAuthorizationCacheSize by default.
+	Size int
+}
+
+// QueueConfig configures the bounded queue of events waiting to be sent to each client,
+// so a slow client doesn't stall the others. the queue is shared between the topics of
+// a client and the overflow policy of the event's topic is applied when it is full.
+type QueueConfig struct {
+	// Size is the number of events that can wait for each client.
+	Size int
+	// Overflow is the policy of topics without a policy in Topics.
+	Overflow Overflow
+	// Topics is the overflow policy per topic.
+	Topics map[string]Overflow
+}
+
+// TopicConfig configures the topics that are created at runtime, in addition to the topics of NewServer.
+type TopicConfig struct {
+	// AutoCreate are the patterns of topics that are created on the first publish or subscribe, e.g. "ride.*".
+	// subscribing to them is authorized before creating.
+	AutoCreate []string
+	// IdleTimeout is how long an auto created topic without subscribers and events is kept,
+	// its history is removed with it, also from the store. negative keeps them.
+	IdleTimeout time.Duration
+}
+
+// HistoryConfig configures the in-memory history of each topic which is replayed
+// to reconnecting clients from their last received event.
+type HistoryConfig struct {
+	// Size is the number of kept events per topic, zero disables the history.
+	Size int
+	// MaxAge is the maximum age of replayed events, zero means no limit.
+	MaxAge time.Duration
+	// Store keeps the history durable across restarts, e.g. store.NewFileStore.
+	// nil keeps the history only in memory. the store is not closed on shutdown.
+	Store store.EventStore
+	// StoreMaxSize is the maximum size of store in bytes, zero means no limit.
+	StoreMaxSize int64
+	// StoreTruncateInterval is the interval of truncating the store by MaxAge and StoreMaxSize.
+	StoreTruncateInterval time.Duration
+	// ReplayLimit is the maximum number of events replayed from Store to a reconnecting client per topic,
+	// the latest ones are replayed. DefReplayLimit by default.
+	ReplayLimit int
 }
 
 type WorkerConfig struct {
+	// Deprecated: subscribers are removed as soon as they disconnect, it has no effect.
 	CleaningInterval          time.Duration
 	ClientAcceptorCount       int64
 	ClientAcceptorQueueSize   int
 	EventDistributorCount     int64
 	EventDistributorQueueSize int
+	// PublishQueueSize is the number of published events that can wait for the distributor of each topic.
+	PublishQueueSize int
 }
 
 type MetricConfig struct {
 	Namespace string
 	Subsystem string
+	// PrincipalLabel returns the principal label of the connection_count metric, e.g. a role.
+	// principal IDs have a high cardinality, so all the principals have the same label when it is nil.
+	PrincipalLabel func(principal *auth.Principal) string
 }
 
+// PublishResult is the outcome of publishing an event, it reports the matched topics, the number
+// of subscribers of each topic that the event is queued on and the topics that failed.
+type PublishResult = internal.PublishResult
+
 type Server interface {
 	Publish(topic string, event []byte)
+	// PublishContext publishes the event and waits for the distributors of the matched
+	// topics until ctx is done. it fails with ErrNoTopicMatched when no topic matches.
+	PublishContext(ctx context.Context, topic string, event []byte) (PublishResult, error)
+	// TryPublish publishes the event without waiting, it fails with ErrQueueFull
+	// when the distributor of a matched topic is saturated. the event is still queued
+	// on the other topics, so only the Failed topics of the result may be retried.
+	TryPublish(topic string, event []byte) (PublishResult, error)
+
+	// AddTopics adds topics at runtime, they must not be empty or have wildcards.
+	AddTopics(topics ...string) error
+	// RemoveTopic removes topic with its history in memory and in the store, a topic added again with the
+	// same name starts over. its subscribers are notified by an error with CodeTopicNotAvailable and the topic
+	// in data.
+	RemoveTopic(topic string) error
 
 	SetAuthenticator(authenticator auth.Authenticator)
 	SetAuthenticatorFunc(authenticatorFunc auth.AuthenticatorFunc)
+	// SetConnAuthenticator authenticates clients by their connection info and returns their principal,
+	// which is passed to the authorizers and hooks. the reason of an *auth.Error is sent to the client.
+	SetConnAuthenticator(authenticator auth.ConnAuthenticator)
+	SetConnAuthenticatorFunc(authenticator auth.ConnAuthenticatorFunc)
 
 	SetAuthorizer(authorizer auth.Authorizer)
 	SetAuthorizerFunc(authorizer auth.AuthorizerFunc)
+	// SetPrincipalAuthorizer authorizes the principals of clients on each topic.
+	SetPrincipalAuthorizer(authorizer auth.PrincipalAuthorizer)
+	SetPrincipalAuthorizerFunc(authorizer auth.PrincipalAuthorizerFunc)
+
+	// AddTemplate authorizes the topics matching template, e.g. ride.passenger.{id}, by authorizer
+	// with the parameters of the topic instead of the authorizer. the first matching template is used.
+	// the topics matching template are auto created on the first publish or subscribe.
+	AddTemplate(template string, authorizer auth.ParamAuthorizer) error
+	AddTemplateFunc(template string, authorizer auth.ParamAuthorizerFunc) error
+
+	// RevokeToken disconnects the clients authenticated by token with CodeNotAuthorized and refuses
+	// the token until it expires, or for RevocationTTL when it has no expiry. it returns the number
+	// of disconnected clients.
+	RevokeToken(token string) int
+	// KickPrincipal disconnects the clients of the principal with id with CodeNotAuthorized,
+	// they can connect again. it returns the number of disconnected clients.
+	KickPrincipal(id string) int
 
 	MetricHandler() http.Handler
+
+	// Shutdown stops accepting new clients, flushes queued events, notifies subscribers
+	// with CodeGoingAway and waits for them to disconnect. connections still open when
+	// ctx is done are closed forcefully and ctx's error is returned.
+	Shutdown(ctx context.Context) error
 }
 
 // NewServer creates a new server and listen for connections on the given address.
 func NewServer(address string, topics []string, config *ServerConfig) (Server, error) {
 	config = processServerConfig(config)
 
-	listener, err := quic.ListenAddr(address, config.TLSConfig, nil)
+	if err := internal.ValidateVersions(config.Versions); err != nil {
+		return nil, err
+	}
+
+	logger, err := newLogger(config.Log)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, topic := range topics {
+		if err := internal.ValidateTopic(topic); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, pattern := range config.Topics.AutoCreate {
+		if err := internal.ValidatePattern(pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	tlsConfig := config.TLSConfig.Clone()
+	tlsConfig.NextProtos = internal.Protocols(config.Versions)
+
+	if config.MTLS != nil {
+		if config.MTLS.ClientCAs == nil {
+			return nil, ErrNoClientCAs
+		}
+
+		tlsConfig.ClientCAs = config.MTLS.ClientCAs
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+
+		if config.MTLS.Required {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, errors.Errorf("failed to resolve address %s: %s", address, err.Error())
+	}
+
+	conn, err := net.ListenUDP("udp", udpAddr)
 	if err != nil {
 		return nil, errors.Errorf("failed to listen at address %s: %s", address, err.Error())
 	}
 
+	transport := &quic.Transport{Conn: conn} //nolint:exhaustruct
+
+	listener, err := transport.Listen(tlsConfig, nil)
+	if err != nil {
+		_ = conn.Close()
+
+		return nil, errors.Errorf("failed to listen at address %s: %s", address, err.Error())
+	}
+
 	workerConfig := internal.WorkerConfig{
 		ClientAcceptorCount:       config.Worker.ClientAcceptorCount,
 		ClientAcceptorQueueSize:   config.Worker.ClientAcceptorQueueSize,
@@ -67,24 +290,61 @@ func NewServer(address string, topics []string, config *ServerConfig) (Server, e
 	}
 
 	metric := internal.NewMetrics(config.Metric.Namespace, config.Metric.Subsystem)
-	l := internal.NewLogger().Named("server")
+	l := logger.Named("server")
 	worker := internal.NewWorker(workerConfig, l.Named("worker"))
 	server := internal.Server{
 		Worker:        worker,
+		Transport:     transport,
 		Listener:      listener,
-		Authenticator: auth.AuthenticatorFunc(internal.DefaultAuthenticationFunc),
-		Authorizer:    auth.AuthorizerFunc(internal.DefaultAuthorizationFunc),
+		Authenticator: auth.TokenAuthenticator(auth.AuthenticatorFunc(internal.DefaultAuthenticationFunc)),
+		Authorizer:    auth.TokenAuthorizer(auth.AuthorizerFunc(internal.DefaultAuthorizationFunc)),
 		EventSources:  make(map[string]*internal.EventSource),
-		Topics:        topics,
+		Topics:        make([]string, 0, len(topics)),
 		Metrics:       metric,
-		Finder: internal.Finder{
-			Logger: l.Named("finder"),
-		},
-		Logger:           l,
-		CleaningInterval: config.Worker.CleaningInterval,
+		Finder:        internal.Finder{},
+		Logger:        l,
+		Versions:      config.Versions,
+		HistorySize:   config.History.Size,
+		HistoryMaxAge: config.History.MaxAge,
+		ReplayLimit:   config.History.ReplayLimit,
+
+		PrincipalLabel: config.Metric.PrincipalLabel,
+		OnConnect:      config.OnConnect,
+		OnDisconnect:   config.OnDisconnect,
+		ExpiryNotice:   config.ExpiryNotice,
+		RevocationTTL:  config.RevocationTTL,
+
+		AutoCreate:       config.Topics.AutoCreate,
+		TopicIdleTimeout: config.Topics.IdleTimeout,
+
+		PublishQueueSize: config.Worker.PublishQueueSize,
+
+		QueueSize:     config.Queue.Size,
+		Overflow:      config.Queue.Overflow,
+		TopicOverflow: config.Queue.Topics,
+
+		Store:                 config.History.Store,
+		StoreMaxSize:          config.History.StoreMaxSize,
+		StoreTruncateInterval: config.History.StoreTruncateInterval,
+	}
+
+	if config.AuthorizationCache != nil {
+		server.AuthCache = &auth.CacheConfig{
+			TTL:         config.AuthorizationCache.TTL,
+			NegativeTTL: config.AuthorizationCache.NegativeTTL,
+			Size:        config.AuthorizationCache.Size,
+			OnLookup:    metric.IncAuthCache,
+		}
+	}
+
+	if err := server.GenerateEventSources(topics); err != nil {
+		_ = server.Shutdown(context.Background())
+
+		return nil, err
 	}
 
-	server.GenerateEventSources(topics)
+	server.StartStoreTruncation()
+	server.StartTopicCollection()
 
 	worker.AddAcceptClientWork(&server, int(config.Worker.ClientAcceptorCount))
 
@@ -105,10 +365,25 @@ func processServerConfig(cfg *ServerConfig) *ServerConfig {
 				ClientAcceptorQueueSize:   DefClientAcceptorQueueSize,
 				EventDistributorCount:     DefEventDistributorCount,
 				EventDistributorQueueSize: DefEVentDistributorQueueSize,
+				PublishQueueSize:          DefPublishQueueSize,
 			},
+			History: &HistoryConfig{ //nolint:exhaustruct
+				Size:                  DefHistorySize,
+				MaxAge:                DefHistoryMaxAge,
+				StoreTruncateInterval: DefStoreTruncateInterval,
+			},
+			Queue:         defaultQueueConfig(),
+			Topics:        &TopicConfig{AutoCreate: nil, IdleTimeout: DefTopicIdleTimeout},
+			Versions:      internal.SupportedVersions,
+			ExpiryNotice:  DefExpiryNotice,
+			RevocationTTL: DefRevocationTTL,
 		}
 	}
 
+	if cfg.Versions == nil {
+		cfg.Versions = internal.SupportedVersions
+	}
+
 	if cfg.Metric == nil {
 		cfg.Metric = &MetricConfig{
 			Namespace: "qsse",
@@ -127,8 +402,96 @@ func processServerConfig(cfg *ServerConfig) *ServerConfig {
 			ClientAcceptorQueueSize:   DefClientAcceptorQueueSize,
 			EventDistributorCount:     DefEventDistributorCount,
 			EventDistributorQueueSize: DefEVentDistributorQueueSize,
+			PublishQueueSize:          DefPublishQueueSize,
+		}
+	}
+
+	if cfg.Worker.PublishQueueSize <= 0 {
+		cfg.Worker.PublishQueueSize = DefPublishQueueSize
+	}
+
+	if cfg.History == nil {
+		cfg.History = &HistoryConfig{ //nolint:exhaustruct
+			Size:   DefHistorySize,
+			MaxAge: DefHistoryMaxAge,
 		}
 	}
 
+	if cfg.Queue == nil {
+		cfg.Queue = defaultQueueConfig()
+	}
+
+	cfg.Queue = processQueueConfig(*cfg.Queue)
+
+	if cfg.Topics == nil {
+		cfg.Topics = &TopicConfig{AutoCreate: nil, IdleTimeout: DefTopicIdleTimeout}
+	}
+
+	if cfg.Topics.IdleTimeout == 0 {
+		cfg.Topics.IdleTimeout = DefTopicIdleTimeout
+	}
+
+	if cfg.AuthorizationCache != nil {
+		cache := *cfg.AuthorizationCache
+
+		if cache.TTL == 0 {
+			cache.TTL = DefAuthorizationCacheTTL
+		}
+
+		if cache.Size == 0 {
+			cache.Size = DefAuthorizationCacheSize
+		}
+
+		cfg.AuthorizationCache = &cache
+	}
+
+	if cfg.ExpiryNotice == 0 {
+		cfg.ExpiryNotice = DefExpiryNotice
+	}
+
+	if cfg.RevocationTTL == 0 {
+		cfg.RevocationTTL = DefRevocationTTL
+	}
+
+	if cfg.History.StoreTruncateInterval == 0 {
+		cfg.History.StoreTruncateInterval = DefStoreTruncateInterval
+	}
+
 	return cfg
 }
+
+// processQueueConfig sets the defaults of queue, a zero Overflow is the Overflow of defaultQueueConfig.
+func processQueueConfig(queue QueueConfig) *QueueConfig {
+	if queue.Size <= 0 {
+		queue.Size = DefQueueSize
+	}
+
+	queue.Overflow = processOverflow(queue.Overflow)
+
+	if queue.Topics != nil {
+		topics := make(map[string]Overflow, len(queue.Topics))
+		for topic, overflow := range queue.Topics {
+			topics[topic] = processOverflow(overflow)
+		}
+
+		queue.Topics = topics
+	}
+
+	return &queue
+}
+
+func processOverflow(overflow Overflow) Overflow {
+	if overflow.Policy == OverflowBlock && overflow.Timeout == 0 {
+		overflow.Timeout = DefBlockTimeout
+	}
+
+	return overflow
+}
+
+func defaultQueueConfig() *QueueConfig {
+	return &QueueConfig{
+		Size:     DefQueueSize,
+		Overflow: Overflow{Policy: OverflowDropOldest, Timeout: 0},
+		Topics:   nil,
+	}
+}
@@ -0,0 +1,807 @@
+package qsse_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	quic "github.com/quic-go/quic-go"
+	"github.com/snapp-incubator/qsse"
+	"github.com/snapp-incubator/qsse/auth"
+	"github.com/snapp-incubator/qsse/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func newTestServer(t *testing.T, address, namespace string, topics []string) qsse.Server {
+	t.Helper()
+
+	server, err := qsse.NewServer(address, topics, &qsse.ServerConfig{ //nolint:exhaustruct
+		Metric: &qsse.MetricConfig{Namespace: namespace, Subsystem: "test"},
+	})
+	require.NoError(t, err)
+
+	return server
+}
+
+// authFailureReasons returns the reason labels of the failed authentications of the server of namespace.
+func authFailureReasons(t *testing.T, namespace string) []string {
+	t.Helper()
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+
+	var reasons []string
+
+	for _, family := range families {
+		if family.GetName() != namespace+"_test_authentication_failure_count" {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "reason" {
+					reasons = append(reasons, label.GetValue())
+				}
+			}
+		}
+	}
+
+	return reasons
+}
+
+// publishUntil keeps publishing on topic until done is closed. clients of
+// version 1 receive their stream on the first event, so it is needed for connecting.
+func publishUntil(server qsse.Server, topic string, done <-chan struct{}) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			server.Publish(topic, []byte("ping"))
+		}
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	address := "localhost:14242"
+
+	server := newTestServer(t, address, "shutdown", []string{"topic"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	require.NoError(t, server.Shutdown(ctx))
+	assert.ErrorIs(t, server.Shutdown(ctx), qsse.ErrServerClosed)
+
+	// publishing after shutdown must not block.
+	server.Publish("topic", []byte("data"))
+
+	// the address is released after shutdown.
+	server = newTestServer(t, address, "shutdown_again", []string{"topic"})
+	require.NoError(t, server.Shutdown(ctx))
+}
+
+// TestServerShutdownSlowClient checks that a client which doesn't read its events doesn't hang Shutdown past ctx.
+func TestServerShutdownSlowClient(t *testing.T) {
+	address := "localhost:14265"
+
+	server, err := qsse.NewServer(address, []string{"topic"}, &qsse.ServerConfig{ //nolint:exhaustruct
+		Metric: &qsse.MetricConfig{Namespace: "shutdown_slow", Subsystem: "test"},
+		Queue:  &qsse.QueueConfig{Size: 1, Overflow: qsse.Overflow{Policy: qsse.OverflowDropNewest}, Topics: nil},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tlsConfig := qsse.GetSimpleTLS()
+	tlsConfig.NextProtos = []string{"PROTOCOL_QUIC"}
+
+	connection, err := quic.DialAddr(ctx, address, tlsConfig, nil)
+	require.NoError(t, err)
+
+	defer func() { _ = connection.CloseWithError(0, "") }()
+
+	stream, err := connection.OpenUniStream()
+	require.NoError(t, err)
+
+	_, err = stream.Write([]byte(`{"token":"","topics":["topic"]}` + "\n"))
+	require.NoError(t, err)
+	require.NoError(t, stream.Close())
+
+	done := make(chan struct{})
+	go publishUntil(server, "topic", done)
+
+	receiveStream, err := connection.AcceptUniStream(ctx)
+	require.NoError(t, err)
+
+	// the client is subscribed after its first event and then it stops reading.
+	_, err = bufio.NewReader(receiveStream).ReadBytes('\n')
+	require.NoError(t, err)
+	close(done)
+
+	payload := bytes.Repeat([]byte("x"), 64*1024)
+	for range 100 {
+		server.Publish("topic", payload)
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Second)
+	defer shutdownCancel()
+
+	start := time.Now()
+
+	require.ErrorIs(t, server.Shutdown(shutdownCtx), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 3*time.Second)
+}
+
+func TestServerConcurrentTopicsFraming(t *testing.T) {
+	address := "localhost:14246"
+	topics := []string{"first", "second"}
+	count := 50
+
+	server, err := qsse.NewServer(address, topics, &qsse.ServerConfig{ //nolint:exhaustruct
+		Metric: &qsse.MetricConfig{Namespace: "framing", Subsystem: "test"},
+		Worker: &qsse.WorkerConfig{
+			CleaningInterval:          time.Second,
+			ClientAcceptorCount:       1,
+			ClientAcceptorQueueSize:   1,
+			EventDistributorCount:     4,
+			EventDistributorQueueSize: 10,
+		},
+		// every event must arrive to check its framing.
+		Queue: &qsse.QueueConfig{Size: 0, Overflow: qsse.Overflow{Policy: qsse.OverflowBlock, Timeout: -1}, Topics: nil},
+	})
+	require.NoError(t, err)
+
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	done := make(chan struct{})
+	go publishUntil(server, "first", done)
+
+	client, err := qsse.NewClient(address, topics, nil)
+	require.NoError(t, err)
+
+	defer func() { _ = client.Close() }()
+
+	close(done)
+
+	payload := bytes.Repeat([]byte("x"), 32*1024)
+	received := make(chan string, 2*count)
+
+	for _, topic := range topics {
+		client.SetEventHandler(topic, func(data []byte) {
+			if bytes.Equal(data, payload) {
+				received <- topic
+			}
+		})
+	}
+
+	for _, topic := range topics {
+		go func() {
+			for range count {
+				server.Publish(topic, payload)
+			}
+		}()
+	}
+
+	counts := make(map[string]int)
+
+	for range 2 * count {
+		select {
+		case topic := <-received:
+			counts[topic]++
+		case <-time.After(10 * time.Second):
+			t.Fatalf("events are lost or corrupted: %v", counts)
+		}
+	}
+
+	assert.Equal(t, map[string]int{"first": count, "second": count}, counts)
+}
+
+// TestServerLegacyJSONClient checks that a client which doesn't offer a frame version still receives JSON events.
+func TestServerLegacyJSONClient(t *testing.T) {
+	address := "localhost:14247"
+
+	server := newTestServer(t, address, "legacy", []string{"topic"})
+
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// clients before versioning only offer the legacy ALPN token.
+	tlsConfig := qsse.GetSimpleTLS()
+	tlsConfig.NextProtos = []string{"PROTOCOL_QUIC"}
+
+	connection, err := quic.DialAddr(ctx, address, tlsConfig, nil)
+	require.NoError(t, err)
+
+	defer func() { _ = connection.CloseWithError(0, "") }()
+
+	stream, err := connection.OpenUniStream()
+	require.NoError(t, err)
+
+	_, err = stream.Write([]byte(`{"token":"","topics":["topic"]}` + "\n"))
+	require.NoError(t, err)
+	require.NoError(t, stream.Close())
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go publishUntil(server, "topic", done)
+
+	receiveStream, err := connection.AcceptUniStream(ctx)
+	require.NoError(t, err)
+
+	line, err := bufio.NewReader(receiveStream).ReadBytes('\n')
+	require.NoError(t, err)
+
+	var event struct {
+		ID    uint64 `json:"id"`
+		Topic string `json:"topic"`
+		Data  []byte `json:"data"`
+	}
+
+	require.NoError(t, json.Unmarshal(line, &event))
+	assert.Equal(t, "topic", event.Topic)
+	assert.Equal(t, []byte("ping"), event.Data)
+	assert.Positive(t, event.ID)
+}
+
+func TestServerVersions(t *testing.T) {
+	_, err := qsse.NewServer("localhost:14250", []string{"topic"}, &qsse.ServerConfig{ //nolint:exhaustruct
+		Versions: []int{qsse.Version2, 3},
+	})
+	require.ErrorIs(t, err, qsse.ErrUnsupportedVersion)
+}
+
+func TestServerInvalidTopics(t *testing.T) {
+	_, err := qsse.NewServer("localhost:14257", []string{"ride.*"}, nil)
+	require.ErrorIs(t, err, qsse.ErrInvalidTopic)
+
+	_, err = qsse.NewServer("localhost:14257", nil, &qsse.ServerConfig{ //nolint:exhaustruct
+		Topics: &qsse.TopicConfig{AutoCreate: []string{"ride.>.start"}, IdleTimeout: 0},
+	})
+	require.ErrorIs(t, err, qsse.ErrInvalidTopic)
+}
+
+// TestServerOfferVersionMismatch checks that the server rejects an offer whose version is not negotiated by ALPN.
+func TestServerOfferVersionMismatch(t *testing.T) {
+	address := "localhost:14250"
+
+	server := newTestServer(t, address, "mismatch", []string{"topic"})
+
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tlsConfig := qsse.GetSimpleTLS()
+	tlsConfig.NextProtos = []string{"qsse/1"}
+
+	connection, err := quic.DialAddr(ctx, address, tlsConfig, nil)
+	require.NoError(t, err)
+
+	stream, err := connection.OpenUniStream()
+	require.NoError(t, err)
+
+	_, err = stream.Write([]byte(`{"topics":["topic"],"version":2}` + "\n"))
+	require.NoError(t, err)
+	require.NoError(t, stream.Close())
+
+	<-connection.Context().Done()
+
+	var appErr *quic.ApplicationError
+	require.ErrorAs(t, context.Cause(connection.Context()), &appErr)
+	assert.Equal(t, quic.ApplicationErrorCode(qsse.CodeUnsupportedVersion), appErr.ErrorCode)
+}
+
+func TestServerAddRemoveTopics(t *testing.T) {
+	address := "localhost:14254"
+
+	server := newTestServer(t, address, "dynamic_topics", []string{"people"})
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	require.ErrorIs(t, server.AddTopics("cars", "cars.*"), qsse.ErrInvalidTopic)
+	require.NoError(t, server.AddTopics("cars"))
+
+	removed := make(chan string, 1)
+
+	client, err := qsse.NewClient(address, []string{"people", "cars"}, nil)
+	require.NoError(t, err)
+
+	defer func() { _ = client.Close() }()
+
+	client.SetErrorHandler(func(code int, data map[string]any) {
+		if code == qsse.CodeTopicNotAvailable {
+			removed <- data["topic"].(string) //nolint:forcetypeassert
+		}
+	})
+
+	assert.Equal(t, []string{"people", "cars"}, client.Session().Accepted)
+
+	require.NoError(t, server.RemoveTopic("cars"))
+	require.ErrorIs(t, server.RemoveTopic("cars"), qsse.ErrTopicNotAvailable)
+
+	select {
+	case topic := <-removed:
+		assert.Equal(t, "cars", topic)
+	case <-time.After(5 * time.Second):
+		t.Fatal("client is not notified of the removed topic")
+	}
+
+	assert.Equal(t, []string{"people"}, client.Session().Accepted)
+
+	_, err = server.TryPublish("cars", []byte("data"))
+	require.ErrorIs(t, err, qsse.ErrNoTopicMatched)
+}
+
+func TestServerRemoveTopicFromStore(t *testing.T) {
+	address := "localhost:14267"
+
+	eventStore, err := store.NewFileStore(t.TempDir(), nil)
+	require.NoError(t, err)
+
+	defer func() { _ = eventStore.Close() }()
+
+	server, err := qsse.NewServer(address, []string{"cars"}, &qsse.ServerConfig{ //nolint:exhaustruct
+		Metric:  &qsse.MetricConfig{Namespace: "remove_store", Subsystem: "test"}, //nolint:exhaustruct
+		History: &qsse.HistoryConfig{Store: eventStore},                           //nolint:exhaustruct
+	})
+	require.NoError(t, err)
+
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	lastID := func() uint64 {
+		id, err := eventStore.LastID("cars")
+		require.NoError(t, err)
+
+		return id
+	}
+
+	_, err = server.TryPublish("cars", []byte("data"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return lastID() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	// the topic added again starts over without the history of the removed one.
+	require.NoError(t, server.RemoveTopic("cars"))
+	assert.Zero(t, lastID())
+
+	require.NoError(t, server.AddTopics("cars"))
+
+	_, err = server.TryPublish("cars", []byte("data"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return lastID() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	events, err := eventStore.ReadFrom("cars", 0, time.Time{}, 0)
+	require.NoError(t, err)
+	assert.Len(t, events, 1)
+}
+
+func TestServerAutoCreateTopics(t *testing.T) {
+	address := "localhost:14255"
+
+	server, err := qsse.NewServer(address, []string{"people"}, &qsse.ServerConfig{ //nolint:exhaustruct
+		Metric: &qsse.MetricConfig{Namespace: "auto_create", Subsystem: "test"},
+		Topics: &qsse.TopicConfig{AutoCreate: []string{"ride.*"}, IdleTimeout: 100 * time.Millisecond},
+	})
+	require.NoError(t, err)
+
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	result, err := server.TryPublish("ride.1", []byte("data"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ride.1"}, result.Topics)
+
+	_, err = server.TryPublish("car.1", []byte("data"))
+	require.ErrorIs(t, err, qsse.ErrNoTopicMatched)
+
+	client, err := qsse.NewClient(address, []string{"ride.2", "car.1"}, nil)
+	require.NoError(t, err)
+
+	defer func() { _ = client.Close() }()
+
+	assert.Equal(t, []string{"ride.2"}, client.Session().Accepted)
+
+	// ride.1 is idle and removed, but ride.2 is kept while it has a subscriber.
+	require.Eventually(t, func() bool {
+		result, err := server.TryPublish("ride.*", []byte("data"))
+
+		return err == nil && slices.Equal([]string{"ride.2"}, result.Topics)
+	}, 5*time.Second, 50*time.Millisecond)
+
+	_, err = client.Unsubscribe(context.Background(), "ride.2")
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		_, err := server.TryPublish("ride.*", []byte("data"))
+
+		return errors.Is(err, qsse.ErrNoTopicMatched)
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestServerTemplateAuthorization(t *testing.T) {
+	address := "localhost:14258"
+
+	// ride.passenger.42 is created by its template.
+	server := newTestServer(t, address, "template", []string{"ride.passenger.43", "people"})
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	// the token is the ID of the principal.
+	server.SetConnAuthenticatorFunc(func(_ context.Context, info *auth.ConnInfo) (*auth.Principal, error) {
+		return &auth.Principal{ID: info.Token, Roles: nil, Claims: nil, ExpiresAt: time.Time{}, Token: info.Token}, nil
+	})
+
+	require.ErrorIs(t, server.AddTemplateFunc("ride.passenger.{", nil), auth.ErrInvalidTemplate)
+	require.NoError(t, server.AddTemplateFunc("ride.passenger.{id}", func(principal *auth.Principal, params auth.Params) bool {
+		return params["id"] == principal.ID
+	}))
+
+	client, err := qsse.NewClient(address, []string{"ride.passenger.42", "ride.passenger.43", "people"}, &qsse.ClientConfig{ //nolint:exhaustruct
+		Token: "42",
+	})
+	require.NoError(t, err)
+
+	defer func() { _ = client.Close() }()
+
+	assert.Equal(t, []string{"ride.passenger.42", "people"}, client.Session().Accepted)
+	assert.Equal(t,
+		[]qsse.RejectedTopic{{Topic: "ride.passenger.43", Code: qsse.CodeNotAuthorized}},
+		client.Session().Rejected,
+	)
+
+	ack, err := client.Subscribe(context.Background(), "ride.passenger.*")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ride.passenger.*"}, ack.Accepted)
+}
+
+func TestServerConnAuthenticator(t *testing.T) {
+	address := "localhost:14259"
+
+	connected := make(chan *auth.Principal, 1)
+	disconnected := make(chan *auth.Principal, 1)
+
+	server, err := qsse.NewServer(address, []string{"people", "cars"}, &qsse.ServerConfig{ //nolint:exhaustruct
+		Metric: &qsse.MetricConfig{ //nolint:exhaustruct
+			Namespace: "conn_authenticator",
+			Subsystem: "test",
+		},
+		OnConnect:    func(principal *auth.Principal) { connected <- principal },
+		OnDisconnect: func(principal *auth.Principal) { disconnected <- principal },
+	})
+	require.NoError(t, err)
+
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	server.SetConnAuthenticatorFunc(func(_ context.Context, info *auth.ConnInfo) (*auth.Principal, error) {
+		if info.Token != "secret" {
+			return nil, auth.NewError("invalid token", errors.New("token is not secret"))
+		}
+
+		assert.NotNil(t, info.RemoteAddr)
+		assert.NotEmpty(t, info.Protocol)
+
+		return &auth.Principal{ //nolint:exhaustruct
+			ID:    info.Metadata["user"],
+			Roles: []string{"passenger"},
+			Token: info.Token,
+		}, nil
+	})
+	server.SetPrincipalAuthorizerFunc(func(principal *auth.Principal, topic string) bool {
+		return topic != "cars" || principal.HasRole("driver")
+	})
+
+	_, err = qsse.NewClient(address, []string{"people"}, &qsse.ClientConfig{ //nolint:exhaustruct
+		Token: "wrong",
+	})
+	require.ErrorIs(t, err, qsse.ErrNotAuthorized)
+
+	var handshakeErr *qsse.HandshakeError
+	require.ErrorAs(t, err, &handshakeErr)
+	assert.Contains(t, handshakeErr.Message, "invalid token")
+	assert.NotContains(t, handshakeErr.Message, "not secret")
+
+	client, err := qsse.NewClient(address, []string{"people", "cars"}, &qsse.ClientConfig{ //nolint:exhaustruct
+		Token:    "secret",
+		Metadata: map[string]string{"user": "42"},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"people"}, client.Session().Accepted)
+	assert.Equal(t, "42", (<-connected).ID)
+
+	require.NoError(t, client.Close())
+
+	select {
+	case principal := <-disconnected:
+		assert.Equal(t, "42", principal.ID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("disconnection of the client is not reported")
+	}
+}
+
+// newCertificate returns the certificate of template signed by the parent certificate and key,
+// the certificate is self-signed when parent is nil.
+func newCertificate(t *testing.T, template, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, tls.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return cert, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert} //nolint:exhaustruct
+}
+
+func TestServerMTLS(t *testing.T) {
+	address := "localhost:14260"
+
+	ca, caCert := newCertificate(t, &x509.Certificate{ //nolint:exhaustruct
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "qsse test ca"}, //nolint:exhaustruct
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+
+	_, clientCert := newCertificate(t, &x509.Certificate{ //nolint:exhaustruct
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "billing", OrganizationalUnit: []string{"backend"}}, //nolint:exhaustruct
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caCert.PrivateKey.(crypto.Signer)) //nolint:forcetypeassert
+
+	_, serverCert := newCertificate(t, &x509.Certificate{ //nolint:exhaustruct
+		SerialNumber: big.NewInt(4),
+		Subject:      pkix.Name{CommonName: "localhost"}, //nolint:exhaustruct
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caCert.PrivateKey.(crypto.Signer)) //nolint:forcetypeassert
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	// client certificates are not verified by the system roots.
+	_, err := qsse.NewServer(address, []string{"people"}, &qsse.ServerConfig{ //nolint:exhaustruct
+		MTLS: &qsse.MTLSConfig{ClientCAs: nil, Required: false},
+	})
+	require.ErrorIs(t, err, qsse.ErrNoClientCAs)
+
+	server, err := qsse.NewServer(address, []string{"payments", "people"}, &qsse.ServerConfig{ //nolint:exhaustruct
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{serverCert}}, //nolint:exhaustruct,gosec
+		Metric:    &qsse.MetricConfig{Namespace: "mtls", Subsystem: "test"}, //nolint:exhaustruct
+		MTLS:      &qsse.MTLSConfig{ClientCAs: pool, Required: false},
+	})
+	require.NoError(t, err)
+
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	// backend clients have certificates and the others have tokens.
+	server.SetConnAuthenticator(auth.Any(
+		auth.CertificateAuthenticator(auth.CommonName),
+		auth.TokenAuthenticator(auth.AuthenticatorFunc(func(token string) bool { return token == "secret" })),
+	))
+	server.SetPrincipalAuthorizerFunc(func(principal *auth.Principal, topic string) bool {
+		return topic != "payments" || principal.HasRole("backend")
+	})
+
+	// the server is verified with mutual TLS, the system roots don't trust it.
+	_, err = qsse.NewClient(address, []string{"people"}, &qsse.ClientConfig{ //nolint:exhaustruct
+		MTLS: &qsse.ClientMTLSConfig{Certificate: clientCert, RootCAs: nil},
+	})
+	require.Error(t, err)
+
+	client, err := qsse.NewClient(address, []string{"payments", "people"}, &qsse.ClientConfig{ //nolint:exhaustruct
+		MTLS: &qsse.ClientMTLSConfig{Certificate: clientCert, RootCAs: pool},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"payments", "people"}, client.Session().Accepted)
+	require.NoError(t, client.Close())
+
+	client, err = qsse.NewClient(address, []string{"payments", "people"}, &qsse.ClientConfig{ //nolint:exhaustruct
+		Token: "secret",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"people"}, client.Session().Accepted)
+	require.NoError(t, client.Close())
+
+	_, err = qsse.NewClient(address, []string{"people"}, nil)
+	require.ErrorIs(t, err, qsse.ErrNotAuthorized)
+
+	// certificates of other CAs are not accepted.
+	_, otherCert := newCertificate(t, &x509.Certificate{ //nolint:exhaustruct
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "billing"}, //nolint:exhaustruct
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}, nil, nil)
+
+	_, err = qsse.NewClient(address, []string{"people"}, &qsse.ClientConfig{ //nolint:exhaustruct
+		MTLS: &qsse.ClientMTLSConfig{Certificate: otherCert, RootCAs: pool},
+	})
+	require.Error(t, err)
+}
+
+func TestServerRevokeToken(t *testing.T) {
+	address := "localhost:14262"
+
+	server, err := qsse.NewServer(address, []string{"topic"}, &qsse.ServerConfig{ //nolint:exhaustruct
+		Metric: &qsse.MetricConfig{ //nolint:exhaustruct
+			Namespace: "revoke_token",
+			Subsystem: "test",
+		},
+		RevocationTTL: 500 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	server.SetConnAuthenticatorFunc(func(_ context.Context, info *auth.ConnInfo) (*auth.Principal, error) {
+		return &auth.Principal{ //nolint:exhaustruct
+			ID:        info.Metadata["user"],
+			ExpiresAt: time.Now().Add(time.Minute),
+			Token:     info.Token,
+		}, nil
+	})
+
+	connect := func(token, user string) chan error {
+		disconnected := make(chan error, 1)
+
+		_, err := qsse.NewClient(address, []string{"topic"}, &qsse.ClientConfig{ //nolint:exhaustruct
+			Token:        token,
+			Metadata:     map[string]string{"user": user},
+			OnDisconnect: func(err error) { disconnected <- err },
+		})
+		require.NoError(t, err)
+
+		return disconnected
+	}
+
+	assertDisconnected := func(disconnected chan error) {
+		t.Helper()
+
+		select {
+		case err := <-disconnected:
+			var connectionErr *qsse.ConnectionError
+
+			require.ErrorAs(t, err, &connectionErr)
+			assert.Equal(t, qsse.CodeNotAuthorized, connectionErr.Code)
+		case <-time.After(5 * time.Second):
+			t.Fatal("client is not disconnected")
+		}
+	}
+
+	revoked := connect("a", "1")
+	kicked := connect("b", "1")
+	other := connect("c", "2")
+
+	assert.Equal(t, 0, server.RevokeToken(""))
+	assert.Equal(t, 1, server.RevokeToken("a"))
+	assertDisconnected(revoked)
+
+	_, err = qsse.NewClient(address, []string{"topic"}, &qsse.ClientConfig{ //nolint:exhaustruct
+		Token:    "a",
+		Metadata: map[string]string{"user": "1"},
+	})
+	require.ErrorIs(t, err, qsse.ErrNotAuthorized)
+
+	var handshakeErr *qsse.HandshakeError
+	require.ErrorAs(t, err, &handshakeErr)
+	assert.Contains(t, handshakeErr.Message, "revoked")
+
+	assert.Equal(t, 1, server.KickPrincipal("1"))
+	assertDisconnected(kicked)
+	assert.Equal(t, 0, server.KickPrincipal("unknown"))
+
+	// the kicked principal can connect again.
+	connect("b", "1")
+
+	// tokens without connection are refused for RevocationTTL.
+	assert.Equal(t, 0, server.RevokeToken("d"))
+
+	_, err = qsse.NewClient(address, []string{"topic"}, &qsse.ClientConfig{ //nolint:exhaustruct
+		Token:    "d",
+		Metadata: map[string]string{"user": "3"},
+	})
+	require.ErrorIs(t, err, qsse.ErrNotAuthorized)
+
+	require.Eventually(t, func() bool {
+		client, err := qsse.NewClient(address, []string{"topic"}, &qsse.ClientConfig{ //nolint:exhaustruct
+			Token:    "d",
+			Metadata: map[string]string{"user": "3"},
+		})
+		if err != nil {
+			return false
+		}
+
+		_ = client.Close()
+
+		return true
+	}, 5*time.Second, 100*time.Millisecond)
+
+	select {
+	case err := <-other:
+		t.Fatalf("client of another principal is disconnected: %v", err)
+	default:
+	}
+
+	// the revoked tokens are counted by a fixed reason.
+	assert.Equal(t, []string{"forbidden"}, authFailureReasons(t, "revoke_token"))
+}
+
+func TestServerLogger(t *testing.T) {
+	t.Parallel()
+
+	_, err := qsse.NewServer("localhost:14263", []string{"topic"}, &qsse.ServerConfig{ //nolint:exhaustruct
+		Log: &qsse.LogConfig{Level: "verbose"}, //nolint:exhaustruct
+	})
+	require.Error(t, err)
+
+	address := "localhost:14263"
+	core, logs := observer.New(zapcore.InfoLevel)
+
+	server, err := qsse.NewServer(address, []string{"topic"}, &qsse.ServerConfig{ //nolint:exhaustruct
+		Log: &qsse.LogConfig{Logger: zap.New(core)}, //nolint:exhaustruct
+		Metric: &qsse.MetricConfig{ //nolint:exhaustruct
+			Namespace: "logger",
+			Subsystem: "test",
+		},
+	})
+	require.NoError(t, err)
+
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	server.SetConnAuthenticatorFunc(func(_ context.Context, info *auth.ConnInfo) (*auth.Principal, error) {
+		return &auth.Principal{ID: info.Token}, nil //nolint:exhaustruct
+	})
+
+	_, err = qsse.NewClient(address, []string{"topic"}, &qsse.ClientConfig{Token: "42"}) //nolint:exhaustruct
+	require.NoError(t, err)
+
+	authenticated := logs.FilterMessage("client is authenticated").All()
+	require.Len(t, authenticated, 1)
+
+	fields := authenticated[0].ContextMap()
+	assert.Equal(t, "server", authenticated[0].LoggerName)
+	assert.NotEmpty(t, fields["connection"])
+	assert.NotEmpty(t, fields["remote_addr"])
+	assert.Equal(t, "42", fields["principal"])
+}
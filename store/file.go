@@ -0,0 +1,728 @@
+package store
+
+import (
+	"cmp"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefSegmentSize = 64 << 20
+
+	segmentExt  = ".seg"
+	tempExt     = ".tmp"
+	lastIDsFile = "last_ids.json"
+
+	headerSize     = 8  // payload length and crc
+	payloadMinSize = 18 // id, time and topic length
+
+	filePermission = 0o600
+	dirPermission  = 0o750
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli) //nolint:gochecknoglobals
+
+// FileStoreOptions configures the FileStore.
+type FileStoreOptions struct {
+	// SegmentSize is the size in bytes at which the active segment is sealed and a new one is started.
+	SegmentSize int64
+	// Sync flushes each append to disk before returning.
+	Sync bool
+}
+
+// FileStore is an append-only EventStore on a directory of segment files. each record
+// is checked with a CRC, torn records at the end of the last segment are dropped on open
+// and Truncate deletes expired segments and compacts the partially expired ones. the last
+// IDs of topics are kept in a file too, so they don't restart when all the events of a topic
+// are truncated.
+type FileStore struct {
+	dir     string
+	options FileStoreOptions
+
+	lock     sync.Mutex
+	segments []*segment
+	index    map[string][]entry
+	lastIDs  map[string]uint64
+	closed   bool
+}
+
+type segment struct {
+	seq  uint64
+	file *os.File
+	size int64
+}
+
+// entry locates an event in the segments.
+type entry struct {
+	id     uint64
+	time   time.Time
+	seq    uint64
+	offset int64
+	length int64
+}
+
+// NewFileStore opens the store in dir and creates it if it doesn't exist.
+func NewFileStore(dir string, options *FileStoreOptions) (*FileStore, error) {
+	if options == nil {
+		options = &FileStoreOptions{SegmentSize: DefSegmentSize, Sync: false}
+	}
+
+	if options.SegmentSize <= 0 {
+		options.SegmentSize = DefSegmentSize
+	}
+
+	if err := os.MkdirAll(dir, dirPermission); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %w", err)
+	}
+
+	s := &FileStore{
+		dir:      dir,
+		options:  *options,
+		lock:     sync.Mutex{},
+		segments: make([]*segment, 0),
+		index:    make(map[string][]entry),
+		lastIDs:  make(map[string]uint64),
+		closed:   false,
+	}
+
+	if err := s.load(); err != nil {
+		_ = s.Close()
+
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Append writes the event at the end of the active segment. topics longer
+// than 65535 bytes are rejected with ErrTopicTooLong.
+func (s *FileStore) Append(event Event) error {
+	if len(event.Topic) > math.MaxUint16 {
+		return fmt.Errorf("%w: %d bytes", ErrTopicTooLong, len(event.Topic))
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	record := encodeRecord(event)
+
+	active := s.segments[len(s.segments)-1]
+	if active.size > 0 && active.size+int64(len(record)) > s.options.SegmentSize {
+		next, err := s.createSegment(active.seq + 1)
+		if err != nil {
+			return err
+		}
+
+		active = next
+	}
+
+	if _, err := active.file.WriteAt(record, active.size); err != nil {
+		return fmt.Errorf("failed to append event: %w", err)
+	}
+
+	if s.options.Sync {
+		if err := active.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync segment: %w", err)
+		}
+	}
+
+	s.index[event.Topic] = append(s.index[event.Topic], entry{
+		id:     event.ID,
+		time:   event.Time,
+		seq:    active.seq,
+		offset: active.size,
+		length: int64(len(record)),
+	})
+	s.lastIDs[event.Topic] = max(s.lastIDs[event.Topic], event.ID)
+	active.size += int64(len(record))
+
+	return nil
+}
+
+// ReadFrom returns the latest limit events of topic with an ID greater than id which are not older than since.
+func (s *FileStore) ReadFrom(topic string, id uint64, since time.Time, limit int) ([]Event, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return nil, ErrStoreClosed
+	}
+
+	entries := s.index[topic]
+	start := sort.Search(len(entries), func(i int) bool { return entries[i].id > id })
+
+	// the expired events are at the start until they are truncated.
+	for start < len(entries) && entries[start].time.Before(since) {
+		start++
+	}
+
+	if limit > 0 && len(entries)-start > limit {
+		start = len(entries) - limit
+	}
+
+	events := make([]Event, 0, len(entries)-start)
+
+	for _, e := range entries[start:] {
+		seg := s.segment(e.seq)
+		if seg == nil {
+			continue
+		}
+
+		buf := make([]byte, e.length)
+		if _, err := seg.file.ReadAt(buf, e.offset); err != nil {
+			return nil, fmt.Errorf("failed to read event: %w", err)
+		}
+
+		event, _, err := decodeRecord(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// LastID returns the ID of the latest event of topic.
+func (s *FileStore) LastID(topic string) (uint64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return 0, ErrStoreClosed
+	}
+
+	return s.lastIDs[topic], nil
+}
+
+// Delete removes the events of topic by rewriting the segments which have them.
+func (s *FileStore) Delete(topic string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	touched := make(map[uint64]bool)
+	for _, e := range s.index[topic] {
+		touched[e.seq] = true
+	}
+
+	_, known := s.lastIDs[topic]
+
+	delete(s.index, topic)
+	delete(s.lastIDs, topic)
+
+	// the last ID is forgotten before the events, so the topic isn't continued after reopening.
+	if known {
+		if err := s.saveLastIDs(); err != nil {
+			return err
+		}
+	}
+
+	segments := s.segmentEntries()
+	moved := make(map[uint64]map[int64]int64)
+
+	for _, seg := range s.segments {
+		if !touched[seg.seq] {
+			continue
+		}
+
+		// the entries of the other topics are kept, the ones of topic are not indexed anymore.
+		offsets, err := s.compact(seg, segments[seg.seq], time.Time{})
+		if err != nil {
+			s.reindex(moved)
+
+			return err
+		}
+
+		moved[seg.seq] = offsets
+	}
+
+	s.reindex(moved)
+
+	return nil
+}
+
+// Truncate deletes the sealed segments which are expired or exceed maxSize and compacts
+// the sealed segment that is partially expired. the active segment is never touched.
+func (s *FileStore) Truncate(maxAge time.Duration, maxSize int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	// the last IDs are saved before the events are truncated, so they don't restart after reopening.
+	if len(s.segments) > 1 {
+		if err := s.saveLastIDs(); err != nil {
+			return err
+		}
+	}
+
+	removed := make(map[uint64]bool)
+
+	if maxAge > 0 {
+		cutoff := time.Now().Add(-maxAge)
+		segments := s.segmentEntries()
+		moved := make(map[uint64]map[int64]int64)
+
+		for _, seg := range s.segments[:len(s.segments)-1] {
+			entries := segments[seg.seq]
+			oldest, newest := timeRange(entries)
+
+			switch {
+			case newest.Before(cutoff):
+				removed[seg.seq] = true
+			case oldest.Before(cutoff):
+				offsets, err := s.compact(seg, entries, cutoff)
+				if err != nil {
+					s.reindex(moved)
+
+					return err
+				}
+
+				moved[seg.seq] = offsets
+			}
+		}
+
+		s.reindex(moved)
+	}
+
+	if maxSize > 0 {
+		total := int64(0)
+		for _, seg := range s.segments {
+			if !removed[seg.seq] {
+				total += seg.size
+			}
+		}
+
+		for _, seg := range s.segments[:len(s.segments)-1] {
+			if total <= maxSize {
+				break
+			}
+
+			if !removed[seg.seq] {
+				removed[seg.seq] = true
+				total -= seg.size
+			}
+		}
+	}
+
+	return s.remove(removed)
+}
+
+// Close closes all the segment files.
+func (s *FileStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	s.closed = true
+
+	var errs []error
+
+	for _, seg := range s.segments {
+		if err := seg.file.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// load opens the segments and builds the index.
+func (s *FileStore) load() error {
+	if err := s.loadLastIDs(); err != nil {
+		return err
+	}
+
+	names, err := filepath.Glob(filepath.Join(s.dir, "*"+segmentExt))
+	if err != nil {
+		return fmt.Errorf("failed to list segments: %w", err)
+	}
+
+	seqs := make([]uint64, 0, len(names))
+
+	for _, name := range names {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		seqs = append(seqs, seq)
+	}
+
+	slices.Sort(seqs)
+
+	for i, seq := range seqs {
+		file, err := os.OpenFile(s.path(seq), os.O_RDWR, filePermission)
+		if err != nil {
+			return fmt.Errorf("failed to open segment: %w", err)
+		}
+
+		seg := &segment{seq: seq, file: file, size: 0}
+		s.segments = append(s.segments, seg)
+
+		if err := s.scan(seg, i == len(seqs)-1); err != nil {
+			return err
+		}
+	}
+
+	if len(s.segments) == 0 {
+		if _, err := s.createSegment(1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scan reads the records of segment into the index. the corrupt tail of the active segment
+// is a torn append, so it is cut off, but a corrupt record of a sealed segment fails the scan.
+func (s *FileStore) scan(seg *segment, active bool) error {
+	data, err := os.ReadFile(s.path(seg.seq))
+	if err != nil {
+		return fmt.Errorf("failed to read segment: %w", err)
+	}
+
+	offset := int64(0)
+
+	for offset < int64(len(data)) {
+		event, length, err := decodeRecord(data[offset:])
+		if err != nil && !active {
+			return fmt.Errorf("segment %d at offset %d: %w", seg.seq, offset, err)
+		}
+
+		if err != nil {
+			break
+		}
+
+		s.index[event.Topic] = append(s.index[event.Topic], entry{
+			id:     event.ID,
+			time:   event.Time,
+			seq:    seg.seq,
+			offset: offset,
+			length: length,
+		})
+		s.lastIDs[event.Topic] = max(s.lastIDs[event.Topic], event.ID)
+
+		offset += length
+	}
+
+	seg.size = offset
+
+	if active && offset < int64(len(data)) {
+		if err := seg.file.Truncate(offset); err != nil {
+			return fmt.Errorf("failed to cut corrupt tail of segment: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// saveLastIDs replaces the file of last IDs at once, so it is never read half written.
+func (s *FileStore) saveLastIDs() error {
+	data, err := json.Marshal(s.lastIDs)
+	if err != nil {
+		return fmt.Errorf("failed to encode last ids: %w", err)
+	}
+
+	path := filepath.Join(s.dir, lastIDsFile)
+
+	temp, err := os.OpenFile(path+tempExt, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, filePermission)
+	if err != nil {
+		return fmt.Errorf("failed to create last ids: %w", err)
+	}
+
+	if _, err := temp.Write(data); err != nil {
+		_ = temp.Close()
+
+		return fmt.Errorf("failed to write last ids: %w", err)
+	}
+
+	if err := temp.Sync(); err != nil {
+		_ = temp.Close()
+
+		return fmt.Errorf("failed to sync last ids: %w", err)
+	}
+
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("failed to close last ids: %w", err)
+	}
+
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace last ids: %w", err)
+	}
+
+	return nil
+}
+
+// loadLastIDs reads the file of last IDs, the IDs of the events are loaded on top of them.
+func (s *FileStore) loadLastIDs() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, lastIDsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to read last ids: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &s.lastIDs); err != nil {
+		return fmt.Errorf("failed to decode last ids: %w", err)
+	}
+
+	return nil
+}
+
+// compact rewrites the segment with only the entries that are not older than cutoff, zero cutoff keeps all of them.
+// it returns the new offsets of the kept entries by their previous offsets.
+func (s *FileStore) compact(seg *segment, entries []entry, cutoff time.Time) (map[int64]int64, error) {
+	temp, err := os.OpenFile(s.path(seg.seq)+tempExt, os.O_RDWR|os.O_CREATE|os.O_TRUNC, filePermission)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create compacted segment: %w", err)
+	}
+
+	offsets := make(map[int64]int64)
+	size := int64(0)
+
+	for _, e := range entries {
+		if e.time.Before(cutoff) {
+			continue
+		}
+
+		buf := make([]byte, e.length)
+		if _, err := seg.file.ReadAt(buf, e.offset); err != nil {
+			_ = temp.Close()
+
+			return nil, fmt.Errorf("failed to read event: %w", err)
+		}
+
+		if _, err := temp.WriteAt(buf, size); err != nil {
+			_ = temp.Close()
+
+			return nil, fmt.Errorf("failed to write compacted segment: %w", err)
+		}
+
+		offsets[e.offset] = size
+		size += e.length
+	}
+
+	if err := temp.Sync(); err != nil {
+		_ = temp.Close()
+
+		return nil, fmt.Errorf("failed to sync compacted segment: %w", err)
+	}
+
+	if err := os.Rename(temp.Name(), s.path(seg.seq)); err != nil {
+		_ = temp.Close()
+
+		return nil, fmt.Errorf("failed to replace compacted segment: %w", err)
+	}
+
+	_ = seg.file.Close()
+	seg.file = temp
+	seg.size = size
+
+	return offsets, nil
+}
+
+// reindex moves the index entries of the compacted segments to their new offsets by
+// one pass over the index. the entries which are not kept are removed.
+func (s *FileStore) reindex(moved map[uint64]map[int64]int64) {
+	if len(moved) == 0 {
+		return
+	}
+
+	for topic, entries := range s.index {
+		kept := entries[:0]
+
+		for _, e := range entries {
+			if offsets, ok := moved[e.seq]; ok {
+				offset, ok := offsets[e.offset]
+				if !ok {
+					continue
+				}
+
+				e.offset = offset
+			}
+
+			kept = append(kept, e)
+		}
+
+		s.index[topic] = kept
+	}
+}
+
+// remove deletes the segments and their index entries.
+func (s *FileStore) remove(removed map[uint64]bool) error {
+	if len(removed) == 0 {
+		return nil
+	}
+
+	for topic, entries := range s.index {
+		entries = slices.DeleteFunc(entries, func(e entry) bool { return removed[e.seq] })
+		if len(entries) == 0 {
+			delete(s.index, topic)
+		} else {
+			s.index[topic] = entries
+		}
+	}
+
+	var errs []error
+
+	s.segments = slices.DeleteFunc(s.segments, func(seg *segment) bool {
+		if !removed[seg.seq] {
+			return false
+		}
+
+		if err := seg.file.Close(); err != nil {
+			errs = append(errs, err)
+		}
+
+		if err := os.Remove(s.path(seg.seq)); err != nil {
+			errs = append(errs, err)
+		}
+
+		return true
+	})
+
+	return errors.Join(errs...)
+}
+
+// segmentEntries returns the index entries of each segment in the order of their offsets.
+func (s *FileStore) segmentEntries() map[uint64][]entry {
+	segments := make(map[uint64][]entry, len(s.segments))
+
+	for _, entries := range s.index {
+		for _, e := range entries {
+			segments[e.seq] = append(segments[e.seq], e)
+		}
+	}
+
+	for _, entries := range segments {
+		slices.SortFunc(entries, func(a, b entry) int { return cmp.Compare(a.offset, b.offset) })
+	}
+
+	return segments
+}
+
+// timeRange returns the time of oldest and newest entries.
+func timeRange(entries []entry) (time.Time, time.Time) {
+	var oldest, newest time.Time
+
+	for _, e := range entries {
+		if oldest.IsZero() || e.time.Before(oldest) {
+			oldest = e.time
+		}
+
+		if e.time.After(newest) {
+			newest = e.time
+		}
+	}
+
+	return oldest, newest
+}
+
+func (s *FileStore) createSegment(seq uint64) (*segment, error) {
+	file, err := os.OpenFile(s.path(seq), os.O_RDWR|os.O_CREATE|os.O_EXCL, filePermission)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create segment: %w", err)
+	}
+
+	seg := &segment{seq: seq, file: file, size: 0}
+	s.segments = append(s.segments, seg)
+
+	return seg, nil
+}
+
+func (s *FileStore) segment(seq uint64) *segment {
+	for _, seg := range s.segments {
+		if seg.seq == seq {
+			return seg
+		}
+	}
+
+	return nil
+}
+
+func (s *FileStore) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+}
+
+// encodeRecord encodes the event as:
+// payload length (4) | crc of payload (4) | id (8) | unix nano time (8) | topic length (2) | topic | data.
+func encodeRecord(event Event) []byte {
+	payloadSize := payloadMinSize + len(event.Topic) + len(event.Data)
+	record := make([]byte, headerSize+payloadSize)
+	payload := record[headerSize:]
+
+	binary.BigEndian.PutUint64(payload[0:], event.ID)
+	binary.BigEndian.PutUint64(payload[8:], uint64(event.Time.UnixNano())) //nolint:gosec
+	binary.BigEndian.PutUint16(payload[16:], uint16(len(event.Topic)))     //nolint:gosec
+	copy(payload[payloadMinSize:], event.Topic)
+	copy(payload[payloadMinSize+len(event.Topic):], event.Data)
+
+	binary.BigEndian.PutUint32(record[0:], uint32(payloadSize)) //nolint:gosec
+	binary.BigEndian.PutUint32(record[4:], crc32.Checksum(payload, crcTable))
+
+	return record
+}
+
+// decodeRecord decodes the record at the start of data and returns its length.
+func decodeRecord(data []byte) (Event, int64, error) {
+	if len(data) < headerSize {
+		return Event{}, 0, ErrCorruptRecord
+	}
+
+	payloadSize := int64(binary.BigEndian.Uint32(data[0:]))
+	if payloadSize < payloadMinSize || int64(len(data)) < headerSize+payloadSize {
+		return Event{}, 0, ErrCorruptRecord
+	}
+
+	payload := data[headerSize : headerSize+payloadSize]
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(data[4:]) {
+		return Event{}, 0, ErrCorruptRecord
+	}
+
+	topicSize := int64(binary.BigEndian.Uint16(payload[16:]))
+	if payloadMinSize+topicSize > payloadSize {
+		return Event{}, 0, ErrCorruptRecord
+	}
+
+	event := Event{
+		ID:    binary.BigEndian.Uint64(payload[0:]),
+		Time:  time.Unix(0, int64(binary.BigEndian.Uint64(payload[8:]))), //nolint:gosec
+		Topic: string(payload[payloadMinSize : payloadMinSize+topicSize]),
+		Data:  slices.Clone(payload[payloadMinSize+topicSize:]),
+	}
+
+	return event, headerSize + payloadSize, nil
+}
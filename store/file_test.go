@@ -0,0 +1,322 @@
+package store_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/snapp-incubator/qsse/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func appendEvents(t *testing.T, s store.EventStore, topic string, from, to uint64, at time.Time) {
+	t.Helper()
+
+	for id := from; id <= to; id++ {
+		require.NoError(t, s.Append(store.Event{ID: id, Topic: topic, Data: []byte("data"), Time: at}))
+	}
+}
+
+func eventIDs(events []store.Event) []uint64 {
+	ids := make([]uint64, 0, len(events))
+
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+
+	return ids
+}
+
+func TestFileStoreReadFrom(t *testing.T) {
+	s, err := store.NewFileStore(t.TempDir(), &store.FileStoreOptions{SegmentSize: 100, Sync: false})
+	require.NoError(t, err)
+
+	defer func() { _ = s.Close() }()
+
+	appendEvents(t, s, "first", 1, 10, time.Now())
+	appendEvents(t, s, "second", 1, 3, time.Now())
+
+	tests := []struct {
+		name  string
+		topic string
+		id    uint64
+		ids   []uint64
+	}{
+		{
+			name:  "all events",
+			topic: "first",
+			id:    0,
+			ids:   []uint64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+		},
+		{
+			name:  "missed events",
+			topic: "first",
+			id:    7,
+			ids:   []uint64{8, 9, 10},
+		},
+		{
+			name:  "other topic",
+			topic: "second",
+			id:    1,
+			ids:   []uint64{2, 3},
+		},
+		{
+			name:  "unknown topic",
+			topic: "third",
+			id:    0,
+			ids:   []uint64{},
+		},
+	}
+
+	for _, test := range tests {
+		testCase := test
+		t.Run(test.name, func(t *testing.T) {
+			events, err := s.ReadFrom(testCase.topic, testCase.id, time.Time{}, 0)
+			require.NoError(t, err)
+			assert.Equal(t, testCase.ids, eventIDs(events))
+		})
+	}
+}
+
+func TestFileStoreReadFromLimits(t *testing.T) {
+	s, err := store.NewFileStore(t.TempDir(), nil)
+	require.NoError(t, err)
+
+	defer func() { _ = s.Close() }()
+
+	appendEvents(t, s, "topic", 1, 3, time.Now().Add(-time.Hour))
+	appendEvents(t, s, "topic", 4, 10, time.Now())
+
+	events, err := s.ReadFrom("topic", 0, time.Now().Add(-time.Minute), 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{4, 5, 6, 7, 8, 9, 10}, eventIDs(events))
+
+	// only the latest events are read.
+	events, err = s.ReadFrom("topic", 5, time.Time{}, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{9, 10}, eventIDs(events))
+}
+
+func TestFileStoreTopicTooLong(t *testing.T) {
+	s, err := store.NewFileStore(t.TempDir(), nil)
+	require.NoError(t, err)
+
+	defer func() { _ = s.Close() }()
+
+	err = s.Append(store.Event{ID: 1, Topic: strings.Repeat("a", 1<<16), Data: nil, Time: time.Now()})
+	require.ErrorIs(t, err, store.ErrTopicTooLong)
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := store.NewFileStore(dir, &store.FileStoreOptions{SegmentSize: 100, Sync: true})
+	require.NoError(t, err)
+
+	appendEvents(t, s, "topic", 1, 5, time.Now())
+	require.NoError(t, s.Close())
+
+	s, err = store.NewFileStore(dir, nil)
+	require.NoError(t, err)
+
+	defer func() { _ = s.Close() }()
+
+	last, err := s.LastID("topic")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5), last)
+
+	events, err := s.ReadFrom("topic", 3, time.Time{}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{4, 5}, eventIDs(events))
+	assert.Equal(t, []byte("data"), events[0].Data)
+}
+
+func TestFileStoreDelete(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := store.NewFileStore(dir, &store.FileStoreOptions{SegmentSize: 100, Sync: true})
+	require.NoError(t, err)
+
+	for id := uint64(1); id <= 5; id++ {
+		appendEvents(t, s, "removed", id, id, time.Now())
+		appendEvents(t, s, "kept", id, id, time.Now())
+	}
+
+	require.NoError(t, s.Delete("removed"))
+	require.NoError(t, s.Delete("unknown"))
+
+	appendEvents(t, s, "kept", 6, 6, time.Now())
+	require.NoError(t, s.Close())
+
+	// the deleted events are not loaded again.
+	s, err = store.NewFileStore(dir, nil)
+	require.NoError(t, err)
+
+	defer func() { _ = s.Close() }()
+
+	last, err := s.LastID("removed")
+	require.NoError(t, err)
+	assert.Zero(t, last)
+
+	events, err := s.ReadFrom("removed", 0, time.Time{}, 0)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	events, err = s.ReadFrom("kept", 0, time.Time{}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 2, 3, 4, 5, 6}, eventIDs(events))
+}
+
+func TestFileStoreTornTail(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := store.NewFileStore(dir, nil)
+	require.NoError(t, err)
+
+	appendEvents(t, s, "topic", 1, 3, time.Now())
+	require.NoError(t, s.Close())
+
+	segments, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+
+	// a partially written record at the end of the segment.
+	file, err := os.OpenFile(segments[0], os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	_, err = file.Write([]byte{0, 0, 0, 40, 1, 2})
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	s, err = store.NewFileStore(dir, nil)
+	require.NoError(t, err)
+
+	defer func() { _ = s.Close() }()
+
+	appendEvents(t, s, "topic", 4, 4, time.Now())
+
+	events, err := s.ReadFrom("topic", 0, time.Time{}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 2, 3, 4}, eventIDs(events))
+}
+
+func TestFileStoreCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := store.NewFileStore(dir, nil)
+	require.NoError(t, err)
+
+	appendEvents(t, s, "topic", 1, 3, time.Now())
+
+	segments, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(segments[0])
+	require.NoError(t, err)
+
+	// flip the last byte of data in the last record.
+	data[len(data)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(segments[0], data, 0o600))
+
+	_, err = s.ReadFrom("topic", 2, time.Time{}, 0)
+	require.ErrorIs(t, err, store.ErrCorruptRecord)
+	require.NoError(t, s.Close())
+
+	s, err = store.NewFileStore(dir, nil)
+	require.NoError(t, err)
+
+	defer func() { _ = s.Close() }()
+
+	events, err := s.ReadFrom("topic", 0, time.Time{}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 2}, eventIDs(events))
+}
+
+func TestFileStoreTruncate(t *testing.T) {
+	s, err := store.NewFileStore(t.TempDir(), &store.FileStoreOptions{SegmentSize: 120, Sync: false})
+	require.NoError(t, err)
+
+	defer func() { _ = s.Close() }()
+
+	old := time.Now().Add(-time.Hour)
+
+	// each record is 35 bytes, so there are three records in each segment
+	// and the first segment is compacted because it is partially expired.
+	appendEvents(t, s, "topic", 1, 2, old)
+	appendEvents(t, s, "topic", 3, 8, time.Now())
+
+	require.NoError(t, s.Truncate(time.Minute, 0))
+
+	events, err := s.ReadFrom("topic", 0, time.Time{}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{3, 4, 5, 6, 7, 8}, eventIDs(events))
+
+	require.NoError(t, s.Truncate(0, 100))
+
+	events, err = s.ReadFrom("topic", 0, time.Time{}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{7, 8}, eventIDs(events))
+
+	last, err := s.LastID("topic")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(8), last)
+}
+
+func TestFileStoreTruncateReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := store.NewFileStore(dir, &store.FileStoreOptions{SegmentSize: 100, Sync: false})
+	require.NoError(t, err)
+
+	appendEvents(t, s, "truncated", 1, 3, time.Now().Add(-time.Hour))
+	appendEvents(t, s, "deleted", 1, 3, time.Now().Add(-time.Hour))
+	appendEvents(t, s, "kept", 1, 1, time.Now())
+
+	require.NoError(t, s.Truncate(time.Minute, 0))
+	require.NoError(t, s.Delete("deleted"))
+	require.NoError(t, s.Close())
+
+	s, err = store.NewFileStore(dir, nil)
+	require.NoError(t, err)
+
+	defer func() { _ = s.Close() }()
+
+	events, err := s.ReadFrom("truncated", 0, time.Time{}, 0)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	// the IDs of a truncated topic continue after reopening, the ones of a deleted topic restart.
+	last, err := s.LastID("truncated")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), last)
+
+	last, err = s.LastID("deleted")
+	require.NoError(t, err)
+	assert.Zero(t, last)
+}
+
+func TestFileStoreCorruptSealedSegment(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := store.NewFileStore(dir, &store.FileStoreOptions{SegmentSize: 100, Sync: false})
+	require.NoError(t, err)
+
+	appendEvents(t, s, "topic", 1, 6, time.Now())
+	require.NoError(t, s.Close())
+
+	segments, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	require.NoError(t, err)
+	require.Greater(t, len(segments), 1)
+
+	data, err := os.ReadFile(segments[0])
+	require.NoError(t, err)
+
+	data[len(data)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(segments[0], data, 0o600))
+
+	// only the tail of the active segment may be torn.
+	_, err = store.NewFileStore(dir, nil)
+	require.ErrorIs(t, err, store.ErrCorruptRecord)
+}
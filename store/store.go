@@ -0,0 +1,39 @@
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrStoreClosed   = errors.New("event store is closed")
+	ErrCorruptRecord = errors.New("event store record is corrupt")
+	ErrTopicTooLong  = errors.New("topic is too long for event store")
+)
+
+// Event is a published event of a topic.
+type Event struct {
+	ID    uint64
+	Topic string
+	Data  []byte
+	Time  time.Time
+}
+
+// EventStore keeps the history of topics, so events can be replayed to reconnecting
+// clients even after the server restarts. implementations must be safe for concurrent use.
+type EventStore interface {
+	// Append stores the event. IDs of each topic are appended in increasing order.
+	Append(event Event) error
+	// ReadFrom returns the events of topic with an ID greater than id which are not older than since
+	// from oldest to newest. only the latest limit events are returned. zero since and limit disable them.
+	ReadFrom(topic string, id uint64, since time.Time, limit int) ([]Event, error)
+	// LastID returns the ID of the latest event of topic or zero if there is no event.
+	LastID(topic string) (uint64, error)
+	// Delete removes all the events of topic, so a topic with the same name starts from zero.
+	Delete(topic string) error
+	// Truncate removes the events older than maxAge and the oldest events while the
+	// store is larger than maxSize bytes. zero disables each limit.
+	Truncate(maxAge time.Duration, maxSize int64) error
+	// Close releases the resources of store.
+	Close() error
+}
@@ -7,9 +7,9 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"math/big"
-)
 
-const protocol = "PROTOCOL_QUIC"
+	"github.com/snapp-incubator/qsse/internal"
+)
 
 // GetDefaultTLSConfig returns a tls.Config with the default settings for server.
 func GetDefaultTLSConfig() *tls.Config {
@@ -37,7 +37,7 @@ func GetDefaultTLSConfig() *tls.Config {
 
 	return &tls.Config{ //nolint:exhaustruct,gosec
 		Certificates: []tls.Certificate{tlsCert},
-		NextProtos:   []string{protocol},
+		NextProtos:   internal.Protocols(internal.SupportedVersions),
 	}
 }
 
@@ -45,6 +45,6 @@ func GetDefaultTLSConfig() *tls.Config {
 func GetSimpleTLS() *tls.Config {
 	return &tls.Config{ //nolint:exhaustruct
 		InsecureSkipVerify: true, //nolint:gosec
-		NextProtos:         []string{protocol},
+		NextProtos:         internal.Protocols(internal.SupportedVersions),
 	}
 }